@@ -0,0 +1,70 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// bloomHashes is the number of bits set in the bloom filter for each path.
+const bloomHashes = 4
+
+// BloomFilter returns a bloom filter of bits bits, rounded up to whole
+// bytes, holding every value path of the manifest. BloomMayContain never
+// reports false for a path in the manifest, but may report true for a path
+// that is not; the fewer paths per bit, the fewer such false positives.
+func (n *Node) BloomFilter(ctx context.Context, l Loader, bits int) ([]byte, error) {
+	if bits <= 0 {
+		return nil, fmt.Errorf("bloom filter size %d: %w", bits, ErrInvalidInput)
+	}
+	filter := make([]byte, (bits+7)/8)
+	err := n.WalkNode(ctx, []byte{}, l, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if node.IsValueType() {
+			for _, i := range bloomIndexes(filter, path) {
+				filter[i/8] |= 1 << (i % 8)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// BloomMayContain reports whether path may be in the manifest the filter
+// was built from. A false result is definitive; an empty filter carries no
+// information and always reports true.
+func BloomMayContain(filter, path []byte) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, i := range bloomIndexes(filter, path) {
+		if filter[i/8]&(1<<(i%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomIndexes returns the bit indexes of path in filter, derived from its
+// hash by double hashing.
+func bloomIndexes(filter, path []byte) [bloomHashes]uint64 {
+	h := sha256.Sum256(path)
+	h1 := binary.BigEndian.Uint64(h[0:8])
+	h2 := binary.BigEndian.Uint64(h[8:16])
+	m := uint64(len(filter)) * 8
+	var indexes [bloomHashes]uint64
+	for i := range indexes {
+		indexes[i] = (h1 + uint64(i)*h2) % m
+	}
+	return indexes
+}
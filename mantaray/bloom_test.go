@@ -0,0 +1,59 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestBloomFilter(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	var paths []string
+	for i := 0; i < 100; i++ {
+		paths = append(paths, fmt.Sprintf("dir%d/file%d.txt", i%7, i))
+	}
+	n := newManifest(t, ls, paths...)
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	filter, err := mantaray.NewNodeRef(n.Reference()).BloomFilter(ctx, ls, 1000)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(filter) != 125 {
+		t.Fatalf("expected filter of 125 bytes, got %d", len(filter))
+	}
+	for _, c := range paths {
+		if !mantaray.BloomMayContain(filter, []byte(c)) {
+			t.Fatalf("path %s: expected filter to contain path", c)
+		}
+	}
+
+	// with ~10 bits per path false positives are rare
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if mantaray.BloomMayContain(filter, []byte(fmt.Sprintf("missing/%d", i))) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 100 {
+		t.Fatalf("expected few false positives, got %d of 1000", falsePositives)
+	}
+
+	if !mantaray.BloomMayContain(nil, []byte("anything")) {
+		t.Fatal("expected empty filter to report possible containment")
+	}
+	_, err = n.BloomFilter(ctx, ls, 0)
+	if !errors.Is(err, mantaray.ErrInvalidInput) {
+		t.Fatalf("expected invalid input error, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// CBOR major types used by CBORCodec.
+const (
+	cborUint  byte = 0
+	cborBytes byte = 2
+	cborText  byte = 3
+	cborArray byte = 4
+	cborMap   byte = 5
+	cborTag   byte = 6
+)
+
+// cborWriter appends definite length CBOR items to buf.
+type cborWriter struct {
+	buf []byte
+}
+
+// head writes the initial byte of an item of type major with argument v in
+// its shortest form.
+func (w *cborWriter) head(major byte, v uint64) {
+	m := major << 5
+	switch {
+	case v < 24:
+		w.buf = append(w.buf, m|byte(v))
+	case v <= 0xff:
+		w.buf = append(w.buf, m|24, byte(v))
+	case v <= 0xffff:
+		w.buf = append(w.buf, m|25, 0, 0)
+		binary.BigEndian.PutUint16(w.buf[len(w.buf)-2:], uint16(v))
+	case v <= 0xffffffff:
+		w.buf = append(w.buf, m|26, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(w.buf[len(w.buf)-4:], uint32(v))
+	default:
+		w.buf = append(w.buf, m|27, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(w.buf[len(w.buf)-8:], v)
+	}
+}
+
+func (w *cborWriter) bytes(b []byte) {
+	w.head(cborBytes, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *cborWriter) text(s string) {
+	w.head(cborText, uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// cborReader reads definite length CBOR items from data. Indefinite length
+// items, floats and simple values are not supported.
+type cborReader struct {
+	data []byte
+	off  int
+}
+
+// head reads the initial byte and argument of the next item.
+func (r *cborReader) head() (major byte, v uint64, err error) {
+	if r.off >= len(r.data) {
+		return 0, 0, fmt.Errorf("cbor: %w", ErrTooShort)
+	}
+	b := r.data[r.off]
+	r.off++
+	major, info := b>>5, b&0x1f
+	if info < 24 {
+		return major, uint64(info), nil
+	}
+	var size int
+	switch info {
+	case 24:
+		size = 1
+	case 25:
+		size = 2
+	case 26:
+		size = 4
+	case 27:
+		size = 8
+	default:
+		return 0, 0, fmt.Errorf("cbor: unsupported additional information %d: %w", info, ErrInvalidInput)
+	}
+	if len(r.data)-r.off < size {
+		return 0, 0, fmt.Errorf("cbor: %w", ErrTooShort)
+	}
+	for _, c := range r.data[r.off : r.off+size] {
+		v = v<<8 | uint64(c)
+	}
+	r.off += size
+	return major, v, nil
+}
+
+// expect reads the head of an item of type major and returns its argument.
+func (r *cborReader) expect(major byte) (uint64, error) {
+	m, v, err := r.head()
+	if err != nil {
+		return 0, err
+	}
+	if m != major {
+		return 0, fmt.Errorf("cbor: major type %d, expected %d: %w", m, major, ErrInvalidInput)
+	}
+	return v, nil
+}
+
+// uint reads an unsigned integer no larger than max.
+func (r *cborReader) uint(max uint64) (uint64, error) {
+	v, err := r.expect(cborUint)
+	if err != nil {
+		return 0, err
+	}
+	if v > max {
+		return 0, fmt.Errorf("cbor: integer %d out of range: %w", v, ErrInvalidInput)
+	}
+	return v, nil
+}
+
+// raw reads the content of a byte or text string.
+func (r *cborReader) raw(major byte) ([]byte, error) {
+	size, err := r.expect(major)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(r.data)-r.off) < size {
+		return nil, fmt.Errorf("cbor: %w", ErrTooShort)
+	}
+	b := append([]byte{}, r.data[r.off:r.off+int(size)]...)
+	r.off += int(size)
+	return b, nil
+}
+
+func (r *cborReader) bytes() ([]byte, error) {
+	return r.raw(cborBytes)
+}
+
+func (r *cborReader) text() (string, error) {
+	b, err := r.raw(cborText)
+	return string(b), err
+}
@@ -0,0 +1,277 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Codec serialises single nodes for persistence. A node is marshalled after
+// its forks are saved, so fork references are available.
+type Codec interface {
+	MarshalNode(n *Node) ([]byte, error)
+	UnmarshalNode(data []byte, n *Node) error
+}
+
+// persistCodec returns the codec the node is persisted with, or nil for the
+// native format.
+func (n *Node) persistCodec() Codec {
+	if n.opts != nil && n.opts.Codec != nil {
+		return n.opts.Codec
+	}
+	return n.codec
+}
+
+// NativeCodec is the native obfuscated binary format of MarshalBinary.
+type NativeCodec struct{}
+
+// MarshalNode implements Codec.
+func (NativeCodec) MarshalNode(n *Node) ([]byte, error) {
+	return n.MarshalBinary()
+}
+
+// UnmarshalNode implements Codec.
+func (NativeCodec) UnmarshalNode(data []byte, n *Node) error {
+	return n.UnmarshalBinary(data)
+}
+
+// CBORCodec encodes nodes as CBOR (RFC 8949) for consumption outside Swarm.
+// Nodes are written unobfuscated as a map marked with the self-describe tag,
+// starting with a version string:
+//
+//	55799({
+//	  "version": "mantaray-cbor:0.1",
+//	  "key":     bytes,  // obfuscation key, kept for the native format
+//	  "type":    uint,
+//	  "refSize": uint,
+//	  "entry":   bytes,
+//	  "forks":   [{"type": uint, "prefix": bytes, "ref": bytes, ?"metadata": {text: text}}],
+//	})
+//
+// Forks are sorted by the first byte of their prefix.
+type CBORCodec struct{}
+
+const (
+	cborVersionString = "mantaray-cbor:0.1"
+	cborSelfDescribe  = 55799
+)
+
+// MarshalNode implements Codec.
+func (CBORCodec) MarshalNode(n *Node) ([]byte, error) {
+	if n.forks == nil {
+		return nil, ErrInvalidInput
+	}
+	n.ensureObfuscationKey()
+
+	keys := make([]int, 0, len(n.forks))
+	for k := range n.forks {
+		keys = append(keys, int(k))
+	}
+	sort.Ints(keys)
+
+	w := &cborWriter{}
+	w.head(cborTag, cborSelfDescribe)
+	w.head(cborMap, 6)
+	w.text("version")
+	w.text(cborVersionString)
+	w.text("key")
+	w.bytes(n.obfuscationKey)
+	w.text("type")
+	w.head(cborUint, uint64(n.nodeType))
+	w.text("refSize")
+	w.head(cborUint, uint64(n.refBytesSize))
+	w.text("entry")
+	w.bytes(n.entry)
+	w.text("forks")
+	w.head(cborArray, uint64(len(keys)))
+	for _, k := range keys {
+		f := n.forks[byte(k)]
+		ref := refBytes(f)
+		if len(ref) == 0 {
+			return nil, fmt.Errorf("fork '%x' not saved: %w", []byte{byte(k)}, ErrInvalidInput)
+		}
+		withMetadata := f.Node.IsWithMetadataType() && len(f.Node.metadata) > 0
+		if withMetadata {
+			w.head(cborMap, 4)
+		} else {
+			w.head(cborMap, 3)
+		}
+		w.text("type")
+		w.head(cborUint, uint64(f.Node.nodeType))
+		w.text("prefix")
+		w.bytes(f.prefix)
+		w.text("ref")
+		w.bytes(ref)
+		if withMetadata {
+			mkeys := make([]string, 0, len(f.Node.metadata))
+			for mk := range f.Node.metadata {
+				mkeys = append(mkeys, mk)
+			}
+			sort.Strings(mkeys)
+			w.text("metadata")
+			w.head(cborMap, uint64(len(mkeys)))
+			for _, mk := range mkeys {
+				w.text(mk)
+				w.text(f.Node.metadata[mk])
+			}
+		}
+	}
+	return w.buf, nil
+}
+
+// UnmarshalNode implements Codec. Data that does not start with the
+// self-describe tag and version string fails with ErrInvalidVersionHash.
+func (CBORCodec) UnmarshalNode(data []byte, n *Node) error {
+	r := &cborReader{data: data}
+	if tag, err := r.expect(cborTag); err != nil || tag != cborSelfDescribe {
+		return fmt.Errorf("cbor: missing self-describe tag: %w", ErrInvalidVersionHash)
+	}
+	fields, err := r.expect(cborMap)
+	if err != nil {
+		return err
+	}
+	if key, err := r.text(); err != nil || key != "version" {
+		return fmt.Errorf("cbor: missing version: %w", ErrInvalidVersionHash)
+	}
+	if version, err := r.text(); err != nil || version != cborVersionString {
+		return fmt.Errorf("cbor: version %q: %w", version, ErrInvalidVersionHash)
+	}
+
+	var forks map[byte]*fork
+	for i := uint64(1); i < fields; i++ {
+		key, err := r.text()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "key":
+			k, err := r.bytes()
+			if err != nil {
+				return err
+			}
+			if len(k) != nodeObfuscationKeySize {
+				return fmt.Errorf("cbor: obfuscation key length %d: %w", len(k), ErrInvalidInput)
+			}
+			n.obfuscationKey = k
+		case "type":
+			t, err := r.uint(0xff)
+			if err != nil {
+				return err
+			}
+			n.nodeType |= uint8(t)
+		case "refSize":
+			size, err := r.uint(0xff)
+			if err != nil {
+				return err
+			}
+			if size != 0 {
+				n.refBytesSize = int(size)
+			}
+		case "entry":
+			if n.entry, err = r.bytes(); err != nil {
+				return err
+			}
+		case "forks":
+			if forks, err = r.forks(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("cbor: unknown field %q: %w", key, ErrInvalidInput)
+		}
+	}
+	if r.off != len(data) {
+		return fmt.Errorf("cbor: %d trailing bytes: %w", len(data)-r.off, ErrInvalidInput)
+	}
+
+	if forks == nil {
+		forks = make(map[byte]*fork)
+	}
+	n.index++
+	for _, f := range forks {
+		f.index = n.index
+	}
+	if len(forks) > 0 {
+		n.makeEdge()
+	}
+	n.forks = forks
+	return nil
+}
+
+// forks reads the array of forks of a node.
+func (r *cborReader) forks() (map[byte]*fork, error) {
+	count, err := r.expect(cborArray)
+	if err != nil {
+		return nil, err
+	}
+	forks := make(map[byte]*fork)
+	for i := uint64(0); i < count; i++ {
+		fields, err := r.expect(cborMap)
+		if err != nil {
+			return nil, err
+		}
+		f := &fork{Node: &Node{}}
+		for j := uint64(0); j < fields; j++ {
+			key, err := r.text()
+			if err != nil {
+				return nil, err
+			}
+			switch key {
+			case "type":
+				t, err := r.uint(0xff)
+				if err != nil {
+					return nil, err
+				}
+				f.Node.nodeType = uint8(t)
+			case "prefix":
+				if f.prefix, err = r.bytes(); err != nil {
+					return nil, err
+				}
+			case "ref":
+				if f.Node.ref, err = r.bytes(); err != nil {
+					return nil, err
+				}
+			case "metadata":
+				if f.Node.metadata, err = r.metadata(); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, fmt.Errorf("cbor: unknown fork field %q: %w", key, ErrInvalidInput)
+			}
+		}
+		if len(f.prefix) == 0 || len(f.prefix) > nodePrefixMaxSize {
+			return nil, fmt.Errorf("cbor: invalid prefix length: %d: %w", len(f.prefix), ErrInvalidInput)
+		}
+		if len(f.Node.ref) == 0 {
+			return nil, fmt.Errorf("cbor: fork '%x' without reference: %w", f.prefix[:1], ErrInvalidInput)
+		}
+		if _, ok := forks[f.prefix[0]]; ok {
+			return nil, fmt.Errorf("cbor: duplicate fork '%x': %w", f.prefix[:1], ErrInvalidInput)
+		}
+		forks[f.prefix[0]] = f
+	}
+	return forks, nil
+}
+
+// metadata reads a map of text keys to text values.
+func (r *cborReader) metadata() (map[string]string, error) {
+	count, err := r.expect(cborMap)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]string, count)
+	for i := uint64(0); i < count; i++ {
+		k, err := r.text()
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.text()
+		if err != nil {
+			return nil, err
+		}
+		metadata[k] = v
+	}
+	return metadata, nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	meta := map[string]string{"content-type": "text/html"}
+	for _, tc := range []struct {
+		name  string
+		codec mantaray.Codec
+	}{
+		{name: "default"},
+		{name: "native", codec: mantaray.NativeCodec{}},
+		{name: "cbor", codec: mantaray.CBORCodec{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ls := newMockLoadSaver()
+			n := newManifest(t, ls, "img/1.png", "img/2.png", "robots.txt")
+			n.SetOptions(mantaray.Options{Codec: tc.codec})
+			if err := n.Add(ctx, []byte("index.html"), bytes.Repeat([]byte{1}, 32), meta, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := n.Add(ctx, []byte("empty/"), make([]byte, 32), nil, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			expected, err := n.Skeleton(ctx, ls)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := n.Save(ctx, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			n = mantaray.NewNodeRef(n.Reference())
+			n.SetOptions(mantaray.Options{Codec: tc.codec})
+			skeleton, err := n.Skeleton(ctx, ls)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(skeleton, expected) {
+				t.Fatalf("expected paths %s, got %s", expected, skeleton)
+			}
+			for _, c := range []string{"img/1.png", "img/2.png", "robots.txt"} {
+				m, err := n.Lookup(ctx, []byte(c), ls)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if e := entry(c); !bytes.Equal(m, e) {
+					t.Fatalf("expected value %x, got %x", e, m)
+				}
+			}
+			node, err := n.LookupNode(ctx, []byte("index.html"), ls)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(node.Metadata(), meta) {
+				t.Fatalf("expected metadata %v, got %v", meta, node.Metadata())
+			}
+			node, err = n.LookupNode(ctx, []byte("empty/"), ls)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !node.IsEmptyDirectory() {
+				t.Fatal("expected empty directory")
+			}
+
+			// modify and save again with the inherited codec
+			if err := n.Remove(ctx, []byte("img/2.png"), ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := n.Save(ctx, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			n = mantaray.NewNodeRef(n.Reference())
+			n.SetOptions(mantaray.Options{Codec: tc.codec})
+			if _, err := n.Lookup(ctx, []byte("img/1.png"), ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := n.Lookup(ctx, []byte("img/2.png"), ls); !errors.Is(err, mantaray.ErrNotFound) {
+				t.Fatalf("expected not found error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCBORCodec(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	save := func(paths ...string) []byte {
+		t.Helper()
+		n := mantaray.New()
+		n.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+		n.SetOptions(mantaray.Options{Codec: mantaray.CBORCodec{}})
+		for _, c := range paths {
+			if err := n.Add(ctx, []byte(c), entry(c), nil, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return n.Reference()
+	}
+	cborRef := save("img/1.png", "index.html")
+	data, err := ls.Load(ctx, cborRef, 0)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// self-describe tag followed by a map starting with the version
+	if prefix := []byte("\xd9\xd9\xf7\xa6\x67version"); !bytes.HasPrefix(data, prefix) {
+		t.Fatalf("expected data to start with %x, got %x", prefix, data[:len(prefix)])
+	}
+
+	// the encoding is deterministic
+	if ref := save("img/1.png", "index.html"); !bytes.Equal(ref, cborRef) {
+		t.Fatalf("expected reference %x, got %x", cborRef, ref)
+	}
+
+	native := newManifest(t, ls, "img/1.png", "index.html")
+	if err := native.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// reading with the wrong codec fails on the version
+	for _, tc := range []struct {
+		name  string
+		ref   []byte
+		codec mantaray.Codec
+	}{
+		{name: "cbor-as-native", ref: cborRef},
+		{name: "native-as-cbor", ref: native.Reference(), codec: mantaray.CBORCodec{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := mantaray.NewNodeRef(tc.ref)
+			n.SetOptions(mantaray.Options{Codec: tc.codec})
+			_, err := n.Lookup(ctx, []byte("index.html"), ls)
+			if !errors.Is(err, mantaray.ErrInvalidVersionHash) {
+				t.Fatalf("expected invalid version hash error, got %v", err)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: []byte{}},
+		{name: "truncated", data: data[:len(data)-1]},
+		{name: "trailing", data: append(append([]byte{}, data...), 0)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := (mantaray.CBORCodec{}).UnmarshalNode(tc.data, &mantaray.Node{}); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
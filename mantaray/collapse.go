@@ -0,0 +1,107 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+)
+
+// Collapse merges every fork leading to a node that holds nothing but a
+// single fork into one fork with the joined prefix, as long as the joined
+// prefix fits nodePrefixMaxSize, and returns the number of merges. Such
+// chains are left behind, for example, when the value of a node with a
+// single child is removed. Lookups are unaffected.
+func (n *Node) Collapse(ctx context.Context, ls LoadSaver) (merged int, err error) {
+	err = n.collapse(ctx, ls, &merged)
+	return merged, err
+}
+
+func (n *Node) collapse(ctx context.Context, ls LoadSaver, merged *int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, ls); err != nil {
+			return err
+		}
+	}
+	for k, f := range n.forks {
+		if err := f.Node.collapse(ctx, ls, merged); err != nil {
+			return err
+		}
+		for len(f.forks) == 1 && !f.IsValueType() && !f.IsEmptyDirectory() && !f.IsWithMetadataType() {
+			var ff *fork
+			for _, child := range f.forks {
+				ff = child
+			}
+			if len(f.prefix)+len(ff.prefix) > nodePrefixMaxSize {
+				break
+			}
+			prefix := make([]byte, 0, len(f.prefix)+len(ff.prefix))
+			prefix = append(prefix, f.prefix...)
+			prefix = append(prefix, ff.prefix...)
+			ff.Node.updateIsWithPathSeparator(prefix)
+			f = &fork{prefix, ff.Node}
+			n.forks[k] = f
+			n.reborn()
+			*merged++
+		}
+	}
+	return nil
+}
+
+// RepairSeparatorBits recomputes the path separator type of every node and
+// returns the number of nodes whose type was wrong. Add sets the type from
+// the rest of the path being added when it passes a node, so a node must be
+// marked if its fork prefix holds a separator, and may be marked only if
+// some path through it does. Other types are repaired: they are cleared if no
+// path through the node holds a separator and set if its prefix holds one.
+// A manifest built by Add needs no repair. Repaired nodes and their ancestors
+// are reborn, so the manifest has to be saved again for the repair to
+// persist.
+func (n *Node) RepairSeparatorBits(ctx context.Context, ls LoadSaver) (fixed int, err error) {
+	_, _, err = n.repairSeparatorBits(ctx, ls, &fixed)
+	return fixed, err
+}
+
+// repairSeparatorBits repairs the types of the nodes below n and reports
+// whether any of them changed and whether any fork prefix below n holds a
+// separator.
+func (n *Node) repairSeparatorBits(ctx context.Context, ls LoadSaver, fixed *int) (changed, separator bool, err error) {
+	select {
+	case <-ctx.Done():
+		return false, false, ctx.Err()
+	default:
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, ls); err != nil {
+			return false, false, err
+		}
+	}
+	for _, f := range n.forks {
+		c, below, err := f.Node.repairSeparatorBits(ctx, ls, fixed)
+		if err != nil {
+			return false, false, err
+		}
+		// as in updateIsWithPathSeparator, a separator leading the prefix
+		// does not count for the node itself
+		inPrefix := bytes.IndexByte(f.prefix, PathSeparator) > 0
+		if set := f.IsWithPathSeparatorType(); set && !inPrefix && !below || !set && inPrefix {
+			f.updateIsWithPathSeparator(f.prefix)
+			f.Node.reborn()
+			*fixed++
+			c = true
+		}
+		changed = changed || c
+		separator = separator || below || bytes.IndexByte(f.prefix, PathSeparator) >= 0
+	}
+	if changed {
+		n.reborn()
+	}
+	return changed, separator, nil
+}
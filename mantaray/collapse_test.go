@@ -0,0 +1,182 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestCollapse(t *testing.T) {
+	ctx := context.Background()
+	build := func(paths ...string) *mantaray.Node {
+		n := mantaray.New()
+		n.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+		for _, c := range paths {
+			e := entry(c)
+			if err := n.Add(ctx, []byte(c), e, nil, nil); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		return n
+	}
+
+	// removing the values of nodes with a single child leaves chains
+	n := build("ab", "abcdef", "index.html", "xy", "xyzzy")
+	for _, c := range []string{"ab", "xy"} {
+		if err := n.Remove(ctx, []byte(c), nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	merged, err := n.Collapse(ctx, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if merged != 2 {
+		t.Fatalf("expected 2 merges, got %d", merged)
+	}
+	for _, c := range []string{"abcdef", "index.html", "xyzzy"} {
+		m, err := n.Lookup(ctx, []byte(c), nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		e := entry(c)
+		if !bytes.Equal(m, e) {
+			t.Fatalf("expected value %x, got %x", e, m)
+		}
+	}
+
+	ls := newMockLoadSaver()
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := build("abcdef", "index.html", "xyzzy")
+	if err := expected.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(n.Reference(), expected.Reference()) {
+		t.Fatalf("expected reference %x, got %x", expected.Reference(), n.Reference())
+	}
+
+	merged, err = mantaray.NewNodeRef(n.Reference()).Collapse(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if merged != 0 {
+		t.Fatalf("expected no merges, got %d", merged)
+	}
+}
+
+func TestRepairSeparatorBits(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	build := func() *mantaray.Node {
+		n := mantaray.New()
+		n.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+		for _, c := range []string{"img/1.png", "img/2.png", "robots.txt"} {
+			e := entry(c)
+			if err := n.Add(ctx, []byte(c), e, nil, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		return n
+	}
+	n := build()
+
+	// flip the separator type of a directory and of a file
+	for _, c := range []string{"img/", "robots.txt"} {
+		node, err := n.LookupNode(ctx, []byte(c), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		node.SetNodeType(node.NodeType() ^ 8)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	broken := n.Reference()
+
+	n = mantaray.NewNodeRef(broken)
+	fixed, err := n.RepairSeparatorBits(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if fixed != 2 {
+		t.Fatalf("expected 2 repairs, got %d", fixed)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if bytes.Equal(n.Reference(), broken) {
+		t.Fatal("expected repair to change the reference")
+	}
+	expected := build()
+	if err := expected.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(n.Reference(), expected.Reference()) {
+		t.Fatalf("expected reference %x, got %x", expected.Reference(), n.Reference())
+	}
+
+	n = mantaray.NewNodeRef(n.Reference())
+	for c, sep := range map[string]bool{"img/": true, "robots.txt": false} {
+		node, err := n.LookupNode(ctx, []byte(c), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if node.IsWithPathSeparatorType() != sep {
+			t.Fatalf("path '%s': expected separator type %v", c, sep)
+		}
+	}
+	fixed, err = n.RepairSeparatorBits(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if fixed != 0 {
+		t.Fatalf("expected no repairs, got %d", fixed)
+	}
+}
+
+func TestRepairSeparatorBitsFresh(t *testing.T) {
+	ctx := context.Background()
+	for _, paths := range [][]string{
+		{"abc", "ab/x"},
+		{"ab/x", "abc"},
+		{"img/1.png", "img/2.png", "img.png", "robots.txt"},
+		{"a/b/c", "a/bc", "a/b/d/e", "a/", "ab"},
+	} {
+		ls := newMockLoadSaver()
+		n := mantaray.New()
+		n.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+		for _, c := range paths {
+			e := entry(c)
+			if err := n.Add(ctx, []byte(c), e, nil, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		ref := n.Reference()
+
+		n = mantaray.NewNodeRef(ref)
+		fixed, err := n.RepairSeparatorBits(ctx, ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if fixed != 0 {
+			t.Fatalf("paths %q: expected no repairs, got %d", paths, fixed)
+		}
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal(n.Reference(), ref) {
+			t.Fatalf("paths %q: expected reference %x, got %x", paths, ref, n.Reference())
+		}
+	}
+}
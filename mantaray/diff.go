@@ -0,0 +1,310 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// IsSubset reports whether every value path of a exists in b with the same
+// entry, returning the sorted paths of a that are missing or differ in b.
+// Subtrees with equal references in both manifests are not descended.
+func IsSubset(ctx context.Context, a, b *Node, l Loader) (bool, [][]byte, error) {
+	var missing [][]byte
+	if err := subset(ctx, []byte{}, a, b, b, l, sameEntry, &missing); err != nil {
+		return false, nil, err
+	}
+	return len(missing) == 0, missing, nil
+}
+
+// PathDiff returns the sorted value paths present only in a and only in b,
+// ignoring entry changes on paths present in both. Subtrees with equal
+// references in both manifests are not descended.
+func PathDiff(ctx context.Context, a, b *Node, l Loader) (onlyA [][]byte, onlyB [][]byte, err error) {
+	if err := subset(ctx, []byte{}, a, b, b, l, bothValues, &onlyA); err != nil {
+		return nil, nil, err
+	}
+	if err := subset(ctx, []byte{}, b, a, a, l, bothValues, &onlyB); err != nil {
+		return nil, nil, err
+	}
+	return onlyA, onlyB, nil
+}
+
+// EntryChanges opens the manifests with references oldRef and newRef and
+// returns the sorted value paths present in both whose entries differ. Paths
+// only present in one of them are not reported. Subtrees with equal
+// references are not loaded.
+func EntryChanges(ctx context.Context, oldRef, newRef []byte, l Loader) ([][]byte, error) {
+	newRoot := NewNodeRef(newRef)
+	var changed [][]byte
+	if err := subset(ctx, []byte{}, NewNodeRef(oldRef), newRoot, newRoot, l, unchangedEntry, &changed); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// sameEntry reports whether other is a value node with the entry of a.
+func sameEntry(a, other *Node) bool {
+	return other != nil && other.IsValueType() && bytes.Equal(a.entry, other.entry)
+}
+
+// unchangedEntry reports whether other is missing, not a value node or has
+// the entry of a.
+func unchangedEntry(a, other *Node) bool {
+	return other == nil || !other.IsValueType() || bytes.Equal(a.entry, other.entry)
+}
+
+// bothValues reports whether other is a value node.
+func bothValues(_, other *Node) bool {
+	return other != nil && other.IsValueType()
+}
+
+// subset compares the subtree of a on path against b, the node on the same
+// path in root if the structures line up so far, or nil otherwise, in which
+// case values are looked up from root. Value paths of a for which match
+// fails are appended to missing.
+func subset(ctx context.Context, path []byte, a, b, root *Node, l Loader, match func(a, other *Node) bool, missing *[][]byte) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	// as in diffNodes, equal references do not cover the type and metadata
+	// of the node itself
+	if b != nil && a.ref != nil && bytes.Equal(a.ref, b.ref) && (len(path) == 0 ||
+		a.IsValueType() == b.IsValueType() && equalMetadata(a.metadata, b.metadata)) {
+		return nil
+	}
+	if a.forks == nil {
+		if err := a.load(ctx, l); err != nil {
+			return err
+		}
+	}
+	if a.IsValueType() && len(path) > 0 {
+		other := b
+		if other == nil {
+			node, err := root.lookupNode(ctx, path, l)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+			other = node
+		}
+		if !match(a, other) {
+			*missing = append(*missing, path)
+		}
+	}
+	if b != nil && b.forks == nil {
+		if err := b.load(ctx, l); err != nil {
+			return err
+		}
+	}
+	for _, k := range sortedForkKeys(a) {
+		f := a.forks[k]
+		childPath := append(append([]byte{}, path...), f.prefix...)
+		var bChild *Node
+		if b != nil {
+			if bf := b.forks[k]; bf != nil && bytes.Equal(bf.prefix, f.prefix) {
+				bChild = bf.Node
+			}
+		}
+		if err := subset(ctx, childPath, f.Node, bChild, root, l, match, missing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiffType classifies a DiffEntry.
+type DiffType int
+
+const (
+	DiffAdded    DiffType = iota // path only in the second manifest
+	DiffRemoved                  // path only in the first manifest
+	DiffModified                 // path in both with different entries
+)
+
+func (t DiffType) String() string {
+	switch t {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffModified:
+		return "modified"
+	default:
+		return fmt.Sprintf("DiffType(%d)", int(t))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t DiffType) MarshalText() ([]byte, error) {
+	switch t {
+	case DiffAdded, DiffRemoved, DiffModified:
+		return []byte(t.String()), nil
+	default:
+		return nil, fmt.Errorf("diff type %d: %w", int(t), ErrInvalidInput)
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *DiffType) UnmarshalText(text []byte) error {
+	for _, typ := range []DiffType{DiffAdded, DiffRemoved, DiffModified} {
+		if string(text) == typ.String() {
+			*t = typ
+			return nil
+		}
+	}
+	return fmt.Errorf("diff type %q: %w", text, ErrInvalidInput)
+}
+
+// DiffEntry is a value path that differs between two manifests. Old is nil
+// for added paths and New is nil for removed ones.
+type DiffEntry struct {
+	Path []byte
+	Type DiffType
+	Old  []byte
+	New  []byte
+}
+
+// Diff returns the value paths that differ between a and b in sorted order.
+// Only entries are compared; metadata changes are not reported.
+func Diff(ctx context.Context, a, b *Node, l Loader) ([]DiffEntry, error) {
+	var entries []DiffEntry
+	err := DiffStream(ctx, a, b, l, func(e DiffEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// DiffStream calls fn for every value path that differs between a and b, in
+// sorted order, as the tandem walk finds it. An error returned by fn aborts
+// the diff and is returned. Subtrees with equal references in both
+// manifests are not descended.
+func DiffStream(ctx context.Context, a, b *Node, l Loader, fn func(DiffEntry) error) error {
+	return diffNodes(ctx, []byte{}, a, b, l, false, func(e DiffEntry, _, _ *Node) error {
+		return fn(e)
+	})
+}
+
+// diffFunc receives a differing value path along with its value nodes in
+// both manifests, nil where it is missing.
+type diffFunc func(e DiffEntry, from, to *Node) error
+
+// diffNodes compares the nodes of a and b reached on path. If metadata is
+// set, values with equal entries but different metadata are reported as
+// modified.
+func diffNodes(ctx context.Context, path []byte, a, b *Node, l Loader, metadata bool, fn diffFunc) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	// the type and metadata of a node are stored in its parent, so equal
+	// references only mean equal subtrees below the node itself
+	if a.ref != nil && bytes.Equal(a.ref, b.ref) && (len(path) == 0 ||
+		a.IsValueType() == b.IsValueType() && (!metadata || equalMetadata(a.metadata, b.metadata))) {
+		return nil
+	}
+	for _, n := range []*Node{a, b} {
+		if n.forks == nil {
+			if err := n.load(ctx, l); err != nil {
+				return err
+			}
+		}
+	}
+	if len(path) > 0 {
+		var err error
+		switch {
+		case a.IsValueType() && b.IsValueType():
+			if !bytes.Equal(a.entry, b.entry) || metadata && !equalMetadata(a.metadata, b.metadata) {
+				err = fn(DiffEntry{Path: path, Type: DiffModified, Old: copyBytes(a.entry), New: copyBytes(b.entry)}, a, b)
+			}
+		case a.IsValueType():
+			err = fn(DiffEntry{Path: path, Type: DiffRemoved, Old: copyBytes(a.entry)}, a, nil)
+		case b.IsValueType():
+			err = fn(DiffEntry{Path: path, Type: DiffAdded, New: copyBytes(b.entry)}, nil, b)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	keys := sortedForkKeys(a)
+	for _, k := range sortedForkKeys(b) {
+		if a.forks[k] == nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, k := range keys {
+		fa, fb := a.forks[k], b.forks[k]
+		var err error
+		switch {
+		case fb == nil:
+			err = diffOneSided(ctx, append(copyBytes(path), fa.prefix...), fa.Node, DiffRemoved, l, fn)
+		case fa == nil:
+			err = diffOneSided(ctx, append(copyBytes(path), fb.prefix...), fb.Node, DiffAdded, l, fn)
+		default:
+			// line the forks up on their common prefix, standing in for the
+			// side with the longer prefix with a node forking into the rest
+			c := common(fa.prefix, fb.prefix)
+			na, nb := fa.Node, fb.Node
+			if len(c) < len(fa.prefix) {
+				na = &Node{forks: map[byte]*fork{fa.prefix[len(c)]: {fa.prefix[len(c):], fa.Node}}}
+			}
+			if len(c) < len(fb.prefix) {
+				nb = &Node{forks: map[byte]*fork{fb.prefix[len(c)]: {fb.prefix[len(c):], fb.Node}}}
+			}
+			err = diffNodes(ctx, append(copyBytes(path), c...), na, nb, l, metadata, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffOneSided reports every value under n, reached on path, as typ.
+func diffOneSided(ctx context.Context, path []byte, n *Node, typ DiffType, l Loader, fn diffFunc) error {
+	return walkNode(ctx, path, l, n, func(p []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if !node.IsValueType() {
+			return nil
+		}
+		if typ == DiffRemoved {
+			return fn(DiffEntry{Path: p, Type: typ, Old: copyBytes(node.entry)}, node, nil)
+		}
+		return fn(DiffEntry{Path: p, Type: typ, New: copyBytes(node.entry)}, nil, node)
+	})
+}
+
+// equalMetadata reports whether a and b hold the same keys and values,
+// treating nil and empty maps alike.
+func equalMetadata(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,420 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+// entry returns the entry the test manifests store on path.
+func entry(path string) []byte {
+	return append(make([]byte, 32-len(path)), path...)
+}
+
+// pathStrings converts paths for newManifest.
+func pathStrings(paths [][]byte) []string {
+	s := make([]string, len(paths))
+	for i, p := range paths {
+		s[i] = string(p)
+	}
+	return s
+}
+
+func newManifest(t *testing.T, ls mantaray.LoadSaver, paths ...string) *mantaray.Node {
+	t.Helper()
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, c := range paths {
+		if err := n.Add(ctx, []byte(c), entry(c), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	return n
+}
+
+func TestIsSubset(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name     string
+		a, b     []string
+		expected [][]byte
+	}{
+		{
+			name: "proper-subset",
+			a:    []string{"img/1.png", "index.html"},
+			b:    []string{"img/1.png", "img/2.png", "index.html", "robots.txt"},
+		},
+		{
+			name: "equal",
+			a:    []string{"img/1.png", "img/2.png", "index.html"},
+			b:    []string{"index.html", "img/2.png", "img/1.png"},
+		},
+		{
+			name: "disjoint",
+			a:    []string{"img/1.png", "index.html"},
+			b:    []string{"css/main.css", "robots.txt"},
+			expected: [][]byte{
+				[]byte("img/1.png"),
+				[]byte("index.html"),
+			},
+		},
+		{
+			name: "superset",
+			a:    []string{"img/1.png", "img/2.png", "index.html"},
+			b:    []string{"img/1.png", "index.html"},
+			expected: [][]byte{
+				[]byte("img/2.png"),
+			},
+		},
+		{
+			name: "different-structure",
+			a:    []string{"img/1.png", "img/2.png"},
+			b:    []string{"img/1.png", "img/2.png", "img/10.png", "i.html"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ls := newMockLoadSaver()
+			a := newManifest(t, ls, tc.a...)
+			b := newManifest(t, ls, tc.b...)
+			ok, missing, err := mantaray.IsSubset(ctx, a, b, ls)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if ok != (len(tc.expected) == 0) {
+				t.Fatalf("expected subset %t, got %t", len(tc.expected) == 0, ok)
+			}
+			if !reflect.DeepEqual(missing, tc.expected) {
+				t.Fatalf("expected missing %s, got %s", tc.expected, missing)
+			}
+		})
+	}
+
+	t.Run("differing-entry", func(t *testing.T) {
+		ls := newMockLoadSaver()
+		a := newManifest(t, ls, "img/1.png", "index.html")
+		b := newManifest(t, ls, "img/1.png")
+		if err := b.Add(ctx, []byte("index.html"), bytes.Repeat([]byte{1}, 32), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		ok, missing, err := mantaray.IsSubset(ctx, a, b, ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		expected := [][]byte{[]byte("index.html")}
+		if ok || !reflect.DeepEqual(missing, expected) {
+			t.Fatalf("expected missing %s, got %t %s", expected, ok, missing)
+		}
+	})
+
+	t.Run("equal-references", func(t *testing.T) {
+		ls := newMockLoadSaver()
+		a := newManifest(t, ls, "img/1.png", "index.html")
+		if err := a.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		cl := &countingLoader{LoadSaver: ls}
+		ok, _, err := mantaray.IsSubset(ctx, mantaray.NewNodeRef(a.Reference()), mantaray.NewNodeRef(a.Reference()), cl)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !ok {
+			t.Fatal("expected subset")
+		}
+		if cl.loads != 0 {
+			t.Fatalf("expected no loads, got %d", cl.loads)
+		}
+	})
+
+	t.Run("equal-reference-removed-value", func(t *testing.T) {
+		ls := newMockLoadSaver()
+		a := newManifest(t, ls, "ab", "abc")
+		if err := a.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		// removing the value of a node with forks keeps its reference
+		b := mantaray.NewNodeRef(a.Reference())
+		if err := b.Remove(ctx, []byte("ab"), ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := b.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		ok, missing, err := mantaray.IsSubset(ctx, mantaray.NewNodeRef(a.Reference()), mantaray.NewNodeRef(b.Reference()), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		expected := [][]byte{[]byte("ab")}
+		if ok || !reflect.DeepEqual(missing, expected) {
+			t.Fatalf("expected missing %s, got %t %s", expected, ok, missing)
+		}
+		onlyA, _, err := mantaray.PathDiff(ctx, mantaray.NewNodeRef(a.Reference()), mantaray.NewNodeRef(b.Reference()), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(onlyA, expected) {
+			t.Fatalf("expected only in a %s, got %s", expected, onlyA)
+		}
+	})
+}
+
+func TestPathDiff(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name         string
+		a, b         []string
+		onlyA, onlyB [][]byte
+	}{
+		{
+			name: "overlapping",
+			a:    []string{"img/1.png", "img/2.png", "index.html"},
+			b:    []string{"img/1.png", "img/10.png", "index.html", "robots.txt"},
+			onlyA: [][]byte{
+				[]byte("img/2.png"),
+			},
+			onlyB: [][]byte{
+				[]byte("img/10.png"),
+				[]byte("robots.txt"),
+			},
+		},
+		{
+			name: "disjoint",
+			a:    []string{"img/1.png", "index.html"},
+			b:    []string{"css/main.css", "robots.txt"},
+			onlyA: [][]byte{
+				[]byte("img/1.png"),
+				[]byte("index.html"),
+			},
+			onlyB: [][]byte{
+				[]byte("css/main.css"),
+				[]byte("robots.txt"),
+			},
+		},
+		{
+			name: "equal",
+			a:    []string{"img/1.png", "index.html"},
+			b:    []string{"index.html", "img/1.png"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ls := newMockLoadSaver()
+			a := newManifest(t, ls, tc.a...)
+			b := newManifest(t, ls, tc.b...)
+			onlyA, onlyB, err := mantaray.PathDiff(ctx, a, b, ls)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(onlyA, tc.onlyA) {
+				t.Fatalf("expected only in a %s, got %s", tc.onlyA, onlyA)
+			}
+			if !reflect.DeepEqual(onlyB, tc.onlyB) {
+				t.Fatalf("expected only in b %s, got %s", tc.onlyB, onlyB)
+			}
+		})
+	}
+
+	t.Run("changed-entry", func(t *testing.T) {
+		ls := newMockLoadSaver()
+		a := newManifest(t, ls, "index.html")
+		b := mantaray.New()
+		if err := b.Add(ctx, []byte("index.html"), bytes.Repeat([]byte{1}, 32), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		onlyA, onlyB, err := mantaray.PathDiff(ctx, a, b, ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(onlyA) != 0 || len(onlyB) != 0 {
+			t.Fatalf("expected no differences, got %s and %s", onlyA, onlyB)
+		}
+	})
+
+	t.Run("equal-references", func(t *testing.T) {
+		ls := newMockLoadSaver()
+		a := newManifest(t, ls, "img/1.png", "index.html")
+		if err := a.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		cl := &countingLoader{LoadSaver: ls}
+		_, _, err := mantaray.PathDiff(ctx, mantaray.NewNodeRef(a.Reference()), mantaray.NewNodeRef(a.Reference()), cl)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if cl.loads != 0 {
+			t.Fatalf("expected no loads, got %d", cl.loads)
+		}
+	})
+}
+
+func TestEntryChanges(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	paths := []string{
+		"css/main.css",
+		"img/icons/large/home.png",
+		"img/icons/small/home.png",
+		"img/logo.png",
+		"index.html",
+	}
+	n := newManifest(t, ls, paths...)
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	oldRef := n.Reference()
+
+	n = mantaray.NewNodeRef(oldRef)
+	if err := n.LoadAll(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Add(ctx, []byte("img/icons/small/home.png"), bytes.Repeat([]byte{1}, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// added and removed paths are not entry changes
+	if err := n.Add(ctx, []byte("robots.txt"), bytes.Repeat([]byte{2}, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Remove(ctx, []byte("css/main.css"), ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	cl := &countingLoader{LoadSaver: ls}
+	changed, err := mantaray.EntryChanges(ctx, oldRef, n.Reference(), cl)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := [][]byte{[]byte("img/icons/small/home.png")}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Fatalf("expected changes %s, got %s", expected, changed)
+	}
+
+	// unchanged subtrees are skipped
+	all := &countingLoader{LoadSaver: ls}
+	if err := mantaray.NewNodeRef(oldRef).LoadAll(ctx, all); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := mantaray.NewNodeRef(n.Reference()).LoadAll(ctx, all); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cl.loads >= all.loads {
+		t.Fatalf("expected fewer than %d loads, got %d", all.loads, cl.loads)
+	}
+
+	changed, err = mantaray.EntryChanges(ctx, oldRef, oldRef, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(changed) != 0 {
+		t.Fatalf("expected no changes, got %s", changed)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	a := newManifest(t, ls, "css/main.css", "img/1.png", "img/2.png", "index.html", "robots.txt")
+	b := newManifest(t, ls, "img/1.png", "img/10.png", "img/2.png", "index.htm", "index.html", "zz.txt")
+	if err := b.Add(ctx, []byte("robots.txt"), bytes.Repeat([]byte{1}, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, n := range []*mantaray.Node{a, b} {
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	a, b = mantaray.NewNodeRef(a.Reference()), mantaray.NewNodeRef(b.Reference())
+
+	expected := []mantaray.DiffEntry{
+		{Path: []byte("css/main.css"), Type: mantaray.DiffRemoved, Old: entry("css/main.css")},
+		{Path: []byte("img/10.png"), Type: mantaray.DiffAdded, New: entry("img/10.png")},
+		{Path: []byte("index.htm"), Type: mantaray.DiffAdded, New: entry("index.htm")},
+		{Path: []byte("robots.txt"), Type: mantaray.DiffModified, Old: entry("robots.txt"), New: bytes.Repeat([]byte{1}, 32)},
+		{Path: []byte("zz.txt"), Type: mantaray.DiffAdded, New: entry("zz.txt")},
+	}
+	diff, err := mantaray.Diff(ctx, a, b, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Fatalf("expected diff %v, got %v", expected, diff)
+	}
+
+	diff, err = mantaray.Diff(ctx, a, a, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(diff) != 0 {
+		t.Fatalf("expected no diff, got %v", diff)
+	}
+}
+
+func TestDiffStream(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	a := newManifest(t, ls, "a/b/c.txt", "a/b/d.txt", "docs/readme.md", "img/1.png", "index.html")
+	b := newManifest(t, ls, "a/bc.txt", "a/b/d.txt", "docs/", "img/1.png", "img/2.png", "index.html.bak")
+	for _, n := range []*mantaray.Node{a, b} {
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	batch, err := mantaray.Diff(ctx, mantaray.NewNodeRef(a.Reference()), mantaray.NewNodeRef(b.Reference()), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(batch) == 0 {
+		t.Fatal("expected differences")
+	}
+	var streamed []mantaray.DiffEntry
+	err = mantaray.DiffStream(ctx, mantaray.NewNodeRef(a.Reference()), mantaray.NewNodeRef(b.Reference()), ls, func(e mantaray.DiffEntry) error {
+		streamed = append(streamed, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(streamed, batch) {
+		t.Fatalf("expected streamed %v, got %v", batch, streamed)
+	}
+
+	// the diff agrees with the presence-only comparison
+	onlyA, onlyB, err := mantaray.PathDiff(ctx, mantaray.NewNodeRef(a.Reference()), mantaray.NewNodeRef(b.Reference()), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var removed, added [][]byte
+	for _, e := range streamed {
+		switch e.Type {
+		case mantaray.DiffRemoved:
+			removed = append(removed, e.Path)
+		case mantaray.DiffAdded:
+			added = append(added, e.Path)
+		}
+	}
+	if !reflect.DeepEqual(removed, onlyA) || !reflect.DeepEqual(added, onlyB) {
+		t.Fatalf("expected removed %s and added %s, got %s and %s", onlyA, onlyB, removed, added)
+	}
+
+	// an error from fn aborts the diff
+	calls := 0
+	err = mantaray.DiffStream(ctx, mantaray.NewNodeRef(a.Reference()), mantaray.NewNodeRef(b.Reference()), ls, func(e mantaray.DiffEntry) error {
+		calls++
+		return errInjected
+	})
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 call, got %d", calls)
+	}
+}
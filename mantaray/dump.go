@@ -0,0 +1,80 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// dumpEntrySize is the number of entry bytes shown by Dump.
+const dumpEntrySize = 4
+
+// Dump returns a tree(1) style diagram of the trie for debugging. Each line
+// shows the quoted fork prefix, the node type as [VEDM] flags for value,
+// edge, empty directory and metadata, with a dash for unset flags, and the
+// leading bytes of the entry of value nodes.
+func (n *Node) Dump(ctx context.Context, l Loader) (string, error) {
+	var b strings.Builder
+	if err := n.dump(ctx, l, &b, ".", ""); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (n *Node) dump(ctx context.Context, l Loader, b *strings.Builder, label, indent string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, l); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(b, "%s [%s]", label, n.typeFlags())
+	if n.IsValueType() && len(n.entry) > 0 {
+		size := dumpEntrySize
+		if len(n.entry) < size {
+			size = len(n.entry)
+		}
+		fmt.Fprintf(b, " %s…", hex.EncodeToString(n.entry[:size]))
+	}
+	b.WriteString("\n")
+
+	keys := sortedForkKeys(n)
+	for i, k := range keys {
+		f := n.forks[k]
+		connector, childIndent := "├── ", "│   "
+		if i == len(keys)-1 {
+			connector, childIndent = "└── ", "    "
+		}
+		err := f.Node.dump(ctx, l, b, indent+connector+fmt.Sprintf("%q", f.prefix), indent+childIndent)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Node) typeFlags() string {
+	flags := []byte("----")
+	if n.IsValueType() {
+		flags[0] = 'V'
+	}
+	if n.IsEdgeType() {
+		flags[1] = 'E'
+	}
+	if n.IsEmptyDirectory() {
+		flags[2] = 'D'
+	}
+	if n.IsWithMetadataType() {
+		flags[3] = 'M'
+	}
+	return string(flags)
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestDump(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, e := range []mantaray.NodeEntry{
+		{Path: []byte("css/")},
+		{Path: []byte("img/1.png")},
+		{Path: []byte("img/2/test1.png")},
+		{Path: []byte("img/2/test2.png")},
+		{Path: []byte("index.html"), Metadata: map[string]string{"Content-Type": "text/html"}},
+		{Path: []byte("robots.txt")},
+	} {
+		entry := sha256.Sum256(e.Path)
+		if e.Path[len(e.Path)-1] == mantaray.PathSeparator {
+			// empty directory
+			entry = [32]byte{}
+		}
+		err := n.Add(ctx, e.Path, entry[:], e.Metadata, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	ls := newMockLoadSaver()
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	dump, err := mantaray.NewNodeRef(n.Reference()).Dump(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	golden, err := ioutil.ReadFile("testdata/dump.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dump != string(golden) {
+		t.Fatalf("dump mismatch, expected:\n%s\ngot:\n%s", golden, dump)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"context"
+	"fmt"
+)
+
+// MapEntries replaces the entry of every value node with the result of fn,
+// stopping at the first error. New entries must have the reference size of
+// the manifest, otherwise ErrInvalidEntrySize is returned.
+func (n *Node) MapEntries(ctx context.Context, fn func(path, oldEntry []byte) (newEntry []byte, err error), ls LoadSaver) error {
+	var paths [][]byte
+	err := n.WalkNode(ctx, []byte{}, ls, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if node.IsValueType() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		spine, err := n.lookupSpine(ctx, path, ls)
+		if err != nil {
+			return err
+		}
+		node := spine[len(spine)-1]
+		entry, err := fn(path, append([]byte{}, node.entry...))
+		if err != nil {
+			return err
+		}
+		if len(entry) != n.refBytesSize {
+			return fmt.Errorf("path '%s': entry size %d, expected %d: %w", path, len(entry), n.refBytesSize, ErrInvalidEntrySize)
+		}
+		node.entry = append([]byte{}, entry...)
+		rebornSpine(spine)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestMapEntries(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	paths := []string{"img/1.png", "img/2.png", "index.html"}
+	n := newManifest(t, ls, paths...)
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	oldRef := n.Reference()
+
+	n = mantaray.NewNodeRef(oldRef)
+	invert := func(path, old []byte) ([]byte, error) {
+		e := make([]byte, len(old))
+		for i, b := range old {
+			e[i] = ^b
+		}
+		return e, nil
+	}
+	if err := n.MapEntries(ctx, invert, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if bytes.Equal(n.Reference(), oldRef) {
+		t.Fatal("expected root reference to change")
+	}
+
+	n = mantaray.NewNodeRef(n.Reference())
+	for _, c := range paths {
+		m, err := n.Lookup(ctx, []byte(c), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		e, _ := invert(nil, entry(c))
+		if !bytes.Equal(m, e) {
+			t.Fatalf("path %s: expected value %x, got %x", c, e, m)
+		}
+	}
+
+	err := n.MapEntries(ctx, func(path, old []byte) ([]byte, error) {
+		return old[:16], nil
+	}, ls)
+	if !errors.Is(err, mantaray.ErrInvalidEntrySize) {
+		t.Fatalf("expected invalid entry size error, got %v", err)
+	}
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = n.MapEntries(ctx, func(path, old []byte) ([]byte, error) {
+		calls++
+		return nil, errStop
+	}, ls)
+	if !errors.Is(err, errStop) {
+		t.Fatalf("expected stop error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected mapping to stop after one call, got %d", calls)
+	}
+}
@@ -3,3 +3,15 @@ package mantaray
 func (n *Node) NodeType() uint8 {
 	return n.nodeType
 }
+
+func (n *Node) SetNodeType(nodeType uint8) {
+	n.nodeType = nodeType
+}
+
+func MetadataSize(meta map[string]string) (int, error) {
+	b, err := marshalMetadata(meta)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
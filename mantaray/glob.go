@@ -0,0 +1,106 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrBadPattern is returned when a glob pattern is malformed.
+var ErrBadPattern = errors.New("syntax error in pattern")
+
+// Glob returns the value paths matching pattern in sorted order. Patterns
+// follow path.Match for each separator-delimited segment, and a `**`
+// segment matches any number of segments, including none.
+func (n *Node) Glob(ctx context.Context, pattern []byte, l Loader) ([][]byte, error) {
+	segments, err := globSegments(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var matches [][]byte
+	err = n.WalkNode(ctx, []byte{}, l, func(p []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if !node.IsValueType() {
+			return nil
+		}
+		ok, err := matchSegments(segments, strings.Split(string(p), string(PathSeparator)))
+		if err != nil {
+			return err
+		}
+		if ok {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// RemoveGlob removes every value path matching pattern, as matched by Glob,
+// and returns the number of paths removed. Directories left empty by the
+// removal are removed as well; explicitly created empty directories are
+// kept. If the removal fails part way, the number of paths removed so far is
+// returned with the error.
+func (n *Node) RemoveGlob(ctx context.Context, pattern []byte, ls LoadSaver) (removed int, err error) {
+	matches, err := n.Glob(ctx, pattern, ls)
+	if err != nil {
+		return 0, err
+	}
+	if len(matches) == 0 {
+		return 0, nil
+	}
+	return n.removePaths(ctx, matches, ls)
+}
+
+// globSegments splits pattern into segments, validating each of them.
+func globSegments(pattern []byte) ([]string, error) {
+	if len(pattern) == 0 {
+		return nil, fmt.Errorf("empty pattern: %w", ErrBadPattern)
+	}
+	segments := strings.Split(string(pattern), string(PathSeparator))
+	for _, s := range segments {
+		if s == "**" {
+			continue
+		}
+		if _, err := path.Match(s, ""); err != nil {
+			return nil, fmt.Errorf("pattern '%s': %w", pattern, ErrBadPattern)
+		}
+	}
+	return segments, nil
+}
+
+func matchSegments(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				ok, err := matchSegments(pattern[1:], name[i:])
+				if err != nil || ok {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(name) == 0 {
+			return false, nil
+		}
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil {
+			return false, ErrBadPattern
+		}
+		if !ok {
+			return false, nil
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func newGlobManifest(t *testing.T) *mantaray.Node {
+	t.Helper()
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, c := range [][]byte{
+		[]byte("a.tmp"),
+		[]byte("cache/"),
+		[]byte("docs/index.html"),
+		[]byte("docs/tmp/x.tmp"),
+		[]byte("docs/tmp/y.tmp"),
+		[]byte("src/main.go"),
+		[]byte("src/main.go.tmp"),
+		[]byte("src/pkg/a/b.tmp"),
+	} {
+		e := entry(string(c))
+		if err := n.Add(ctx, c, e, nil, nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	return n
+}
+
+func TestGlob(t *testing.T) {
+	ctx := context.Background()
+	n := newGlobManifest(t)
+	for _, tc := range []struct {
+		pattern  string
+		expected [][]byte
+	}{
+		{
+			pattern: "**/*.tmp",
+			expected: [][]byte{
+				[]byte("a.tmp"),
+				[]byte("docs/tmp/x.tmp"),
+				[]byte("docs/tmp/y.tmp"),
+				[]byte("src/main.go.tmp"),
+				[]byte("src/pkg/a/b.tmp"),
+			},
+		},
+		{
+			pattern: "src/*",
+			expected: [][]byte{
+				[]byte("src/main.go"),
+				[]byte("src/main.go.tmp"),
+			},
+		},
+		{
+			pattern: "docs/**",
+			expected: [][]byte{
+				[]byte("docs/index.html"),
+				[]byte("docs/tmp/x.tmp"),
+				[]byte("docs/tmp/y.tmp"),
+			},
+		},
+		{
+			pattern: "*.html",
+		},
+	} {
+		t.Run(tc.pattern, func(t *testing.T) {
+			matches, err := n.Glob(ctx, []byte(tc.pattern), nil)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(matches, tc.expected) {
+				t.Fatalf("expected %s, got %s", tc.expected, matches)
+			}
+		})
+	}
+
+	_, err := n.Glob(ctx, []byte("src/[a-"), nil)
+	if !errors.Is(err, mantaray.ErrBadPattern) {
+		t.Fatalf("expected bad pattern error, got %v", err)
+	}
+}
+
+func TestRemoveGlob(t *testing.T) {
+	ctx := context.Background()
+	n := newGlobManifest(t)
+
+	_, err := n.RemoveGlob(ctx, []byte("**/[.tmp"), nil)
+	if !errors.Is(err, mantaray.ErrBadPattern) {
+		t.Fatalf("expected bad pattern error, got %v", err)
+	}
+
+	removed, err := n.RemoveGlob(ctx, []byte("**/*.tmp"), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if removed != 5 {
+		t.Fatalf("expected 5 paths removed, got %d", removed)
+	}
+
+	var b bytes.Buffer
+	err = n.WriteListing(ctx, nil, &b, mantaray.ListingOptions{EmptyDirectories: true})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := "cache/\ndocs/index.html\nsrc/main.go\n"
+	if b.String() != expected {
+		t.Fatalf("expected listing %q, got %q", expected, b.String())
+	}
+
+	removed, err = n.RemoveGlob(ctx, []byte("**/*.tmp"), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if removed != 0 {
+		t.Fatalf("expected no paths removed, got %d", removed)
+	}
+}
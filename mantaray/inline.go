@@ -0,0 +1,216 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// errInlineLimit stops the walk of InlineSmall once the manifest has too
+// many values to be inlined.
+var errInlineLimit = errors.New("too many values to inline")
+
+// errNotInlinable stops the walk of InlineSmall on a node that the inline
+// records cannot represent.
+var errNotInlinable = errors.New("node cannot be inlined")
+
+// InlineSmall saves the manifest as a single node holding every path, if it
+// has at most maxEntries values, and reports whether it did. Larger
+// manifests, and manifests with nodes the inlined form cannot represent,
+// such as metadata on nodes that are neither values nor empty directories,
+// are saved as with Save. The inlined node uses its
+// own version hash and is expanded into the full trie when it is loaded, so
+// lookups and walks handle it transparently; once changed, the manifest is
+// saved in the regular form again. Each path costs its length, its entry and its
+// metadata plus five bytes, so maxEntries should keep the node within the
+// chunk size of the storage. Inlining is only supported by the native
+// format.
+func (n *Node) InlineSmall(ctx context.Context, maxEntries int, ls LoadSaver) (bool, error) {
+	if c := n.persistCodec(); c != nil {
+		if _, ok := c.(NativeCodec); !ok {
+			return false, fmt.Errorf("inline with codec %T: %w", c, ErrInvalidInput)
+		}
+	}
+	if ls == nil {
+		return false, ErrNoSaver
+	}
+
+	var records []byte
+	values := 0
+	err := n.WalkNode(ctx, []byte{}, ls, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if !node.IsValueType() && !node.IsEmptyDirectory() {
+			// only values and empty directories are recorded, so metadata
+			// on any other node, such as one left by RemoveFile, would be
+			// lost
+			if node.IsWithMetadataType() && len(path) > 0 {
+				return errNotInlinable
+			}
+			return nil
+		}
+		if node.IsEmptyDirectory() && len(path) > 0 && path[len(path)-1] != PathSeparator {
+			// left by removing part of a prefix, it would be rebuilt as a
+			// file
+			return errNotInlinable
+		}
+		if node.IsValueType() {
+			values++
+			if values > maxEntries {
+				return errInlineLimit
+			}
+		}
+		record, err := inlineRecord(path, node)
+		if err != nil {
+			return fmt.Errorf("path '%s': %w", path, err)
+		}
+		records = append(records, record...)
+		return nil
+	})
+	if errors.Is(err, errInlineLimit) || errors.Is(err, errNotInlinable) {
+		return false, n.Save(ctx, ls)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	n.ensureObfuscationKey()
+	data := make([]byte, nodeHeaderSize, nodeHeaderSize+len(records))
+	copy(data, n.obfuscationKey)
+	copy(data[nodeObfuscationKeySize:], versionInlineHashBytes)
+	data[nodeHeaderSize-1] = uint8(n.refBytesSize)
+	data = append(data, records...)
+
+	ref, err := ls.Save(ctx, obfuscate(data, n.obfuscationKey))
+	if err != nil {
+		return false, err
+	}
+	n.ref = ref
+	n.forks = nil
+	atomic.StoreUint32(&n.loaded, 0)
+	return true, nil
+}
+
+// inlineRecord serialises a path of an inlined node: the path length as two
+// bytes and the path, the entry length as one byte and the entry, and the
+// JSON metadata length as two bytes and the metadata.
+func inlineRecord(path []byte, node *Node) ([]byte, error) {
+	if len(path) > int(maxUint16) || len(node.entry) > 0xff {
+		return nil, ErrInvalidInput
+	}
+	var metadata []byte
+	if len(node.metadata) > 0 {
+		var err error
+		if metadata, err = marshalMetadata(node.metadata); err != nil {
+			return nil, err
+		}
+	}
+	entry := node.entry
+	if node.IsEmptyDirectory() {
+		// the zero entry marks an empty directory when the node is rebuilt
+		entry = zero32
+	}
+	b := make([]byte, 0, 5+len(path)+len(entry)+len(metadata))
+	b = append(b, 0, 0)
+	binary.BigEndian.PutUint16(b, uint16(len(path)))
+	b = append(b, path...)
+	b = append(b, uint8(len(entry)))
+	b = append(b, entry...)
+	b = append(b, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-2:], uint16(len(metadata)))
+	return append(b, metadata...), nil
+}
+
+// unmarshalInline rebuilds the trie of an inlined node from its decrypted
+// data. The nodes below n are only held in memory.
+func (n *Node) unmarshalInline(data []byte) error {
+	if refBytesSize := int(data[nodeHeaderSize-1]); refBytesSize != 0 {
+		n.refBytesSize = refBytesSize
+	}
+	// adding the paths would clear the reference of n
+	ref := n.ref
+	defer func() { n.ref = ref }()
+	n.entry = nil
+	n.forks = make(map[byte]*fork)
+	n.index++
+	ctx := context.Background()
+	var dirs []inlineDir
+	for offset := nodeHeaderSize; offset < len(data); {
+		path, rest, err := inlineField(data[offset:], 2)
+		if err != nil {
+			return err
+		}
+		entry, rest, err := inlineField(rest, 1)
+		if err != nil {
+			return err
+		}
+		metadataBytes, rest, err := inlineField(rest, 2)
+		if err != nil {
+			return err
+		}
+		offset = len(data) - len(rest)
+
+		var metadata map[string]string
+		if len(metadataBytes) > 0 {
+			if err := json.Unmarshal(metadataBytes, &metadata); err != nil {
+				return fmt.Errorf("inline path '%s': %v: %w", path, err, ErrInvalidInput)
+			}
+		}
+		if len(path) == 0 {
+			return fmt.Errorf("inline path: %w", ErrEmptyPath)
+		}
+		node, err := newEntryNode(path, entry, metadata)
+		if err != nil {
+			return fmt.Errorf("inline path '%s': %w", path, err)
+		}
+		if node.IsEmptyDirectory() {
+			dirs = append(dirs, inlineDir{path, node})
+			continue
+		}
+		if err := n.addNode(ctx, path, node, nil); err != nil {
+			return fmt.Errorf("inline path '%s': %w", path, err)
+		}
+	}
+	// a path added below an empty directory replaces it, so directories
+	// are added after the paths they may hold, deepest first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := n.addNode(ctx, dirs[i].path, dirs[i].node, nil); err != nil {
+			return fmt.Errorf("inline path '%s': %w", dirs[i].path, err)
+		}
+	}
+	if len(n.forks) > 0 {
+		n.makeEdge()
+	}
+	return nil
+}
+
+// inlineDir is an empty directory record of an inlined node.
+type inlineDir struct {
+	path []byte
+	node *Node
+}
+
+// inlineField splits a field prefixed with its size in sizeBytes bytes off
+// data, returning the field and the remaining data.
+func inlineField(data []byte, sizeBytes int) ([]byte, []byte, error) {
+	if len(data) < sizeBytes {
+		return nil, nil, ErrTooShort
+	}
+	size := int(data[0])
+	if sizeBytes == 2 {
+		size = int(binary.BigEndian.Uint16(data))
+	}
+	data = data[sizeBytes:]
+	if len(data) < size {
+		return nil, nil, ErrTooShort
+	}
+	return append([]byte{}, data[:size]...), data[size:], nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestInlineSmall(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	meta := map[string]string{"content-type": "text/html"}
+	build := func() *mantaray.Node {
+		t.Helper()
+		n := newManifest(t, ls, "img/1.png", "img/2.png", "robots.txt")
+		if err := n.Add(ctx, []byte("index.html"), bytes.Repeat([]byte{1}, 32), meta, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := n.Add(ctx, []byte("docs/"), make([]byte, 32), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return n
+	}
+	expected, err := build().Skeleton(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// too many values are saved in the regular form
+	n := build()
+	inlined, err := n.InlineSmall(ctx, 3, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if inlined {
+		t.Fatal("expected manifest not to be inlined")
+	}
+	cl := &countingLoader{LoadSaver: ls}
+	if err := mantaray.NewNodeRef(n.Reference()).LoadAll(ctx, cl); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cl.loads == 1 {
+		t.Fatal("expected manifest to be saved as several nodes")
+	}
+
+	n = build()
+	inlined, err = n.InlineSmall(ctx, 4, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !inlined {
+		t.Fatal("expected manifest to be inlined")
+	}
+	ref := n.Reference()
+
+	cl = &countingLoader{LoadSaver: ls}
+	m := mantaray.NewNodeRef(ref)
+	skeleton, err := m.Skeleton(ctx, cl)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(skeleton, expected) {
+		t.Fatalf("expected paths %s, got %s", expected, skeleton)
+	}
+	for _, c := range []string{"img/1.png", "img/2.png", "robots.txt"} {
+		e, err := m.Lookup(ctx, []byte(c), cl)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if de := entry(c); !bytes.Equal(e, de) {
+			t.Fatalf("expected value %x, got %x", de, e)
+		}
+	}
+	node, err := m.LookupNode(ctx, []byte("index.html"), cl)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(node.Entry(), bytes.Repeat([]byte{1}, 32)) || !reflect.DeepEqual(node.Metadata(), meta) {
+		t.Fatalf("expected entry and metadata %v, got %x and %v", meta, node.Entry(), node.Metadata())
+	}
+	node, err = m.LookupNode(ctx, []byte("docs/"), cl)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !node.IsEmptyDirectory() {
+		t.Fatal("expected empty directory")
+	}
+	if cl.loads != 1 {
+		t.Fatalf("expected a single load, got %d", cl.loads)
+	}
+
+	// saving unchanged keeps the inlined reference
+	if err := m.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(m.Reference(), ref) {
+		t.Fatalf("expected reference %x, got %x", ref, m.Reference())
+	}
+
+	// a changed manifest is saved in the regular form
+	if err := m.Remove(ctx, []byte("img/2.png"), ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := m.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	m = mantaray.NewNodeRef(m.Reference())
+	if _, err := m.Lookup(ctx, []byte("img/1.png"), ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := m.Lookup(ctx, []byte("img/2.png"), ls); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	// metadata left on a node that is no longer a value cannot be inlined
+	n = build()
+	if err := n.Add(ctx, []byte("index.html.gz"), bytes.Repeat([]byte{2}, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.RemoveFile(ctx, []byte("index.html"), ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	inlined, err = n.InlineSmall(ctx, 10, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if inlined {
+		t.Fatal("expected manifest not to be inlined")
+	}
+	node, err = mantaray.NewNodeRef(n.Reference()).LookupNode(ctx, []byte("index.html"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if node.IsValueType() || !reflect.DeepEqual(node.Metadata(), meta) {
+		t.Fatalf("expected metadata %v without a value, got %v", meta, node.Metadata())
+	}
+
+	// a directory holding paths keeps its metadata, and the paths keep theirs
+	n = mantaray.New()
+	if err := n.Add(ctx, []byte("L/ab/ab"), bytes.Repeat([]byte{3}, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	dirMeta := map[string]string{"k": "dir"}
+	if err := n.MakeDir(ctx, []byte("L/"), dirMeta, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	inlined, err = n.InlineSmall(ctx, 10, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !inlined {
+		t.Fatal("expected manifest to be inlined")
+	}
+	m = mantaray.NewNodeRef(n.Reference())
+	node, err = m.LookupNode(ctx, []byte("L/"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !node.IsEmptyDirectory() || !reflect.DeepEqual(node.Metadata(), dirMeta) {
+		t.Fatalf("expected directory with metadata %v, got %v", dirMeta, node.Metadata())
+	}
+	node, err = m.LookupNode(ctx, []byte("L/ab/ab"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !node.IsValueType() || len(node.Metadata()) != 0 {
+		t.Fatalf("expected file without metadata, got %v", node.Metadata())
+	}
+
+	n = build()
+	n.SetOptions(mantaray.Options{Codec: mantaray.CBORCodec{}})
+	if _, err := n.InlineSmall(ctx, 10, ls); !errors.Is(err, mantaray.ErrInvalidInput) {
+		t.Fatalf("expected invalid input error, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"context"
+	"errors"
+)
+
+// NodeKind classifies the node found on a path.
+type NodeKind int
+
+const (
+	KindUnknown  NodeKind = iota // returned with an error
+	KindFile                     // value node not ending with separator
+	KindDir                      // directory with children
+	KindEmptyDir                 // explicitly created empty directory
+)
+
+func (k NodeKind) String() string {
+	switch k {
+	case KindFile:
+		return "file"
+	case KindDir:
+		return "dir"
+	case KindEmptyDir:
+		return "empty-dir"
+	}
+	return "unknown"
+}
+
+// TypeBit is a node type bit counted by TypeCounts.
+type TypeBit uint8
+
+const (
+	TypeValue          = TypeBit(nodeTypeValue)          // node holding an entry
+	TypeEdge           = TypeBit(nodeTypeEdge)           // node with forks
+	TypeWithMetadata   = TypeBit(nodeTypeWithMetadata)   // node carrying metadata
+	TypeEmptyDirectory = TypeBit(nodeTypeEmptyDirectory) // explicitly created empty directory
+)
+
+func (b TypeBit) String() string {
+	switch b {
+	case TypeValue:
+		return "value"
+	case TypeEdge:
+		return "edge"
+	case TypeWithMetadata:
+		return "with-metadata"
+	case TypeEmptyDirectory:
+		return "empty-dir"
+	}
+	return "unknown"
+}
+
+// Type returns the kind of the node on path, or KindUnknown and ErrNotFound.
+func (n *Node) Type(ctx context.Context, path []byte, l Loader) (NodeKind, error) {
+	isDirPath := len(path) == 0 || path[len(path)-1] == PathSeparator
+	node, err := n.LookupNode(ctx, path, l)
+	if errors.Is(err, ErrNotFound) && isDirPath {
+		// directory within a collapsed prefix
+		exists, err := n.HasPrefix(ctx, path, l)
+		if err != nil {
+			return KindUnknown, err
+		}
+		if exists {
+			return KindDir, nil
+		}
+		return KindUnknown, notFound(path)
+	}
+	if err != nil {
+		return KindUnknown, err
+	}
+	if node.IsEmptyDirectory() {
+		return KindEmptyDir, nil
+	}
+	if isDirPath {
+		if node.IsValueType() || node.IsEdgeType() {
+			return KindDir, nil
+		}
+		return KindUnknown, notFound(path)
+	}
+	if node.IsValueType() {
+		return KindFile, nil
+	}
+	return KindUnknown, notFound(path)
+}
+
+// TypeCounts returns the number of nodes with each of the value, edge,
+// metadata and empty directory type bits set. A node counts toward every bit
+// it has.
+func (n *Node) TypeCounts(ctx context.Context, l Loader) (map[TypeBit]int, error) {
+	counts := map[TypeBit]int{
+		TypeValue:          0,
+		TypeEdge:           0,
+		TypeWithMetadata:   0,
+		TypeEmptyDirectory: 0,
+	}
+	err := n.WalkNode(ctx, []byte{}, l, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		for b := range counts {
+			if node.nodeType&uint8(b) == uint8(b) {
+				counts[b]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
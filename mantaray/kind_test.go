@@ -0,0 +1,112 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestType(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, e := range []mantaray.NodeEntry{
+		{Path: []byte("css/"), Entry: make([]byte, 32)},
+		{Path: []byte("img/1.png")},
+		{Path: []byte("img/2.png")},
+		{Path: []byte("img/sub/a/1.png")},
+		{
+			Path: []byte("index.html"),
+			Metadata: map[string]string{
+				"content-type": "text/html",
+			},
+		},
+	} {
+		v := e.Entry
+		if len(v) == 0 {
+			v = entry(string(e.Path))
+		}
+		err := n.Add(ctx, e.Path, v, e.Metadata, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		path string
+		kind mantaray.NodeKind
+		err  error
+	}{
+		{path: "", kind: mantaray.KindDir},
+		{path: "css/", kind: mantaray.KindEmptyDir},
+		{path: "img/", kind: mantaray.KindDir},
+		{path: "img/1.png", kind: mantaray.KindFile},
+		{path: "index.html", kind: mantaray.KindFile},
+		{path: "img/sub/", kind: mantaray.KindDir},
+		{path: "img/sub/a/", kind: mantaray.KindDir},
+		{path: "img/sub/b/", err: mantaray.ErrNotFound},
+		{path: "img", err: mantaray.ErrNotFound},
+		{path: "i", err: mantaray.ErrNotFound},
+		{path: "img/3.png", err: mantaray.ErrNotFound},
+	} {
+		kind, err := n.Type(ctx, []byte(tc.path), nil)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("path %q: expected error %v, got %v", tc.path, tc.err, err)
+		}
+		if tc.err != nil && kind != mantaray.KindUnknown {
+			t.Fatalf("path %q: expected kind %s, got %s", tc.path, mantaray.KindUnknown, kind)
+		}
+		if tc.err == nil && kind != tc.kind {
+			t.Fatalf("path %q: expected kind %s, got %s", tc.path, tc.kind, kind)
+		}
+	}
+}
+
+func TestTypeCounts(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := mantaray.New()
+	for _, e := range []mantaray.NodeEntry{
+		{Path: []byte("/"), Entry: make([]byte, 32), Metadata: map[string]string{"index-document": "index.html"}},
+		{Path: []byte("css/"), Entry: make([]byte, 32)},
+		{Path: []byte("img/1.png")},
+		{Path: []byte("img/2.png")},
+		{Path: []byte("index.html"), Metadata: map[string]string{"content-type": "text/html"}},
+		{Path: []byte("index.html.bak")},
+	} {
+		v := e.Entry
+		if len(v) == 0 {
+			v = entry(string(e.Path))
+		}
+		if err := n.Add(ctx, e.Path, v, e.Metadata, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	counts, err := mantaray.NewNodeRef(n.Reference()).TypeCounts(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := map[mantaray.TypeBit]int{
+		// img/1.png, img/2.png, index.html, index.html.bak
+		mantaray.TypeValue: 4,
+		// root, "i", "img/", "ndex.html"
+		mantaray.TypeEdge: 4,
+		// "/", "css/"
+		mantaray.TypeEmptyDirectory: 2,
+		// "/", "ndex.html"
+		mantaray.TypeWithMetadata: 2,
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("expected %v, got %v", expected, counts)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ListingOptions controls the output of WriteListing.
+type ListingOptions struct {
+	Entry            bool   // append the entry to each line
+	Metadata         bool   // append the JSON encoded metadata to each line
+	EmptyDirectories bool   // list explicitly created empty directories
+	Hex              bool   // hex encode the entry instead of writing raw bytes
+	FieldSeparator   string // defaults to "\t"
+	LineSeparator    string // defaults to "\n"
+}
+
+// WriteListing writes one line per value path to w in sorted order.
+func (n *Node) WriteListing(ctx context.Context, l Loader, w io.Writer, opts ListingOptions) error {
+	fieldSep := opts.FieldSeparator
+	if fieldSep == "" {
+		fieldSep = "\t"
+	}
+	lineSep := opts.LineSeparator
+	if lineSep == "" {
+		lineSep = "\n"
+	}
+	return n.WalkNode(ctx, []byte{}, l, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if !node.IsValueType() && !(opts.EmptyDirectories && node.IsEmptyDirectory()) {
+			return nil
+		}
+		line := append([]byte{}, path...)
+		if opts.Entry {
+			line = append(line, fieldSep...)
+			if opts.Hex {
+				line = append(line, hex.EncodeToString(node.entry)...)
+			} else {
+				line = append(line, node.entry...)
+			}
+		}
+		if opts.Metadata {
+			line = append(line, fieldSep...)
+			if len(node.metadata) > 0 {
+				m, err := json.Marshal(node.metadata)
+				if err != nil {
+					return err
+				}
+				line = append(line, m...)
+			}
+		}
+		line = append(line, lineSep...)
+		_, err = w.Write(line)
+		return err
+	})
+}
+
+// BuildFromReader builds a manifest from a listing as written by
+// WriteListing with hex encoded entries: one `path<TAB>entry` line per
+// path, optionally followed by a TAB and the JSON encoded metadata. Paths
+// must be sorted, otherwise ErrNotSorted is returned, and are added through
+// a shared insertion cursor like AddStream does. Errors name the line they
+// occurred on.
+func BuildFromReader(ctx context.Context, r io.Reader, ls LoadSaver) (*Node, error) {
+	n := New()
+	c := newInsertCursor(n)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	var last []byte
+	for line := 1; scanner.Scan(); line++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		fields := bytes.Split(scanner.Bytes(), []byte{'\t'})
+		if len(fields) < 2 || len(fields) > 3 || len(fields[0]) == 0 {
+			return nil, fmt.Errorf("line %d: expected path, entry and optional metadata: %w", line, ErrInvalidInput)
+		}
+		path := append([]byte{}, fields[0]...)
+		entry, err := hex.DecodeString(string(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: entry: %v: %w", line, err, ErrInvalidInput)
+		}
+		var metadata map[string]string
+		if len(fields) == 3 && len(fields[2]) > 0 {
+			if err := json.Unmarshal(fields[2], &metadata); err != nil {
+				return nil, fmt.Errorf("line %d: metadata: %v: %w", line, err, ErrInvalidInput)
+			}
+		}
+		if last != nil && bytes.Compare(path, last) < 0 {
+			return nil, fmt.Errorf("line %d: path '%s' after '%s': %w", line, path, last, ErrNotSorted)
+		}
+		if err := c.add(ctx, path, entry, metadata, ls); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		last = path
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestWriteListing(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, e := range []mantaray.NodeEntry{
+		{
+			Path: []byte("/"),
+			Metadata: map[string]string{
+				"index-document": "index.html",
+			},
+		},
+		{Path: []byte("css/"), Entry: make([]byte, 32)},
+		{Path: []byte("img/1.png")},
+		{Path: []byte("img/2.png")},
+		{Path: []byte("index.html")},
+		{Path: []byte("robots.txt")},
+	} {
+		v := e.Entry
+		if len(v) == 0 {
+			v = entry(string(e.Path))
+		}
+		err := n.Add(ctx, e.Path, v, e.Metadata, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	ls := newMockLoadSaver()
+	err := n.Save(ctx, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2 := mantaray.NewNodeRef(n.Reference())
+
+	buf := bytes.NewBuffer(nil)
+	err = n2.WriteListing(ctx, ls, buf, mantaray.ListingOptions{
+		Entry:            true,
+		Metadata:         true,
+		EmptyDirectories: true,
+		Hex:              true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden, err := ioutil.ReadFile("testdata/listing.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), golden) {
+		t.Fatalf("listing mismatch, expected:\n%s\ngot:\n%s", golden, buf.Bytes())
+	}
+}
+
+func TestBuildFromReader(t *testing.T) {
+	ctx := context.Background()
+	golden, err := ioutil.ReadFile("testdata/listing.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := newMockLoadSaver()
+	n, err := mantaray.BuildFromReader(ctx, bytes.NewReader(golden), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = mantaray.NewNodeRef(n.Reference()).WriteListing(ctx, ls, buf, mantaray.ListingOptions{
+		Entry:            true,
+		Metadata:         true,
+		EmptyDirectories: true,
+		Hex:              true,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), golden) {
+		t.Fatalf("listing mismatch, expected:\n%s\ngot:\n%s", golden, buf.Bytes())
+	}
+
+	entry := strings.Repeat("ab", 32)
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected error
+		line     string
+	}{
+		{
+			name:     "missing-entry",
+			input:    "a.txt\t" + entry + "\nb.txt\n",
+			expected: mantaray.ErrInvalidInput,
+			line:     "line 2",
+		},
+		{
+			name:     "bad-hex",
+			input:    "a.txt\tzz\n",
+			expected: mantaray.ErrInvalidInput,
+			line:     "line 1",
+		},
+		{
+			name:     "bad-metadata",
+			input:    "a.txt\t" + entry + "\t{\n",
+			expected: mantaray.ErrInvalidInput,
+			line:     "line 1",
+		},
+		{
+			name:     "unsorted",
+			input:    "b.txt\t" + entry + "\na.txt\t" + entry + "\n",
+			expected: mantaray.ErrNotSorted,
+			line:     "line 2",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := mantaray.BuildFromReader(ctx, strings.NewReader(tc.input), nil)
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("expected error %v, got %v", tc.expected, err)
+			}
+			if !strings.Contains(err.Error(), tc.line) {
+				t.Fatalf("expected error naming %s, got %v", tc.line, err)
+			}
+		})
+	}
+}
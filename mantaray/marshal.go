@@ -31,6 +31,9 @@ const (
 
 	version02String     = versionNameString + versionSeparatorString + versionCode02String   // "mantaray:0.2"
 	version02HashString = "5768b3b6a7db56d21d1abff40d41cebfc83448fed8d7e9b06ec0d3b073f28f7b" // pre-calculated version string, Keccak-256
+
+	versionInlineString     = version02String + "-inline"                                        // "mantaray:0.2-inline"
+	versionInlineHashString = "ebb5820ec82a1344f4c21de2bcfd9c0a0102b6b4a9c168516a91afa01fd94f7d" // pre-calculated version string, Keccak-256
 )
 
 // Node header fields constants.
@@ -55,14 +58,16 @@ const (
 )
 
 var (
-	version01HashBytes []byte
-	version02HashBytes []byte
-	zero32             []byte
+	version01HashBytes     []byte
+	version02HashBytes     []byte
+	versionInlineHashBytes []byte
+	zero32                 []byte
 )
 
 func init() {
 	initVersion(version01HashString, &version01HashBytes)
 	initVersion(version02HashString, &version02HashBytes)
+	initVersion(versionInlineHashString, &versionInlineHashBytes)
 	zero32 = make([]byte, 32)
 }
 
@@ -105,15 +110,7 @@ func (n *Node) MarshalBinary() (bytes []byte, err error) {
 
 	headerBytes := make([]byte, nodeHeaderSize)
 
-	if len(n.obfuscationKey) == 0 {
-		// generate obfuscation key
-		obfuscationKey := make([]byte, nodeObfuscationKeySize)
-		for i := 0; i < nodeObfuscationKeySize; {
-			read, _ := obfuscationKeyFn(obfuscationKey[i:])
-			i += read
-		}
-		n.obfuscationKey = obfuscationKey
-	}
+	n.ensureObfuscationKey()
 	copy(headerBytes[0:nodeObfuscationKeySize], n.obfuscationKey)
 
 	copy(headerBytes[nodeObfuscationKeySize:nodeObfuscationKeySize+versionHashSize], version02HashBytes)
@@ -154,21 +151,39 @@ func (n *Node) MarshalBinary() (bytes []byte, err error) {
 	}
 
 	// perform XOR encryption on bytes after obfuscation key
-	xorEncryptedBytes := make([]byte, len(bytes))
+	return obfuscate(bytes, n.obfuscationKey), nil
+}
+
+// obfuscate returns a copy of data with the bytes after the obfuscation key
+// XORed with key, which both encrypts and decrypts them.
+func obfuscate(data, key []byte) []byte {
+	out := make([]byte, len(data))
 
-	copy(xorEncryptedBytes, bytes[0:nodeObfuscationKeySize])
+	copy(out, data[0:nodeObfuscationKeySize])
 
-	for i := nodeObfuscationKeySize; i < len(bytes); i += nodeObfuscationKeySize {
+	for i := nodeObfuscationKeySize; i < len(data); i += nodeObfuscationKeySize {
 		end := i + nodeObfuscationKeySize
-		if end > len(bytes) {
-			end = len(bytes)
+		if end > len(data) {
+			end = len(data)
 		}
 
-		encrypted := encryptDecrypt(bytes[i:end], n.obfuscationKey)
-		copy(xorEncryptedBytes[i:end], encrypted)
+		copy(out[i:end], encryptDecrypt(data[i:end], key))
 	}
 
-	return xorEncryptedBytes, nil
+	return out
+}
+
+// ensureObfuscationKey generates an obfuscation key if the node has none.
+func (n *Node) ensureObfuscationKey() {
+	if len(n.obfuscationKey) > 0 {
+		return
+	}
+	obfuscationKey := make([]byte, nodeObfuscationKeySize)
+	for i := 0; i < nodeObfuscationKeySize; {
+		read, _ := obfuscationKeyFn(obfuscationKey[i:])
+		i += read
+	}
+	n.obfuscationKey = obfuscationKey
 }
 
 // bitsForBytes is a set of bytes represented as a 256-length bitvector
@@ -220,21 +235,7 @@ func (n *Node) UnmarshalBinary(data []byte) error {
 	n.obfuscationKey = append([]byte{}, data[0:nodeObfuscationKeySize]...)
 
 	// perform XOR decryption on bytes after obfuscation key
-	xorDecryptedBytes := make([]byte, len(data))
-
-	copy(xorDecryptedBytes, data[0:nodeObfuscationKeySize])
-
-	for i := nodeObfuscationKeySize; i < len(data); i += nodeObfuscationKeySize {
-		end := i + nodeObfuscationKeySize
-		if end > len(data) {
-			end = len(data)
-		}
-
-		decrypted := encryptDecrypt(data[i:end], n.obfuscationKey)
-		copy(xorDecryptedBytes[i:end], decrypted)
-	}
-
-	data = xorDecryptedBytes
+	data = obfuscate(data, n.obfuscationKey)
 
 	// Verify version hash.
 	versionHash := data[nodeObfuscationKeySize : nodeObfuscationKeySize+versionHashSize]
@@ -331,6 +332,8 @@ func (n *Node) UnmarshalBinary(data []byte) error {
 			offset += nodeForkSize
 			return nil
 		})
+	} else if bytes.Equal(versionHash, versionInlineHashBytes) {
+		return n.unmarshalInline(data)
 	}
 
 	return fmt.Errorf("%x: %w", versionHash, ErrInvalidVersionHash)
@@ -397,35 +400,11 @@ func (f *fork) bytes() (b []byte, err error) {
 	b = append(b, refBytes...)
 
 	if f.Node.IsWithMetadataType() {
-		// using JSON encoding for metadata
-		metadataJSONBytes, err1 := json.Marshal(f.Node.metadata)
+		metadataJSONBytes, err1 := marshalMetadata(f.Node.metadata)
 		if err1 != nil {
 			return b, err1
 		}
-
-		metadataJSONBytesSizeWithSize := len(metadataJSONBytes) + nodeForkMetadataBytesSize
-
-		// pad JSON bytes if necessary
-		if metadataJSONBytesSizeWithSize < nodeObfuscationKeySize {
-			paddingLength := nodeObfuscationKeySize - metadataJSONBytesSizeWithSize
-			padding := make([]byte, paddingLength)
-			for i := range padding {
-				padding[i] = '\n'
-			}
-			metadataJSONBytes = append(metadataJSONBytes, padding...)
-		} else if metadataJSONBytesSizeWithSize > nodeObfuscationKeySize {
-			paddingLength := nodeObfuscationKeySize - metadataJSONBytesSizeWithSize%nodeObfuscationKeySize
-			padding := make([]byte, paddingLength)
-			for i := range padding {
-				padding[i] = '\n'
-			}
-			metadataJSONBytes = append(metadataJSONBytes, padding...)
-		}
-
 		metadataJSONBytesSize := len(metadataJSONBytes)
-		if metadataJSONBytesSize > int(maxUint16) {
-			return b, ErrMetadataTooLarge
-		}
 
 		mBytesSize := make([]byte, nodeForkMetadataBytesSize)
 		binary.BigEndian.PutUint16(mBytesSize, uint16(metadataJSONBytesSize))
@@ -437,6 +416,42 @@ func (f *fork) bytes() (b []byte, err error) {
 	return b, nil
 }
 
+// marshalMetadata returns the padded JSON encoding of metadata as stored in
+// a fork, or ErrMetadataTooLarge if it does not fit.
+func marshalMetadata(metadata map[string]string) ([]byte, error) {
+	// using JSON encoding for metadata; json.Marshal sorts map keys, so
+	// equal metadata always serialises to the same bytes and references
+	// do not depend on the order the keys were set in
+	metadataJSONBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataJSONBytesSizeWithSize := len(metadataJSONBytes) + nodeForkMetadataBytesSize
+
+	// pad JSON bytes if necessary
+	if metadataJSONBytesSizeWithSize < nodeObfuscationKeySize {
+		paddingLength := nodeObfuscationKeySize - metadataJSONBytesSizeWithSize
+		padding := make([]byte, paddingLength)
+		for i := range padding {
+			padding[i] = '\n'
+		}
+		metadataJSONBytes = append(metadataJSONBytes, padding...)
+	} else if metadataJSONBytesSizeWithSize > nodeObfuscationKeySize {
+		paddingLength := nodeObfuscationKeySize - metadataJSONBytesSizeWithSize%nodeObfuscationKeySize
+		padding := make([]byte, paddingLength)
+		for i := range padding {
+			padding[i] = '\n'
+		}
+		metadataJSONBytes = append(metadataJSONBytes, padding...)
+	}
+
+	if len(metadataJSONBytes) > int(maxUint16) {
+		return nil, ErrMetadataTooLarge
+	}
+	return metadataJSONBytes, nil
+}
+
 var refBytes = nodeRefBytes
 
 func nodeRefBytes(f *fork) []byte {
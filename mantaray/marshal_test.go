@@ -41,12 +41,6 @@ var testEntries = []NodeEntry{
 	},
 }
 
-type NodeEntry struct {
-	Path     []byte
-	Entry    []byte
-	Metadata map[string]string
-}
-
 func init() {
 	obfuscationKeyFn = mrand.Read
 }
@@ -0,0 +1,69 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrDropPath can be returned by a MergeResolveFunc to remove the
+// conflicting path from the merged manifest.
+var ErrDropPath = errors.New("drop path")
+
+// MergeResolveFunc is called by MergeFunc for paths holding a value in both
+// manifests. The returned bytes become the merged entry.
+type MergeResolveFunc func(path, base, overlay []byte) ([]byte, error)
+
+// MergeFunc adds every entry of overlay to the node. Paths holding a value in
+// both manifests are resolved by resolve, keeping the metadata of the node;
+// other paths are taken from overlay as they are.
+func (n *Node) MergeFunc(ctx context.Context, overlay *Node, resolve MergeResolveFunc, ls LoadSaver) error {
+	var entries []NodeEntry
+	err := overlay.WalkNode(ctx, []byte{}, ls, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if !node.IsValueType() && !node.IsEmptyDirectory() {
+			return nil
+		}
+		entries = append(entries, NodeEntry{
+			Path:     path,
+			Entry:    append([]byte{}, node.entry...),
+			Metadata: node.metadata,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		base, err := n.LookupNode(ctx, e.Path, ls)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if err != nil || !base.IsValueType() {
+			if err := n.Add(ctx, e.Path, e.Entry, e.Metadata, ls); err != nil {
+				return err
+			}
+			continue
+		}
+		entry, err := resolve(e.Path, base.entry, e.Entry)
+		if errors.Is(err, ErrDropPath) {
+			if err := n.Remove(ctx, e.Path, ls); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := n.Add(ctx, e.Path, entry, base.metadata, ls); err != nil {
+			return err
+		}
+	}
+	return nil
+}
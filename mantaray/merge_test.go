@@ -0,0 +1,112 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestMergeFunc(t *testing.T) {
+	entry := func(b byte) []byte {
+		e := make([]byte, 32)
+		e[31] = b
+		return e
+	}
+	for _, tc := range []struct {
+		name     string
+		resolve  mantaray.MergeResolveFunc
+		expected []byte
+	}{
+		{
+			name: "prefer-base",
+			resolve: func(_, base, _ []byte) ([]byte, error) {
+				return base, nil
+			},
+			expected: entry(1),
+		},
+		{
+			name: "prefer-overlay",
+			resolve: func(_, _, overlay []byte) ([]byte, error) {
+				return overlay, nil
+			},
+			expected: entry(2),
+		},
+		{
+			name: "computed",
+			resolve: func(_, base, overlay []byte) ([]byte, error) {
+				e := make([]byte, len(base))
+				for i := range e {
+					e[i] = base[i] + overlay[i]
+				}
+				return e, nil
+			},
+			expected: entry(3),
+		},
+		{
+			name: "drop",
+			resolve: func(_, _, _ []byte) ([]byte, error) {
+				return nil, mantaray.ErrDropPath
+			},
+		},
+	} {
+		ctx := context.Background()
+		t.Run(tc.name, func(t *testing.T) {
+			base := mantaray.New()
+			overlay := mantaray.New()
+			for _, c := range []struct {
+				n     *mantaray.Node
+				path  string
+				entry []byte
+			}{
+				{base, "index.html", entry(1)},
+				{base, "img/1.png", entry(4)},
+				{overlay, "index.html", entry(2)},
+				{overlay, "img/2.png", entry(5)},
+			} {
+				err := c.n.Add(ctx, []byte(c.path), c.entry, nil, nil)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+
+			err := base.MergeFunc(ctx, overlay, tc.resolve, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			m, err := base.Lookup(ctx, []byte("index.html"), nil)
+			if tc.expected == nil {
+				if !errors.Is(err, mantaray.ErrNotFound) {
+					t.Fatalf("expected not found error, got %v", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if !bytes.Equal(m, tc.expected) {
+					t.Fatalf("expected value %x, got %x", tc.expected, m)
+				}
+			}
+
+			for path, e := range map[string][]byte{
+				"img/1.png": entry(4),
+				"img/2.png": entry(5),
+			} {
+				m, err := base.Lookup(ctx, []byte(path), nil)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if !bytes.Equal(m, e) {
+					t.Fatalf("expected value %x, got %x", e, m)
+				}
+			}
+		})
+	}
+}
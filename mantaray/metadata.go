@@ -0,0 +1,307 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// TagsMetadataKey is the reserved metadata key holding the JSON encoded
+	// list of tags of a path.
+	TagsMetadataKey = "mantaray-tags"
+	// ModTimeMetadataKey is the reserved metadata key holding the
+	// modification time of a path in nanoseconds since the Unix epoch.
+	ModTimeMetadataKey = "mantaray-mtime"
+)
+
+// SetMetadataPrefix replaces the metadata of every value node under prefix
+// with the result of apply and returns the number of nodes updated. apply
+// receives the existing metadata, which may be nil; returning an empty map
+// clears the metadata.
+func (n *Node) SetMetadataPrefix(ctx context.Context, prefix []byte, apply func(existing map[string]string) map[string]string, ls LoadSaver) (updated int, err error) {
+	var paths [][]byte
+	err = n.WalkNode(ctx, []byte{}, ls, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if node.IsValueType() && bytes.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, path := range paths {
+		spine, err := n.lookupSpine(ctx, path, ls)
+		if err != nil {
+			return updated, err
+		}
+		node := spine[len(spine)-1]
+		existing := make(map[string]string, len(node.metadata))
+		for k, v := range node.metadata {
+			existing[k] = v
+		}
+		if err := node.setMetadata(apply(existing)); err != nil {
+			return updated, fmt.Errorf("path '%s': %w", path, err)
+		}
+		rebornSpine(spine)
+		updated++
+	}
+	return updated, nil
+}
+
+// setMetadata replaces the metadata of the node, validating its size.
+func (n *Node) setMetadata(metadata map[string]string) error {
+	if len(metadata) == 0 {
+		n.metadata = nil
+		n.makeNotWithMetadata()
+		return nil
+	}
+	if _, err := marshalMetadata(metadata); err != nil {
+		return err
+	}
+	n.metadata = metadata
+	n.makeWithMetadata()
+	return nil
+}
+
+// Tag adds tag to the value node on path.
+func (n *Node) Tag(ctx context.Context, path []byte, tag string, ls LoadSaver) error {
+	spine, err := n.lookupSpine(ctx, path, ls)
+	if err != nil {
+		return err
+	}
+	node := spine[len(spine)-1]
+	if !node.IsValueType() {
+		return notFound(path)
+	}
+	tags, err := node.tags()
+	if err != nil {
+		return err
+	}
+	i := sort.SearchStrings(tags, tag)
+	if i < len(tags) && tags[i] == tag {
+		return nil
+	}
+	tags = append(tags, "")
+	copy(tags[i+1:], tags[i:])
+	tags[i] = tag
+	tagsBytes, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	metadata := make(map[string]string, len(node.metadata)+1)
+	for k, v := range node.metadata {
+		metadata[k] = v
+	}
+	metadata[TagsMetadataKey] = string(tagsBytes)
+	if err := node.setMetadata(metadata); err != nil {
+		return fmt.Errorf("path '%s': %w", path, err)
+	}
+	rebornSpine(spine)
+	return nil
+}
+
+// PathsByTag returns the sorted paths of all value nodes tagged with tag.
+func (n *Node) PathsByTag(ctx context.Context, tag string, l Loader) ([][]byte, error) {
+	var paths [][]byte
+	err := n.WalkNode(ctx, []byte{}, l, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if !node.IsValueType() {
+			return nil
+		}
+		tags, err := node.tags()
+		if err != nil {
+			return fmt.Errorf("path '%s': %w", path, err)
+		}
+		i := sort.SearchStrings(tags, tag)
+		if i < len(tags) && tags[i] == tag {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// RemoveByTag removes all paths tagged with tag and returns how many were
+// removed.
+func (n *Node) RemoveByTag(ctx context.Context, tag string, ls LoadSaver) (int, error) {
+	paths, err := n.PathsByTag(ctx, tag, ls)
+	if err != nil {
+		return 0, err
+	}
+	for i, path := range paths {
+		if err := n.Remove(ctx, path, ls); err != nil {
+			return i, err
+		}
+	}
+	return len(paths), nil
+}
+
+// FilterByMetadata returns the sorted paths of all value nodes whose
+// metadata satisfies pred. pred receives a copy of the metadata, or nil for
+// nodes without metadata, which key lookups therefore never match.
+func (n *Node) FilterByMetadata(ctx context.Context, pred func(meta map[string]string) bool, l Loader) ([][]byte, error) {
+	var paths [][]byte
+	err := n.WalkNode(ctx, []byte{}, l, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if node.IsValueType() && pred(node.Metadata()) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// tags returns the sorted tags stored in the node metadata.
+func (n *Node) tags() ([]string, error) {
+	v, ok := n.metadata[TagsMetadataKey]
+	if !ok {
+		return nil, nil
+	}
+	var tags []string
+	if err := json.Unmarshal([]byte(v), &tags); err != nil {
+		return nil, err
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// SetModTime stores t as the modification time of the value node on path.
+func (n *Node) SetModTime(ctx context.Context, path []byte, t time.Time, ls LoadSaver) error {
+	spine, err := n.lookupSpine(ctx, path, ls)
+	if err != nil {
+		return err
+	}
+	node := spine[len(spine)-1]
+	if !node.IsValueType() {
+		return notFound(path)
+	}
+	metadata := make(map[string]string, len(node.metadata)+1)
+	for k, v := range node.metadata {
+		metadata[k] = v
+	}
+	metadata[ModTimeMetadataKey] = strconv.FormatInt(t.UnixNano(), 10)
+	if err := node.setMetadata(metadata); err != nil {
+		return fmt.Errorf("path '%s': %w", path, err)
+	}
+	rebornSpine(spine)
+	return nil
+}
+
+// ModTime returns the modification time stored on the value node on path.
+// ErrNotFound is returned if the path or its modification time is missing.
+func (n *Node) ModTime(ctx context.Context, path []byte, l Loader) (time.Time, error) {
+	node, err := n.LookupNode(ctx, path, l)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !node.IsValueType() {
+		return time.Time{}, notFound(path)
+	}
+	v, ok := node.metadata[ModTimeMetadataKey]
+	if !ok {
+		return time.Time{}, fmt.Errorf("path '%s': modification time: %w", path, ErrNotFound)
+	}
+	nsec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("path '%s': modification time: %w", path, err)
+	}
+	return time.Unix(0, nsec), nil
+}
+
+// MetadataValue returns the value of key in the metadata of the value node on
+// path and whether it is set.
+func (n *Node) MetadataValue(ctx context.Context, path, key []byte, l Loader) ([]byte, bool, error) {
+	node, err := n.LookupNode(ctx, path, l)
+	if err != nil {
+		return nil, false, err
+	}
+	if !node.IsValueType() {
+		return nil, false, notFound(path)
+	}
+	v, ok := node.metadata[string(key)]
+	if !ok {
+		return nil, false, nil
+	}
+	return []byte(v), true, nil
+}
+
+// RootMetadata returns the metadata of the root path "/" of the manifest at
+// ref, such as the site-wide index document. Only the root node is loaded,
+// since fork metadata is stored in the parent. The map is empty if the root
+// path has no metadata.
+func RootMetadata(ctx context.Context, ref []byte, l Loader) (map[string]string, error) {
+	n := NewNodeRef(ref)
+	if err := n.load(ctx, l); err != nil {
+		return nil, err
+	}
+	meta := make(map[string]string)
+	f, ok := n.forks[PathSeparator]
+	if !ok || len(f.prefix) != 1 {
+		return meta, nil
+	}
+	for k, v := range f.Node.metadata {
+		meta[k] = v
+	}
+	return meta, nil
+}
+
+// ClampMetadata trims meta so that its serialized size fits within limit
+// bytes, keeping keys in priority order first and the remaining keys in
+// sorted order after them. Keys that do not fit are dropped and returned. A
+// limit of zero or less uses the maximum size the format allows.
+func ClampMetadata(meta map[string]string, limit int, priority []string) (map[string]string, []string) {
+	if limit <= 0 || limit > int(maxUint16) {
+		limit = int(maxUint16)
+	}
+
+	order := make([]string, 0, len(meta))
+	seen := make(map[string]bool, len(meta))
+	for _, k := range priority {
+		if _, ok := meta[k]; ok && !seen[k] {
+			order = append(order, k)
+			seen[k] = true
+		}
+	}
+	var rest []string
+	for k := range meta {
+		if !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	order = append(order, rest...)
+
+	kept := make(map[string]string, len(meta))
+	var dropped []string
+	for _, k := range order {
+		kept[k] = meta[k]
+		if b, err := marshalMetadata(kept); err != nil || len(b) > limit {
+			delete(kept, k)
+			dropped = append(dropped, k)
+		}
+	}
+	return kept, dropped
+}
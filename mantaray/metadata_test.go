@@ -0,0 +1,431 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestSetMetadataPrefix(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, c := range [][]byte{
+		[]byte("index.html"),
+		[]byte("static/app.js"),
+		[]byte("static/css/app.css"),
+		[]byte("static/img/icons/logo.png"),
+		[]byte("staticfile.txt"),
+	} {
+		e := entry(string(c))
+		err := n.Add(ctx, c, e, map[string]string{"content-type": "x"}, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	ls := newMockLoadSaver()
+	err := n.Save(ctx, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := n.Reference()
+
+	updated, err := n.SetMetadataPrefix(ctx, []byte("static/"), func(m map[string]string) map[string]string {
+		m["cache-control"] = "max-age=3600"
+		return m
+	}, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if updated != 3 {
+		t.Fatalf("expected 3 updated nodes, got %d", updated)
+	}
+
+	err = n.Save(ctx, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(n.Reference()) == string(ref) {
+		t.Fatal("expected reference to change")
+	}
+
+	n2 := mantaray.NewNodeRef(n.Reference())
+	for path, expected := range map[string]map[string]string{
+		"index.html":                {"content-type": "x"},
+		"staticfile.txt":            {"content-type": "x"},
+		"static/app.js":             {"content-type": "x", "cache-control": "max-age=3600"},
+		"static/css/app.css":        {"content-type": "x", "cache-control": "max-age=3600"},
+		"static/img/icons/logo.png": {"content-type": "x", "cache-control": "max-age=3600"},
+	} {
+		node, err := n2.LookupNode(ctx, []byte(path), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(node.Metadata(), expected) {
+			t.Fatalf("path %s: expected metadata %v, got %v", path, expected, node.Metadata())
+		}
+	}
+
+	_, err = n2.SetMetadataPrefix(ctx, []byte("index"), func(m map[string]string) map[string]string {
+		m["large"] = strings.Repeat("a", 1<<16)
+		return m
+	}, ls)
+	if !errors.Is(err, mantaray.ErrMetadataTooLarge) {
+		t.Fatalf("expected metadata too large error, got %v", err)
+	}
+}
+
+func TestTags(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	paths := [][]byte{
+		[]byte("index.html"),
+		[]byte("img/1.png"),
+		[]byte("img/2.png"),
+		[]byte("robots.txt"),
+	}
+	for _, c := range paths {
+		e := entry(string(c))
+		err := n.Add(ctx, c, e, nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	for path, tags := range map[string][]string{
+		"index.html": {"build-42"},
+		"img/1.png":  {"build-41", "build-42"},
+		"img/2.png":  {"build-41"},
+	} {
+		for _, tag := range tags {
+			err := n.Tag(ctx, []byte(path), tag, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+	}
+	// tagging twice is a no-op
+	err := n.Tag(ctx, []byte("index.html"), "build-42", nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = n.Tag(ctx, []byte("img/"), "build-42", nil)
+	if !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	ls := newMockLoadSaver()
+	err = n.Save(ctx, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2 := mantaray.NewNodeRef(n.Reference())
+
+	got, err := n2.PathsByTag(ctx, "build-42", ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := [][]byte{[]byte("img/1.png"), []byte("index.html")}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected paths %s, got %s", expected, got)
+	}
+
+	removed, err := n2.RemoveByTag(ctx, "build-41", ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if removed != 2 {
+		t.Fatalf("expected 2 removed paths, got %d", removed)
+	}
+	for path, exists := range map[string]bool{
+		"index.html": true,
+		"img/1.png":  false,
+		"img/2.png":  false,
+		"robots.txt": true,
+	} {
+		_, err := n2.Lookup(ctx, []byte(path), ls)
+		if exists && err != nil {
+			t.Fatalf("path %s: expected no error, got %v", path, err)
+		}
+		if !exists && !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("path %s: expected not found error, got %v", path, err)
+		}
+	}
+}
+
+func TestFilterByMetadata(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := mantaray.New()
+	for _, e := range []mantaray.NodeEntry{
+		{Path: []byte("about.html"), Metadata: map[string]string{"Content-Type": "text/html"}},
+		{Path: []byte("img/1.png"), Metadata: map[string]string{"Content-Type": "image/png"}},
+		{Path: []byte("index.html"), Metadata: map[string]string{"Content-Type": "text/html"}},
+		{Path: []byte("robots.txt")},
+	} {
+		entry := entry(string(e.Path))
+		if err := n.Add(ctx, e.Path, entry, e.Metadata, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	n = mantaray.NewNodeRef(n.Reference())
+
+	paths, err := n.FilterByMetadata(ctx, func(meta map[string]string) bool {
+		return meta["Content-Type"] == "text/html"
+	}, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := [][]byte{[]byte("about.html"), []byte("index.html")}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected %s, got %s", expected, paths)
+	}
+
+	paths, err = n.FilterByMetadata(ctx, func(meta map[string]string) bool {
+		return meta == nil
+	}, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected = [][]byte{[]byte("robots.txt")}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected %s, got %s", expected, paths)
+	}
+}
+
+func TestModTime(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "img/1.png", "index.html")
+	mtime := time.Date(2020, 10, 20, 16, 3, 32, 123456789, time.UTC)
+	if err := n.SetModTime(ctx, []byte("index.html"), mtime, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err := n.SetModTime(ctx, []byte("img/"), mtime, ls)
+	if !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	n = mantaray.NewNodeRef(n.Reference())
+	got, err := n.ModTime(ctx, []byte("index.html"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !got.Equal(mtime) {
+		t.Fatalf("expected modification time %v, got %v", mtime, got)
+	}
+	_, err = n.ModTime(ctx, []byte("img/1.png"), ls)
+	if !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	// the metadata size limit applies
+	large := map[string]string{"k": strings.Repeat("x", 65500)}
+	if err := n.Add(ctx, []byte("large.bin"), entry("large.bin"), large, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = n.SetModTime(ctx, []byte("large.bin"), mtime, ls)
+	if !errors.Is(err, mantaray.ErrMetadataTooLarge) {
+		t.Fatalf("expected metadata too large error, got %v", err)
+	}
+}
+
+func TestMetadataValue(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "img/1.png")
+	meta := map[string]string{"content-type": "text/html", "empty": ""}
+	if err := n.Add(ctx, []byte("index.html"), bytes.Repeat([]byte{1}, 32), meta, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	n = mantaray.NewNodeRef(n.Reference())
+	for _, tc := range []struct {
+		path, key string
+		value     []byte
+		ok        bool
+		err       error
+	}{
+		{path: "index.html", key: "content-type", value: []byte("text/html"), ok: true},
+		{path: "index.html", key: "empty", value: []byte{}, ok: true},
+		{path: "index.html", key: "author"},
+		{path: "img/1.png", key: "content-type"},
+		{path: "img/", key: "content-type", err: mantaray.ErrNotFound},
+		{path: "robots.txt", key: "content-type", err: mantaray.ErrNotFound},
+	} {
+		v, ok, err := n.MetadataValue(ctx, []byte(tc.path), []byte(tc.key), ls)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("%s %s: expected error %v, got %v", tc.path, tc.key, tc.err, err)
+		}
+		if ok != tc.ok || !bytes.Equal(v, tc.value) {
+			t.Fatalf("%s %s: expected %q %t, got %q %t", tc.path, tc.key, tc.value, tc.ok, v, ok)
+		}
+	}
+}
+
+func TestRootMetadata(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	meta := map[string]string{mantaray.IndexDocumentMetadataKey: "index.html"}
+
+	n := newManifest(t, ls, "img/1.png", "index.html")
+	if err := n.Add(ctx, []byte("/"), make([]byte, 32), meta, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	cl := &countingLoader{LoadSaver: ls}
+	got, err := mantaray.RootMetadata(ctx, n.Reference(), cl)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(got, meta) {
+		t.Fatalf("expected metadata %v, got %v", meta, got)
+	}
+	if cl.loads != 1 {
+		t.Fatalf("expected 1 load, got %d", cl.loads)
+	}
+
+	n = newManifest(t, ls, "img/1.png", "index.html")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	got, err = mantaray.RootMetadata(ctx, n.Reference(), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got == nil || len(got) != 0 {
+		t.Fatalf("expected empty metadata, got %v", got)
+	}
+}
+
+func TestMetadataDeterministic(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	keys := []string{"content-type", "filename", "lang", "mantaray-mtime", "z"}
+	expected := make(map[string]string)
+	for _, k := range keys {
+		expected[k] = "value-" + k
+	}
+
+	build := func(add func(n *mantaray.Node, path, entry []byte)) []byte {
+		t.Helper()
+		n := mantaray.New()
+		n.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+		for _, c := range []string{"img/1.png", "index.html", "robots.txt"} {
+			add(n, []byte(c), entry(c))
+		}
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return n.Reference()
+	}
+
+	forward := build(func(n *mantaray.Node, path, entry []byte) {
+		meta := make(map[string]string)
+		for _, k := range keys {
+			meta[k] = expected[k]
+		}
+		if err := n.Add(ctx, path, entry, meta, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+	for name, add := range map[string]func(n *mantaray.Node, path, entry []byte){
+		"reverse": func(n *mantaray.Node, path, entry []byte) {
+			meta := make(map[string]string)
+			for i := len(keys) - 1; i >= 0; i-- {
+				meta[keys[i]] = expected[keys[i]]
+			}
+			if err := n.Add(ctx, path, entry, meta, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		},
+		"one-by-one": func(n *mantaray.Node, path, entry []byte) {
+			if err := n.Add(ctx, path, entry, nil, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			for i := len(keys) - 1; i >= 0; i-- {
+				k := keys[i]
+				_, err := n.SetMetadataPrefix(ctx, path, func(existing map[string]string) map[string]string {
+					existing[k] = expected[k]
+					return existing
+				}, ls)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+		},
+	} {
+		if ref := build(add); !bytes.Equal(ref, forward) {
+			t.Fatalf("%s: expected reference %x, got %x", name, forward, ref)
+		}
+	}
+}
+
+func TestClampMetadata(t *testing.T) {
+	meta := map[string]string{
+		"content-type":  "text/html",
+		"cache-control": "max-age=3600",
+		"author":        strings.Repeat("a", 40),
+		"comment":       strings.Repeat("c", 40),
+	}
+	priority := []string{"content-type", "cache-control"}
+
+	withoutComment := map[string]string{
+		"content-type":  meta["content-type"],
+		"cache-control": meta["cache-control"],
+		"author":        meta["author"],
+	}
+	size, err := mantaray.MetadataSize(withoutComment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// exactly at the boundary
+	kept, dropped := mantaray.ClampMetadata(meta, size, priority)
+	if !reflect.DeepEqual(kept, withoutComment) {
+		t.Fatalf("expected metadata %v, got %v", withoutComment, kept)
+	}
+	if !reflect.DeepEqual(dropped, []string{"comment"}) {
+		t.Fatalf("expected dropped [comment], got %v", dropped)
+	}
+
+	// one byte below drops the lowest priority keys
+	kept, dropped = mantaray.ClampMetadata(meta, size-1, priority)
+	if _, ok := kept["content-type"]; !ok {
+		t.Fatalf("expected priority key to be kept, got %v", kept)
+	}
+	if _, ok := kept["author"]; ok {
+		t.Fatalf("expected author to be dropped, got %v", kept)
+	}
+	if len(kept)+len(dropped) != len(meta) {
+		t.Fatalf("expected %d keys in total, got %d", len(meta), len(kept)+len(dropped))
+	}
+	if s, err := mantaray.MetadataSize(kept); err != nil || s > size-1 {
+		t.Fatalf("expected size within %d, got %d (%v)", size-1, s, err)
+	}
+
+	// everything fits
+	kept, dropped = mantaray.ClampMetadata(meta, 0, priority)
+	if !reflect.DeepEqual(kept, meta) || len(dropped) != 0 {
+		t.Fatalf("expected all metadata to be kept, got %v dropped %v", kept, dropped)
+	}
+}
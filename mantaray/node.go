@@ -7,8 +7,10 @@ package mantaray
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 const (
@@ -30,6 +32,15 @@ var (
 	ErrInvalidFile      = errors.New("invalid file")
 	ErrMetadataTooLarge = errors.New("metadata too large")
 	ErrForbiddenAction  = errors.New("forbidden action")
+	ErrNotSorted        = errors.New("entries not sorted")
+	ErrInvalidReference = errors.New("invalid reference")
+	ErrIsDirectory      = errors.New("path is a directory")
+	ErrInvalidEntrySize = errors.New("invalid entry size")
+	ErrConflict         = errors.New("path conflict")
+	ErrInvalidPath      = errors.New("invalid path")
+	ErrNotConnected     = errors.New("manifest not connected")
+	ErrPathTooLong      = errors.New("path too long")
+	ErrNotDirectory     = errors.New("path is not a directory")
 )
 
 // Node represents a mantaray Node
@@ -42,6 +53,19 @@ type Node struct {
 	entry          []byte
 	metadata       map[string]string
 	forks          map[byte]*fork
+	opts           *Options
+	codec          Codec        // codec the node was loaded or saved with, nil for native
+	loaded         uint32       // set atomically once loadShared has published forks
+	shared         *sharedLoads // state of the lookups started from the node
+	count          int          // files below the node, valid if countValid
+	countValid     bool
+}
+
+// NodeEntry describes a single path to be added to a manifest.
+type NodeEntry struct {
+	Path     []byte
+	Entry    []byte
+	Metadata map[string]string
 }
 
 type fork struct {
@@ -73,8 +97,50 @@ func New() *Node {
 	return &Node{forks: make(map[byte]*fork)}
 }
 
+// NotFoundError is returned when a path does not resolve. Matched holds the
+// leading part of Path that was successfully descended before failing.
+type NotFoundError struct {
+	Path    []byte
+	Matched []byte
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("entry on '%s' ('%x'): %v", e.Path, e.Path, ErrNotFound)
+}
+
+// Unwrap makes errors.Is(err, ErrNotFound) hold for a NotFoundError.
+func (e *NotFoundError) Unwrap() error {
+	return ErrNotFound
+}
+
+// PathTooLongError is returned when a path exceeds Options.MaxPathLen.
+type PathTooLongError struct {
+	Len   int
+	Limit int
+}
+
+func (e *PathTooLongError) Error() string {
+	return fmt.Sprintf("path length %d exceeds limit %d: %v", e.Len, e.Limit, ErrPathTooLong)
+}
+
+// Unwrap makes errors.Is(err, ErrPathTooLong) hold for a PathTooLongError.
+func (e *PathTooLongError) Unwrap() error {
+	return ErrPathTooLong
+}
+
 func notFound(path []byte) error {
-	return fmt.Errorf("entry on '%s' ('%x'): %w", path, path, ErrNotFound)
+	return &NotFoundError{Path: path}
+}
+
+// descended updates a NotFoundError returned by a child node after matching
+// prefix, so that it refers to path as seen by the parent.
+func descended(err error, path, prefix []byte) error {
+	var e *NotFoundError
+	if errors.As(err, &e) {
+		e.Path = path
+		e.Matched = append(append([]byte{}, prefix...), e.Matched...)
+	}
+	return err
 }
 
 // IsValueType returns true if the node contains entry.
@@ -101,6 +167,39 @@ func (n *Node) IsEmptyDirectory() bool {
 	return n.nodeType&nodeTypeEmptyDirectory == nodeTypeEmptyDirectory
 }
 
+// nodeTypeNames names the node type bits in the order TypeString lists them.
+var nodeTypeNames = []struct {
+	bit  uint8
+	name string
+}{
+	{nodeTypeValue, "value"},
+	{nodeTypeEdge, "edge"},
+	{nodeTypeWithPathSeparator, "path-separator"},
+	{nodeTypeWithMetadata, "metadata"},
+	{nodeTypeEmptyDirectory, "empty-directory"},
+}
+
+// TypeString returns the names of the type bits set on the node joined by
+// "|", such as "value|edge|metadata", or "none" if no bit is set. Unknown
+// bits are appended in hexadecimal.
+func (n *Node) TypeString() string {
+	var names []string
+	rest := n.nodeType
+	for _, t := range nodeTypeNames {
+		if n.nodeType&t.bit == t.bit {
+			names = append(names, t.name)
+			rest &^= t.bit
+		}
+	}
+	if rest != 0 {
+		names = append(names, fmt.Sprintf("%#x", rest))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
 func (n *Node) makeValue() {
 	n.nodeType = n.nodeType | nodeTypeValue
 }
@@ -155,14 +254,41 @@ func (n *Node) Reference() []byte {
 	return n.ref
 }
 
-// Entry returns the value stored on the specific path.
+// ReferenceString returns the lowercase hex encoding of the reference, or an
+// empty string if the node is not saved.
+func (n *Node) ReferenceString() string {
+	return hex.EncodeToString(n.ref)
+}
+
+// ParseReference decodes a hex encoded reference of either 32 bytes or 64
+// bytes (encrypted).
+func ParseReference(s string) ([]byte, error) {
+	ref, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrInvalidReference)
+	}
+	if len(ref) != 32 && len(ref) != 64 {
+		return nil, fmt.Errorf("length %d: %w", len(ref), ErrInvalidReference)
+	}
+	return ref, nil
+}
+
+// Entry returns the value stored on the specific path. The returned slice
+// is shared with the node and must not be modified.
 func (n *Node) Entry() []byte {
 	return n.entry
 }
 
-// Metadata returns the metadata stored on the specific path.
+// Metadata returns a copy of the metadata stored on the specific path.
 func (n *Node) Metadata() map[string]string {
-	return n.metadata
+	if n.metadata == nil {
+		return nil
+	}
+	metadata := make(map[string]string, len(n.metadata))
+	for k, v := range n.metadata {
+		metadata[k] = v
+	}
+	return metadata
 }
 
 func (n *Node) Index() int64 {
@@ -179,15 +305,43 @@ func (n *Node) Prefix() [][]byte {
 
 // LookupNode finds the node for a path or returns error if not found
 func (n *Node) LookupNode(ctx context.Context, path []byte, l Loader) (*Node, error) {
+	return n.lookupNodeWithOptions(ctx, path, n.budgeted(l))
+}
+
+// lookupNodeWithOptions looks up path applying the node options.
+func (n *Node) lookupNodeWithOptions(ctx context.Context, path []byte, l Loader) (*Node, error) {
+	node, err := n.lookupNode(ctx, path, l)
+	if errors.Is(err, ErrNotFound) && n.options().TrailingSlashInsensitive {
+		if dirPath := withTrailingSlash(path); dirPath != nil {
+			if dir, dirErr := n.lookupNode(ctx, dirPath, l); dirErr == nil && isDirectory(dir) {
+				return dir, nil
+			}
+		}
+	}
+	return node, err
+}
+
+func (n *Node) lookupNode(ctx context.Context, path []byte, l Loader) (*Node, error) {
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
 	}
-	if n.forks == nil {
-		if err := n.load(ctx, l); err != nil {
-			return nil, err
-		}
+	s := n.sharedLoads()
+	if err := n.loadShared(ctx, s, l); err != nil {
+		return nil, err
+	}
+	return n.lookupLoaded(ctx, s, path, l)
+}
+
+// lookupLoaded looks up path below the loaded node n. Forks are loaded with
+// loadShared and indexes are passed on under the lock of s, so concurrent
+// lookups started from the same node are safe.
+func (n *Node) lookupLoaded(ctx context.Context, s *sharedLoads, path []byte, l Loader) (*Node, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
 	}
 	if len(path) == 0 {
 		return n, nil
@@ -198,12 +352,19 @@ func (n *Node) LookupNode(ctx context.Context, path []byte, l Loader) (*Node, er
 	}
 	c := common(f.prefix, path)
 	if len(c) == len(f.prefix) {
+		s.mu.Lock()
 		f.Node.index = n.index
-		node, err := f.Node.LookupNode(ctx, path[len(c):], l)
+		s.mu.Unlock()
+		if err := f.Node.loadShared(ctx, s, l); err != nil {
+			return nil, descended(err, path, c)
+		}
+		node, err := f.Node.lookupLoaded(ctx, s, path[len(c):], l)
 		if err != nil {
-			return node, err
+			return node, descended(err, path, c)
 		}
+		s.mu.Lock()
 		n.index = node.index
+		s.mu.Unlock()
 		return node, err
 	}
 	return nil, notFound(path)
@@ -211,24 +372,299 @@ func (n *Node) LookupNode(ctx context.Context, path []byte, l Loader) (*Node, er
 
 // Lookup finds the entry for a path or returns error if not found
 func (n *Node) Lookup(ctx context.Context, path []byte, l Loader) ([]byte, error) {
-	node, err := n.LookupNode(ctx, path, l)
+	node, err := n.lookupValue(ctx, path, n.budgeted(l))
+	if err != nil {
+		return nil, err
+	}
+	return n.readEntry(node.entry), nil
+}
+
+// LookupWithMetadata finds the entry and a copy of the metadata for a path
+// in a single descent, or returns error if not found.
+func (n *Node) LookupWithMetadata(ctx context.Context, path []byte, l Loader) (entry []byte, metadata map[string]string, err error) {
+	node, err := n.lookupValue(ctx, path, n.budgeted(l))
+	if err != nil {
+		return nil, nil, err
+	}
+	return n.readEntry(node.entry), node.Metadata(), nil
+}
+
+// LookupMany looks up every path, returning the entries and the per-path
+// errors at the same indices, so a missing path does not fail the others.
+// Paths sharing a prefix are resolved in a single descent, so every node is
+// loaded at most once. The returned error is only set if loading fails or
+// ctx is done.
+func (n *Node) LookupMany(ctx context.Context, paths [][]byte, l Loader) ([][]byte, []error, error) {
+	l = n.budgeted(l)
+	nodes := make([]*Node, len(paths))
+	errs := make([]error, len(paths))
+	pending := make([]lookupQuery, len(paths))
+	for i, p := range paths {
+		pending[i] = lookupQuery{index: i, path: p, rest: p}
+	}
+	if err := n.lookupAll(ctx, pending, nodes, errs, l); err != nil {
+		return nil, nil, err
+	}
+	if n.options().TrailingSlashInsensitive {
+		var retry []lookupQuery
+		for i, p := range paths {
+			if errs[i] == nil {
+				continue
+			}
+			if dirPath := withTrailingSlash(p); dirPath != nil {
+				retry = append(retry, lookupQuery{index: i, path: dirPath, rest: dirPath})
+			}
+		}
+		dirNodes := make([]*Node, len(paths))
+		dirErrs := make([]error, len(paths))
+		if err := n.lookupAll(ctx, retry, dirNodes, dirErrs, l); err != nil {
+			return nil, nil, err
+		}
+		for _, q := range retry {
+			if dirErrs[q.index] == nil && isDirectory(dirNodes[q.index]) {
+				nodes[q.index], errs[q.index] = dirNodes[q.index], nil
+			}
+		}
+	}
+	entries := make([][]byte, len(paths))
+	for i, node := range nodes {
+		if errs[i] == nil {
+			entries[i] = n.readEntry(node.entry)
+		}
+	}
+	return entries, errs, nil
+}
+
+// lookupQuery is a path of LookupMany and its part left to resolve.
+type lookupQuery struct {
+	index      int
+	path, rest []byte
+}
+
+// lookupAll resolves every pending path to its value node, as lookup does,
+// storing the nodes and not found errors at the query indices.
+func (n *Node) lookupAll(ctx context.Context, pending []lookupQuery, nodes []*Node, errs []error, l Loader) error {
+	if len(pending) == 0 {
+		return nil
+	}
+	s := n.sharedLoads()
+	if err := n.loadShared(ctx, s, l); err != nil {
+		return err
+	}
+	if err := n.lookupAllLoaded(ctx, s, pending, nodes, l); err != nil {
+		return err
+	}
+	for _, q := range pending {
+		node := nodes[q.index]
+		if node == nil {
+			e := &NotFoundError{Path: q.path}
+			if matched := len(q.path) - len(q.rest); matched > 0 {
+				e.Matched = append([]byte{}, q.path[:matched]...)
+			}
+			errs[q.index] = e
+			continue
+		}
+		nodes[q.index], errs[q.index] = n.valueNode(node, q.path)
+	}
+	return nil
+}
+
+// lookupAllLoaded descends from the loaded node n once for every fork the
+// pending paths continue in, leaving rest at the unmatched part of the paths
+// that are not found.
+func (n *Node) lookupAllLoaded(ctx context.Context, s *sharedLoads, pending []lookupQuery, nodes []*Node, l Loader) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	descend := make(map[byte][]int)
+	for i, q := range pending {
+		if len(q.rest) == 0 {
+			nodes[q.index] = n
+			continue
+		}
+		f := n.forks[q.rest[0]]
+		if f == nil || !bytes.HasPrefix(q.rest, f.prefix) {
+			continue
+		}
+		descend[q.rest[0]] = append(descend[q.rest[0]], i)
+	}
+	for _, b := range sortedForkKeys(n) {
+		if len(descend[b]) == 0 {
+			continue
+		}
+		f := n.forks[b]
+		s.mu.Lock()
+		f.Node.index = n.index
+		s.mu.Unlock()
+		if err := f.Node.loadShared(ctx, s, l); err != nil {
+			return err
+		}
+		qs := make([]lookupQuery, len(descend[b]))
+		for j, i := range descend[b] {
+			qs[j] = pending[i]
+			qs[j].rest = qs[j].rest[len(f.prefix):]
+		}
+		if err := f.Node.lookupAllLoaded(ctx, s, qs, nodes, l); err != nil {
+			return err
+		}
+		for j, i := range descend[b] {
+			pending[i].rest = qs[j].rest
+		}
+		s.mu.Lock()
+		n.index = f.Node.index
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// LookupChain looks up path in each of manifests in order, such as overrides
+// before a base, and returns the entry of the first manifest holding it
+// along with that manifest. ErrNotFound is returned if none does; any other
+// error stops the search.
+func LookupChain(ctx context.Context, path []byte, l Loader, manifests ...*Node) ([]byte, *Node, error) {
+	for _, m := range manifests {
+		entry, err := m.Lookup(ctx, path, l)
+		if err == nil {
+			return entry, m, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, notFound(path)
+}
+
+// readEntry returns entry, or a copy of it if CopyEntriesOnRead is set.
+func (n *Node) readEntry(entry []byte) []byte {
+	if n.options().CopyEntriesOnRead {
+		return copyBytes(entry)
+	}
+	return entry
+}
+
+// lookupValue returns the node holding the value on path, falling back to
+// the directory path if the manifest is trailing slash insensitive.
+func (n *Node) lookupValue(ctx context.Context, path []byte, l Loader) (*Node, error) {
+	node, err := n.lookup(ctx, path, l)
+	if errors.Is(err, ErrNotFound) && n.options().TrailingSlashInsensitive {
+		if dirPath := withTrailingSlash(path); dirPath != nil {
+			if dirNode, dirErr := n.lookup(ctx, dirPath, l); dirErr == nil && isDirectory(dirNode) {
+				node, err = dirNode, nil
+			}
+		}
+	}
+	return node, err
+}
+
+func (n *Node) lookup(ctx context.Context, path []byte, l Loader) (*Node, error) {
+	node, err := n.lookupNode(ctx, path, l)
 	if err != nil {
 		return nil, err
 	}
+	return n.valueNode(node, path)
+}
+
+// valueNode returns node, found on path, if it holds a value for lookups.
+func (n *Node) valueNode(node *Node, path []byte) (*Node, error) {
 	if !node.IsValueType() && len(path) > 0 {
-		return nil, notFound(path)
+		if node.IsEmptyDirectory() && n.options().ResolveDirsAsValues {
+			return node, nil
+		}
+		return nil, &NotFoundError{Path: path, Matched: path}
 	}
-	return node.entry, nil
+	return node, nil
+}
+
+// LookupOrAncestor returns the entry on path or, if path holds no value,
+// the entry of the nearest ancestor directory holding one, up to the root
+// directory path "/". It also returns the path the entry was found on.
+func (n *Node) LookupOrAncestor(ctx context.Context, path []byte, l Loader) (entry []byte, matchedPath []byte, err error) {
+	candidates := append([][]byte{path}, ancestors(path)...)
+	for _, p := range candidates {
+		node, err := n.LookupNode(ctx, p, l)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if node.IsValueType() {
+			return n.readEntry(node.entry), p, nil
+		}
+	}
+	return nil, nil, notFound(path)
+}
+
+// Exists reports whether a value node exists on path. A missing path is not
+// an error; only load and context errors are returned.
+func (n *Node) Exists(ctx context.Context, path []byte, l Loader) (bool, error) {
+	node, err := n.LookupNode(ctx, path, l)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return node.IsValueType(), nil
 }
 
 // Add adds an entry to the path
 func (n *Node) Add(ctx context.Context, path, entry []byte, metadata map[string]string, ls LoadSaver) error {
+	if err := n.checkPath(path); err != nil {
+		return err
+	}
+	nn, err := newEntryNode(path, entry, metadata)
+	if err != nil {
+		return err
+	}
+	n.warnZeroEntryDirs(ctx, path, nn, ls)
+	return n.addNode(ctx, path, nn, ls)
+}
+
+// MakeDir adds an explicitly created empty directory on path, appending a
+// separator if path does not end with one.
+func (n *Node) MakeDir(ctx context.Context, path []byte, metadata map[string]string, ls LoadSaver) error {
+	if len(path) == 0 {
+		return ErrEmptyPath
+	}
+	if p := withTrailingSlash(path); p != nil {
+		path = p
+	}
+	if err := n.checkPath(path); err != nil {
+		return err
+	}
+	nn, err := newEntryNode(path, make([]byte, len(zero32)), metadata)
+	if err != nil {
+		return err
+	}
+	return n.addNode(ctx, path, nn, ls)
+}
+
+// AddCounting is like Add but also returns the number of intermediate nodes
+// created to insert the entry.
+func (n *Node) AddCounting(ctx context.Context, path, entry []byte, metadata map[string]string, ls LoadSaver) (nodesCreated int, err error) {
+	if err := n.checkPath(path); err != nil {
+		return 0, err
+	}
+	nn, err := newEntryNode(path, entry, metadata)
+	if err != nil {
+		return 0, err
+	}
+	n.warnZeroEntryDirs(ctx, path, nn, ls)
+	err = n.addNodeCounting(ctx, path, nn, ls, &nodesCreated)
+	return nodesCreated, err
+}
+
+// newEntryNode constructs the node holding entry for path.
+func newEntryNode(path, entry []byte, metadata map[string]string) (*Node, error) {
 	nn := New()
 	nn.entry = entry
 
 	if bytes.Equal(nn.entry, zero32) {
 		if path[len(path)-1] != PathSeparator {
-			return ErrInvalidFile
+			return nil, ErrInvalidFile
 		}
 		nn.makeEmptyDirectory()
 	} else {
@@ -240,7 +676,38 @@ func (n *Node) Add(ctx context.Context, path, entry []byte, metadata map[string]
 		nn.makeWithMetadata()
 	}
 
-	return n.addNode(ctx, path, nn, ls)
+	return nn, nil
+}
+
+// AddIfAbsent adds the entry only if no value exists on path. It reports
+// whether the entry was added and returns ErrIsDirectory if path is a
+// directory.
+func (n *Node) AddIfAbsent(ctx context.Context, path, entry []byte, metadata map[string]string, ls LoadSaver) (added bool, err error) {
+	isDirPath := len(path) > 0 && path[len(path)-1] == PathSeparator
+	node, err := n.LookupNode(ctx, path, ls)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		// directory may be part of a collapsed prefix
+		if isDirPath {
+			exists, err := n.HasPrefix(ctx, path, ls)
+			if err != nil {
+				return false, err
+			}
+			if exists {
+				return false, fmt.Errorf("path '%s': %w", path, ErrIsDirectory)
+			}
+		}
+	case err != nil:
+		return false, err
+	case node.IsValueType():
+		return false, nil
+	case node.IsEmptyDirectory() || (node.IsEdgeType() && isDirPath):
+		return false, fmt.Errorf("path '%s': %w", path, ErrIsDirectory)
+	}
+	if err := n.Add(ctx, path, entry, metadata, ls); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 func (n *Node) updateIsWithPathSeparator(path []byte) {
@@ -268,26 +735,34 @@ func (n *Node) Remove(ctx context.Context, path []byte, ls LoadSaver) error {
 	}
 	f := n.forks[path[0]]
 	if f == nil {
-		return ErrNotFound
+		return notFound(path)
 	}
 	if len(f.prefix) <= len(path) {
 		if !bytes.HasPrefix(path, f.prefix) {
-			return ErrNotFound
+			return notFound(path)
 		}
 		rest := path[len(f.prefix):]
 		if len(rest) == 0 {
+			if f.forks == nil {
+				if err := f.load(ctx, ls); err != nil {
+					return err
+				}
+			}
 			if f.IsValueType() {
 				f.makeNotValue()
 			}
+			// the path separator type may stem from a longer path than the
+			// prefix, so the prefix must hold a separator to keep a directory
+			dir := bytes.LastIndexByte(f.prefix, PathSeparator) + 1
 			// first slash not recognized as path type
-			if f.prefix[0] == PathSeparator || f.IsWithPathSeparatorType() {
+			if dir > 0 && (f.prefix[0] == PathSeparator || f.IsWithPathSeparatorType()) {
 				f.forks = make(map[byte]*fork, 0)
-				f.prefix = f.prefix[:bytes.LastIndexByte(f.prefix, PathSeparator)+1]
+				f.prefix = f.prefix[:dir]
 				copy(f.entry, zero32)
 				f.makeEmptyDirectory()
 				f.updateIsWithPathSeparator(f.prefix)
-			}
-			if (f.prefix[0] != PathSeparator && !f.IsWithPathSeparatorType()) && len(f.forks) == 0 {
+				f.reborn()
+			} else if len(f.forks) == 0 {
 				delete(n.forks, path[0])
 			}
 			// clear ref
@@ -296,12 +771,12 @@ func (n *Node) Remove(ctx context.Context, path []byte, ls LoadSaver) error {
 		}
 		err := f.Node.Remove(ctx, rest, ls)
 		if err != nil {
-			return err
+			return descended(err, path, f.prefix)
 		}
 	} else {
 		// must match directory leading
 		if path[len(path)-1] != PathSeparator && !bytes.HasPrefix(f.prefix, path) {
-			return ErrNotFound
+			return notFound(path)
 		}
 		f.prefix = f.prefix[:len(path)]
 		if len(f.prefix) == 0 {
@@ -318,23 +793,104 @@ func (n *Node) Remove(ctx context.Context, path []byte, ls LoadSaver) error {
 		n.reborn()
 		return nil
 	}
-	if len(f.forks) == 1 {
-		var ff *fork
-		for _, fork := range f.forks {
-			ff = fork
-		}
-		// merge fork
-		delete(n.forks, f.prefix[0])
-		err := n.addNode(ctx, append(f.prefix, ff.prefix...), ff.Node, ls)
-		if err != nil {
-			return err
-		}
+	if err := n.mergeFork(ctx, path[0], ls); err != nil {
+		return err
 	}
 	// clear parent ref recursively
 	n.reborn()
 	return nil
 }
 
+// RemoveDir removes the directory on path, which must end with a path
+// separator, and everything under it, leaving an empty directory in its
+// place like Remove does. ErrInvalidPath is returned for a path without a
+// trailing separator and ErrNotDirectory if the path names a file.
+func (n *Node) RemoveDir(ctx context.Context, path []byte, ls LoadSaver) error {
+	if len(path) == 0 {
+		return ErrEmptyPath
+	}
+	if path[len(path)-1] != PathSeparator {
+		return fmt.Errorf("path '%s' does not end with '%c': %w", path, PathSeparator, ErrInvalidPath)
+	}
+	exists, err := n.HasPrefix(ctx, path, ls)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		file := path[:len(path)-1]
+		if len(file) > 0 {
+			if ok, err := n.Exists(ctx, file, ls); err != nil {
+				return err
+			} else if ok {
+				return fmt.Errorf("path '%s': %w", file, ErrNotDirectory)
+			}
+		}
+		return notFound(path)
+	}
+	return n.Remove(ctx, path, ls)
+}
+
+// RemoveFile removes the value on path, which must not be a directory.
+// Other paths under a value that also leads to further paths are kept.
+// ErrIsDirectory is returned for a path with a trailing separator or naming
+// a directory.
+func (n *Node) RemoveFile(ctx context.Context, path []byte, ls LoadSaver) error {
+	if len(path) == 0 {
+		return ErrEmptyPath
+	}
+	if path[len(path)-1] == PathSeparator {
+		return fmt.Errorf("path '%s': %w", path, ErrIsDirectory)
+	}
+	spine, err := n.lookupSpine(ctx, path, ls)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err == nil {
+		if node := spine[len(spine)-1]; node.IsValueType() {
+			if len(node.forks) == 0 {
+				return n.Remove(ctx, path, ls)
+			}
+			node.makeNotValue()
+			rebornSpine(spine)
+			return nil
+		}
+	}
+	isDir, err := n.HasPrefix(ctx, withTrailingSlash(path), ls)
+	if err != nil {
+		return err
+	}
+	if isDir {
+		return fmt.Errorf("path '%s': %w", path, ErrIsDirectory)
+	}
+	return notFound(path)
+}
+
+// mergeFork merges the single remaining child of the fork on key into the
+// fork itself, unless the fork node carries its own value, directory marker
+// or metadata.
+func (n *Node) mergeFork(ctx context.Context, key byte, ls LoadSaver) error {
+	f := n.forks[key]
+	if f == nil || len(f.forks) != 1 || f.IsValueType() || f.IsEmptyDirectory() || f.IsWithMetadataType() {
+		return nil
+	}
+	var ff *fork
+	for _, fork := range f.forks {
+		ff = fork
+	}
+	// the child is cloned into the merged fork, so its forks must be present
+	if ff.forks == nil {
+		if err := ff.load(ctx, ls); err != nil {
+			return err
+		}
+	}
+	// build the merged path before dropping f, without aliasing f.prefix
+	mergedPath := make([]byte, 0, len(f.prefix)+len(ff.prefix))
+	mergedPath = append(mergedPath, f.prefix...)
+	mergedPath = append(mergedPath, ff.prefix...)
+	delete(n.forks, key)
+	return n.addNode(ctx, mergedPath, ff.Node, ls)
+}
+
 func common(a, b []byte) (c []byte) {
 	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
 		c = append(c, a[i])
@@ -344,6 +900,10 @@ func common(a, b []byte) (c []byte) {
 
 // HasPrefix tests whether the node contains prefix path.
 func (n *Node) HasPrefix(ctx context.Context, path []byte, l Loader) (bool, error) {
+	return n.hasPrefix(ctx, path, n.budgeted(l))
+}
+
+func (n *Node) hasPrefix(ctx context.Context, path []byte, l Loader) (bool, error) {
 	select {
 	case <-ctx.Done():
 		return false, ctx.Err()
@@ -363,7 +923,7 @@ func (n *Node) HasPrefix(ctx context.Context, path []byte, l Loader) (bool, erro
 	}
 	c := common(f.prefix, path)
 	if len(c) == len(f.prefix) {
-		return f.Node.HasPrefix(ctx, path[len(c):], l)
+		return f.Node.hasPrefix(ctx, path[len(c):], l)
 	}
 	if bytes.HasPrefix(f.prefix, path) {
 		return true, nil
@@ -371,6 +931,67 @@ func (n *Node) HasPrefix(ctx context.Context, path []byte, l Loader) (bool, erro
 	return false, nil
 }
 
+// HasAnyPrefix reports for each of prefixes, keyed by the prefix as a string,
+// whether HasPrefix would return true for it. Prefixes sharing a path are
+// resolved in a single descent, so every node is loaded at most once.
+func (n *Node) HasAnyPrefix(ctx context.Context, prefixes [][]byte, l Loader) (map[string]bool, error) {
+	exists := make(map[string]bool, len(prefixes))
+	if len(prefixes) == 0 {
+		return exists, nil
+	}
+	pending := make([]prefixQuery, len(prefixes))
+	for i, p := range prefixes {
+		pending[i] = prefixQuery{key: string(p), rest: p}
+	}
+	if err := n.hasAnyPrefix(ctx, pending, exists, n.budgeted(l)); err != nil {
+		return nil, err
+	}
+	return exists, nil
+}
+
+// prefixQuery is a prefix of HasAnyPrefix and its part left to resolve.
+type prefixQuery struct {
+	key  string
+	rest []byte
+}
+
+func (n *Node) hasAnyPrefix(ctx context.Context, pending []prefixQuery, exists map[string]bool, l Loader) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, l); err != nil {
+			return err
+		}
+	}
+	descend := make(map[byte][]prefixQuery)
+	for _, q := range pending {
+		if len(q.rest) == 0 {
+			exists[q.key] = true
+			continue
+		}
+		f := n.forks[q.rest[0]]
+		if f == nil {
+			exists[q.key] = false
+			continue
+		}
+		c := common(f.prefix, q.rest)
+		if len(c) == len(f.prefix) {
+			descend[q.rest[0]] = append(descend[q.rest[0]], prefixQuery{key: q.key, rest: q.rest[len(c):]})
+			continue
+		}
+		exists[q.key] = bytes.HasPrefix(f.prefix, q.rest)
+	}
+	for b, qs := range descend {
+		if err := n.forks[b].Node.hasAnyPrefix(ctx, qs, exists, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (n *Node) clone(other *Node) {
 	if len(n.entry) == 0 {
 		n.entry = make([]byte, len(other.entry))
@@ -390,31 +1011,138 @@ func (n *Node) clone(other *Node) {
 	if len(other.obfuscationKey) > 0 {
 		n.SetObfuscationKey(other.obfuscationKey)
 	}
+	if other.codec != nil {
+		n.codec = other.codec
+	}
+}
+
+// deepCopy returns a copy of the in-memory trie rooted at n that shares no
+// mutable state with it. Nodes that are not loaded yet are copied by
+// reference and load independently.
+func (n *Node) deepCopy() *Node {
+	nn := &Node{
+		nodeType:       n.nodeType,
+		refBytesSize:   n.refBytesSize,
+		index:          n.index,
+		obfuscationKey: copyBytes(n.obfuscationKey),
+		ref:            copyBytes(n.ref),
+		entry:          copyBytes(n.entry),
+		opts:           n.opts,
+		codec:          n.codec,
+	}
+	if n.metadata != nil {
+		nn.metadata = make(map[string]string, len(n.metadata))
+		for k, v := range n.metadata {
+			nn.metadata[k] = v
+		}
+	}
+	if n.forks != nil {
+		nn.forks = make(map[byte]*fork, len(n.forks))
+		for b, f := range n.forks {
+			nn.forks[b] = &fork{copyBytes(f.prefix), f.Node.deepCopy()}
+		}
+	}
+	return nn
+}
+
+// Branch returns an independent manifest with the same content as n.
+// Persisted subtrees are shared by reference and loaded separately by each
+// side on demand, so only the nodes changed since the last save are copied.
+// Edits to either side do not affect the other.
+func (n *Node) Branch() *Node {
+	nn := &Node{
+		nodeType:       n.nodeType,
+		refBytesSize:   n.refBytesSize,
+		index:          n.index,
+		obfuscationKey: copyBytes(n.obfuscationKey),
+		ref:            copyBytes(n.ref),
+		entry:          copyBytes(n.entry),
+		metadata:       n.Metadata(),
+		opts:           n.opts,
+		codec:          n.codec,
+	}
+	if n.ref != nil || n.forks == nil {
+		return nn
+	}
+	nn.forks = make(map[byte]*fork, len(n.forks))
+	for b, f := range n.forks {
+		nn.forks[b] = &fork{copyBytes(f.prefix), f.Node.Branch()}
+	}
+	return nn
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
 }
 
 func (n *Node) reborn() {
 	n.ref = nil
+	n.countValid = false
+}
+
+// lookupSpine returns the nodes from n down to the node on path, loading
+// them as needed.
+func (n *Node) lookupSpine(ctx context.Context, path []byte, l Loader) ([]*Node, error) {
+	nodes := []*Node{n}
+	cur := n
+	rest := path
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		if cur.forks == nil {
+			if err := cur.load(ctx, l); err != nil {
+				return nil, err
+			}
+		}
+		if len(rest) == 0 {
+			return nodes, nil
+		}
+		f := cur.forks[rest[0]]
+		if f == nil || !bytes.HasPrefix(rest, f.prefix) {
+			return nil, notFound(path)
+		}
+		rest = rest[len(f.prefix):]
+		cur = f.Node
+		nodes = append(nodes, cur)
+	}
+}
+
+// rebornSpine clears the references of all nodes on a spine.
+func rebornSpine(nodes []*Node) {
+	for _, node := range nodes {
+		node.reborn()
+	}
 }
 
 func (n *Node) addNode(ctx context.Context, path []byte, node *Node, ls LoadSaver) error {
+	return n.addNodeCounting(ctx, path, node, ls, nil)
+}
+
+// addNodeCounting adds node on path, incrementing created, if not nil, for
+// every intermediate node constructed.
+func (n *Node) addNodeCounting(ctx context.Context, path []byte, node *Node, ls LoadSaver, created *int) error {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
 	}
-	if n.refBytesSize == 0 {
-		if len(node.entry) > 256 {
-			return fmt.Errorf("node entry size > 256: %d", len(node.entry))
-		}
-		// empty entry for directories
-		if len(node.entry) > 0 {
-			n.refBytesSize = len(node.entry)
-		}
-	} else if len(node.entry) > 0 && n.refBytesSize != len(node.entry) {
-		return fmt.Errorf("invalid entry size: %d, expected: %d", len(node.entry), n.refBytesSize)
+	if err := n.fitEntrySize(node.entry); err != nil {
+		return err
 	}
 
 	if len(path) == 0 {
+		// keep the children of a node only known by reference
+		if n.forks == nil {
+			if err := n.load(ctx, ls); err != nil {
+				return err
+			}
+		}
 		n.clone(node)
 		n.reborn()
 		return nil
@@ -429,25 +1157,11 @@ func (n *Node) addNode(ctx context.Context, path []byte, node *Node, ls LoadSave
 	if f == nil {
 		// check for prefix size limit
 		if len(path) > nodePrefixMaxSize {
-			prefix := path[:nodePrefixMaxSize]
-			rest := path[nodePrefixMaxSize:]
-			nn := New()
-			if len(n.obfuscationKey) > 0 {
-				nn.SetObfuscationKey(n.obfuscationKey)
-			}
-			nn.refBytesSize = n.refBytesSize
-			err := nn.addNode(ctx, rest, node, ls)
-			if err != nil {
-				return err
-			}
-			nn.updateIsWithPathSeparator(prefix)
-			n.forks[path[0]] = &fork{prefix, nn}
-			n.reborn()
-			n.makeEdge()
-			return nil
+			return n.addChain(ctx, path, node, ls, created)
 		}
 		if node.ref != nil {
 			nn := New()
+			countNode(created)
 			nn.clone(node)
 			node = nn
 		} else {
@@ -470,6 +1184,7 @@ func (n *Node) addNode(ctx context.Context, path []byte, node *Node, ls LoadSave
 	if len(rest) > 0 {
 		// move current common prefix node
 		nn = New()
+		countNode(created)
 		if len(n.obfuscationKey) > 0 {
 			nn.SetObfuscationKey(n.obfuscationKey)
 		}
@@ -486,7 +1201,7 @@ func (n *Node) addNode(ctx context.Context, path []byte, node *Node, ls LoadSave
 		nn.clone(node)
 		n.forks[path[0]] = &fork{path, nn}
 	} else {
-		err := nn.addNode(ctx, path[len(c):], node, ls)
+		err := nn.addNodeCounting(ctx, path[len(c):], node, ls, created)
 		if err != nil {
 			return err
 		}
@@ -497,103 +1212,264 @@ func (n *Node) addNode(ctx context.Context, path []byte, node *Node, ls LoadSave
 	return nil
 }
 
+// fitEntrySize adopts the size of entry as the reference size of the node
+// if it has none yet, or checks that entry has the reference size.
+func (n *Node) fitEntrySize(entry []byte) error {
+	if n.refBytesSize == 0 {
+		if len(entry) > 256 {
+			return fmt.Errorf("node entry size > 256: %d", len(entry))
+		}
+		// empty entry for directories
+		if len(entry) > 0 {
+			n.refBytesSize = len(entry)
+		}
+	} else if len(entry) > 0 && n.refBytesSize != len(entry) {
+		return fmt.Errorf("invalid entry size: %d, expected: %d", len(entry), n.refBytesSize)
+	}
+	return nil
+}
+
+// addChain adds node on a path too long for a single fork prefix by
+// chaining intermediate nodes of nodePrefixMaxSize long prefixes. The chain
+// is built iteratively and only attached to n once complete, so paths have
+// no maximum length and their size does not bound the stack depth.
+func (n *Node) addChain(ctx context.Context, path []byte, node *Node, ls LoadSaver, created *int) error {
+	var head, last *Node
+	headPrefix := path[:nodePrefixMaxSize]
+	for len(path) > nodePrefixMaxSize {
+		prefix := path[:nodePrefixMaxSize]
+		nn := New()
+		countNode(created)
+		if len(n.obfuscationKey) > 0 {
+			nn.SetObfuscationKey(n.obfuscationKey)
+		}
+		nn.refBytesSize = n.refBytesSize
+		nn.updateIsWithPathSeparator(prefix)
+		if last == nil {
+			head = nn
+		} else {
+			last.forks[prefix[0]] = &fork{prefix, nn}
+			last.makeEdge()
+		}
+		last = nn
+		path = path[nodePrefixMaxSize:]
+	}
+	if err := last.addNodeCounting(ctx, path, node, ls, created); err != nil {
+		return err
+	}
+	n.forks[headPrefix[0]] = &fork{headPrefix, head}
+	n.reborn()
+	n.makeEdge()
+	return nil
+}
+
+func countNode(created *int) {
+	if created != nil {
+		*created++
+	}
+}
+
+// Link adds newPath as an independent value node sharing the entry and
+// metadata of the value node on existingPath.
+func (n *Node) Link(ctx context.Context, existingPath, newPath []byte, ls LoadSaver) error {
+	node, err := n.LookupNode(ctx, existingPath, ls)
+	if err != nil {
+		return err
+	}
+	if !node.IsValueType() {
+		return notFound(existingPath)
+	}
+	var metadata map[string]string
+	if len(node.metadata) > 0 {
+		metadata = make(map[string]string, len(node.metadata))
+		for k, v := range node.metadata {
+			metadata[k] = v
+		}
+	}
+	entry := append([]byte{}, node.entry...)
+	return n.Add(ctx, newPath, entry, metadata, ls)
+}
+
 func (n *Node) Copy(ctx context.Context, target *Node, path, newPath []byte, create bool, ls LoadSaver) error {
-	return n.move(ctx, target, path, newPath, create, true, ls)
+	return n.move(ctx, target, path, newPath, MoveOptions{Create: create}, true, ls)
 }
 
+// Move moves path to newPath on target, which may be n itself. If any step
+// fails, neither n nor target is modified.
 func (n *Node) Move(ctx context.Context, target *Node, path, newPath []byte, create bool, ls LoadSaver) error {
-	return n.move(ctx, target, path, newPath, create, false, ls)
+	return n.move(ctx, target, path, newPath, MoveOptions{Create: create}, false, ls)
 }
 
-func (n *Node) move(ctx context.Context, target *Node, path, newPath []byte, create, keepOrigin bool, ls LoadSaver) error {
+// MoveOptions configures MoveWithOptions.
+type MoveOptions struct {
+	// Create allows moving to a newPath whose parent does not exist yet.
+	Create bool
+	// PruneEmpty removes the empty directories the move leaves behind at the
+	// source. Empty directories that existed before the move or that hold
+	// metadata are kept.
+	PruneEmpty bool
+}
+
+// MoveWithOptions moves path to newPath on target like Move, configured by
+// opts.
+func (n *Node) MoveWithOptions(ctx context.Context, target *Node, path, newPath []byte, opts MoveOptions, ls LoadSaver) error {
+	return n.move(ctx, target, path, newPath, opts, false, ls)
+}
+
+// move performs the move in place, recording the nodes along the changed
+// paths first and restoring them if any step fails, so a failure leaves both
+// n and target unchanged.
+func (n *Node) move(ctx context.Context, target *Node, path, newPath []byte, opts MoveOptions, keepOrigin bool, ls LoadSaver) error {
+	adds, removePath, err := n.planMove(ctx, target, path, newPath, opts.Create, ls)
+	if err != nil {
+		return err
+	}
+	var kept map[string]struct{}
+	if opts.PruneEmpty && !keepOrigin {
+		if kept, err = n.emptyDirsAround(ctx, path, ls); err != nil {
+			return err
+		}
+	}
+
+	undo := newUndoLog()
+	for _, a := range adds {
+		undo.recordPath(target, a.path)
+	}
+	if !keepOrigin {
+		undo.recordPath(n, removePath)
+	}
+	if err := n.applyMove(ctx, target, path, removePath, adds, kept, keepOrigin, ls); err != nil {
+		undo.rollback()
+		return err
+	}
+	return nil
+}
+
+// moveAdd is a copy of a moved node and the path it is added on.
+type moveAdd struct {
+	path []byte
+	node *Node
+}
+
+// planMove resolves a move without modifying n or target. It returns the
+// nodes to add to target and the path to remove from n.
+func (n *Node) planMove(ctx context.Context, target *Node, path, newPath []byte, create bool, ls LoadSaver) (adds []moveAdd, removePath []byte, err error) {
 	if len(path) == 0 {
-		return ErrEmptyPath
+		return nil, nil, ErrEmptyPath
 	}
 
 	sourceDir := path[len(path)-1] == PathSeparator
 	targetDir := newPath[len(newPath)-1] == PathSeparator
 
 	if sourceDir && !targetDir {
-		return ErrForbiddenAction
+		return nil, nil, ErrForbiddenAction
 	}
 
 	if target == n && bytes.HasPrefix(newPath, path) {
-		return ErrForbiddenAction
+		return nil, nil, ErrForbiddenAction
 	}
 
 	source, sourcePrefix, err := n.lookupClosest(ctx, path, ls)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	sourcePath := sourcePrefix
-	if !sourceDir {
+	removePath = path
+	if sourceDir {
+		removePath = append(append([]byte{}, path...), sourcePrefix...)
+	} else {
 		if !source.IsValueType() {
-			return ErrNotFound
+			return nil, nil, ErrNotFound
 		}
 		sourcePath = path[bytes.LastIndexByte(path, PathSeparator)+1:]
 		// clone value node
 		nn := New()
 		nn.makeValue()
-		nn.entry = source.entry
+		nn.entry = copyBytes(source.entry)
 		if len(source.obfuscationKey) > 0 {
 			nn.SetObfuscationKey(source.obfuscationKey)
 		}
 		nn.ref = source.ref
 		nn.refBytesSize = source.refBytesSize
-		nn.metadata = source.metadata
+		nn.metadata = source.Metadata()
 		source = nn
 	}
 
 	if !create {
 		_, _, err = target.lookupClosest(ctx, newPath, ls)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 	}
 
 	targetPath := newPath
 	if targetDir {
-		targetPath = append(newPath, sourcePath...)
+		targetPath = append(append([]byte{}, newPath...), sourcePath...)
 	}
 
 	if err := source.load(ctx, ls); err != nil {
-		return err
+		return nil, nil, err
 	}
 
+	// the copies must not share mutable nodes with the source
 	if len(source.forks) == 0 {
-		err = target.addNode(ctx, targetPath, source, ls)
-		if err != nil {
-			return err
-		}
-	} else {
-		for _, node := range source.forks {
-			addPath := make([]byte, len(targetPath))
-			copy(addPath, targetPath)
-			addPath = append(addPath, node.prefix...)
-			if err := node.load(ctx, ls); err != nil {
-				return err
-			}
-			err = target.addNode(ctx, addPath, node.Node, ls)
-			if err != nil {
-				return err
-			}
+		return []moveAdd{{targetPath, source.deepCopy()}}, removePath, nil
+	}
+	for _, node := range source.forks {
+		addPath := make([]byte, len(targetPath))
+		copy(addPath, targetPath)
+		addPath = append(addPath, node.prefix...)
+		if err := node.load(ctx, ls); err != nil {
+			return nil, nil, err
 		}
+		adds = append(adds, moveAdd{addPath, node.Node.deepCopy()})
 	}
+	return adds, removePath, nil
+}
 
-	if !keepOrigin {
-		if sourceDir {
-			err = n.Remove(ctx, append(path, sourcePrefix...), ls)
-			if err != nil {
-				return err
-			}
-		} else {
-			err = n.Remove(ctx, path, ls)
-			if err != nil {
-				return err
-			}
+// applyMove adds the planned nodes to target and, unless keepOrigin is set,
+// removes removePath from n and prunes the empty directories around path not
+// in kept if kept is not nil.
+func (n *Node) applyMove(ctx context.Context, target *Node, path, removePath []byte, adds []moveAdd, kept map[string]struct{}, keepOrigin bool, ls LoadSaver) error {
+	for _, a := range adds {
+		if err := target.addNode(ctx, a.path, a.node, ls); err != nil {
+			return err
 		}
 	}
+	if keepOrigin {
+		return nil
+	}
+	if err := n.Remove(ctx, removePath, ls); err != nil {
+		return err
+	}
+	if kept != nil {
+		return n.pruneEmptyDirs(ctx, path, kept, ls)
+	}
+	return nil
+}
 
+// pruneEmptyDirs removes the empty directories on, above or below path that
+// are not in kept and hold no metadata.
+func (n *Node) pruneEmptyDirs(ctx context.Context, path []byte, kept map[string]struct{}, ls LoadSaver) error {
+	after, err := n.emptyDirsAround(ctx, path, ls)
+	if err != nil {
+		return err
+	}
+	for dir := range after {
+		if _, ok := kept[dir]; ok {
+			continue
+		}
+		node, err := n.LookupNode(ctx, []byte(dir), ls)
+		if err != nil {
+			return err
+		}
+		if len(node.metadata) > 0 {
+			continue
+		}
+		if err := n.removeSubtree(ctx, []byte(dir), ls); err != nil {
+			return err
+		}
+	}
 	return nil
 }
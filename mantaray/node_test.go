@@ -9,7 +9,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strconv"
+	"reflect"
 	"testing"
 
 	"github.com/FavorLabs/manifest/mantaray"
@@ -59,6 +59,232 @@ func TestAddAndLookup(t *testing.T) {
 	}
 }
 
+func TestAddLongPath(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	long := bytes.Repeat([]byte("abcdefghij"), 1024)
+	sibling := append(append([]byte{}, long[:5000]...), 'x')
+	n := mantaray.New()
+	for i, c := range [][]byte{long, sibling} {
+		if err := n.Add(ctx, c, bytes.Repeat([]byte{byte(i + 1)}, 32), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	n = mantaray.NewNodeRef(n.Reference())
+	for i, c := range [][]byte{long, sibling} {
+		m, err := n.Lookup(ctx, c, ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if e := bytes.Repeat([]byte{byte(i + 1)}, 32); !bytes.Equal(m, e) {
+			t.Fatalf("expected value %x, got %x", e, m)
+		}
+	}
+	if _, err := n.Lookup(ctx, long[:len(long)-1], ls); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestLookupMany(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "img/1.png", "img/2.png", "index.html")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	paths := [][]byte{
+		[]byte("index.html"),
+		[]byte("img/3.png"),
+		[]byte("img/2.png"),
+		[]byte("robots.txt"),
+		[]byte("img/1.png"),
+	}
+
+	cl := &countingLoader{LoadSaver: ls}
+	entries, errs, err := mantaray.NewNodeRef(n.Reference()).LookupMany(ctx, paths, cl)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i, p := range paths {
+		switch string(p) {
+		case "img/3.png", "robots.txt":
+			if !errors.Is(errs[i], mantaray.ErrNotFound) {
+				t.Fatalf("path %s: expected not found error, got %v", p, errs[i])
+			}
+		default:
+			if errs[i] != nil {
+				t.Fatalf("path %s: expected no error, got %v", p, errs[i])
+			}
+			if e := entry(string(p)); !bytes.Equal(entries[i], e) {
+				t.Fatalf("path %s: expected value %x, got %x", p, e, entries[i])
+			}
+		}
+	}
+
+	// every node is loaded once
+	all := &countingLoader{LoadSaver: ls}
+	if err := mantaray.NewNodeRef(n.Reference()).LoadAll(ctx, all); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cl.loads > all.loads {
+		t.Fatalf("expected at most %d loads, got %d", all.loads, cl.loads)
+	}
+
+	// loader failures fail the call
+	_, _, err = mantaray.NewNodeRef(n.Reference()).LookupMany(ctx, paths, &failingLoader{LoadSaver: ls, ref: n.Reference()})
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+
+	// the results are those of Lookup, under the lookup options too
+	if err := n.MakeDir(ctx, []byte("img/empty"), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	paths = append(paths, []byte("img"), []byte("img/"), []byte("img/empty"), []byte("img/empty/"), []byte("img/1"), []byte(""))
+	for _, opts := range []mantaray.Options{
+		{},
+		{TrailingSlashInsensitive: true},
+		{TrailingSlashInsensitive: true, ResolveDirsAsValues: true},
+	} {
+		m := mantaray.NewNodeRef(n.Reference())
+		m.SetOptions(opts)
+		entries, errs, err := m.LookupMany(ctx, paths, ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for i, p := range paths {
+			m := mantaray.NewNodeRef(n.Reference())
+			m.SetOptions(opts)
+			e, err := m.Lookup(ctx, p, ls)
+			if !bytes.Equal(entries[i], e) || !reflect.DeepEqual(errs[i], err) {
+				t.Fatalf("options %+v, path %q: expected %x, %v, got %x, %v", opts, p, e, err, entries[i], errs[i])
+			}
+		}
+	}
+}
+
+func TestLookupWithMetadata(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "img/1.png", "robots.txt")
+	meta := map[string]string{"content-type": "text/html"}
+	value := bytes.Repeat([]byte{1}, 32)
+	if err := n.Add(ctx, []byte("index.html"), value, meta, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	m := mantaray.NewNodeRef(n.Reference())
+	e, md, err := m.LookupWithMetadata(ctx, []byte("index.html"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(e, value) || !reflect.DeepEqual(md, meta) {
+		t.Fatalf("expected entry and metadata %x and %v, got %x and %v", value, meta, e, md)
+	}
+
+	// the returned metadata is a copy
+	md["content-type"] = "text/plain"
+	if _, md, err = m.LookupWithMetadata(ctx, []byte("index.html"), ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(md, meta) {
+		t.Fatalf("expected metadata %v, got %v", meta, md)
+	}
+
+	e, md, err = m.LookupWithMetadata(ctx, []byte("robots.txt"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if de := entry("robots.txt"); !bytes.Equal(e, de) || md != nil {
+		t.Fatalf("expected entry %x without metadata, got %x and %v", de, e, md)
+	}
+
+	for _, c := range []string{"img/", "img/2.png"} {
+		if _, _, err := m.LookupWithMetadata(ctx, []byte(c), ls); !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("path %s: expected not found error, got %v", c, err)
+		}
+	}
+}
+
+func TestLookupChain(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	override := newManifest(t, ls, "index.html", "css/site.css")
+	base := newManifest(t, ls, "index.html", "css/theme.css", "img/logo.png")
+	if err := override.Add(ctx, []byte("index.html"), bytes.Repeat([]byte{1}, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		path     string
+		manifest *mantaray.Node
+		entry    []byte
+	}{
+		{path: "index.html", manifest: override, entry: bytes.Repeat([]byte{1}, 32)},
+		{path: "css/site.css", manifest: override},
+		{path: "css/theme.css", manifest: base},
+		{path: "img/logo.png", manifest: base},
+	} {
+		e, m, err := mantaray.LookupChain(ctx, []byte(tc.path), ls, override, base)
+		if err != nil {
+			t.Fatalf("path %s: expected no error, got %v", tc.path, err)
+		}
+		if m != tc.manifest {
+			t.Fatalf("path %s: resolved by the wrong manifest", tc.path)
+		}
+		expected := tc.entry
+		if expected == nil {
+			expected = entry(tc.path)
+		}
+		if !bytes.Equal(e, expected) {
+			t.Fatalf("path %s: expected value %x, got %x", tc.path, expected, e)
+		}
+	}
+
+	if _, _, err := mantaray.LookupChain(ctx, []byte("img/missing.png"), ls, override, base); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if _, _, err := mantaray.LookupChain(ctx, []byte("index.html"), ls); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error for an empty chain, got %v", err)
+	}
+
+	// errors other than not found stop the search
+	if err := base.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	fl := &failingLoader{LoadSaver: ls, ref: base.Reference()}
+	if _, _, err := mantaray.LookupChain(ctx, []byte("img/logo.png"), fl, override, mantaray.NewNodeRef(base.Reference())); !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+}
+
+// spaWebsite is the build output of a single page application, with a
+// directory chain that collapses into one fork.
+var spaWebsite = [][]byte{
+	[]byte("assets/fonts/a.woff"),
+	[]byte("css/"),
+	[]byte("css/app.css"),
+	[]byte("favicon.ico"),
+	[]byte("img/"),
+	[]byte("img/logo.png"),
+	[]byte("index.html"),
+	[]byte("js/"),
+	[]byte("js/chunk-vendors.js.map"),
+	[]byte("js/chunk-vendors.js"),
+	[]byte("js/app.js.map"),
+	[]byte("js/app.js"),
+}
+
 func TestAddAndLookupNode(t *testing.T) {
 	for _, tc := range []struct {
 		name  string
@@ -114,20 +340,8 @@ func TestAddAndLookupNode(t *testing.T) {
 			},
 		},
 		{
-			name: "spa-website",
-			toAdd: [][]byte{
-				[]byte("css/"),
-				[]byte("css/app.css"),
-				[]byte("favicon.ico"),
-				[]byte("img/"),
-				[]byte("img/logo.png"),
-				[]byte("index.html"),
-				[]byte("js/"),
-				[]byte("js/chunk-vendors.js.map"),
-				[]byte("js/chunk-vendors.js"),
-				[]byte("js/app.js.map"),
-				[]byte("js/app.js"),
-			},
+			name:  "spa-website",
+			toAdd: spaWebsite,
 		},
 	} {
 		ctx := context.Background()
@@ -148,7 +362,7 @@ func TestAddAndLookupNode(t *testing.T) {
 						t.Fatalf("expected no error, got %v", err)
 					}
 					if !node.IsValueType() {
-						t.Fatalf("expected value type, got %v", strconv.FormatInt(int64(node.NodeType()), 2))
+						t.Fatalf("expected value type, got %v", node.TypeString())
 					}
 					de := append(make([]byte, 32-len(d)), d...)
 					if !bytes.Equal(node.Entry(), de) {
@@ -183,7 +397,7 @@ func TestAddAndLookupNode(t *testing.T) {
 					t.Fatalf("expected no error, got %v", err)
 				}
 				if !node.IsValueType() {
-					t.Fatalf("expected value type, got %v", strconv.FormatInt(int64(node.NodeType()), 2))
+					t.Fatalf("expected value type, got %v", node.TypeString())
 				}
 				de := append(make([]byte, 32-len(d)), d...)
 				if !bytes.Equal(node.Entry(), de) {
@@ -194,6 +408,28 @@ func TestAddAndLookupNode(t *testing.T) {
 	}
 }
 
+func TestTypeString(t *testing.T) {
+	for _, tc := range []struct {
+		nodeType uint8
+		expected string
+	}{
+		{nodeType: 0, expected: "none"},
+		{nodeType: 2, expected: "value"},
+		{nodeType: 4, expected: "edge"},
+		{nodeType: 2 | 4 | 16, expected: "value|edge|metadata"},
+		{nodeType: 4 | 8, expected: "edge|path-separator"},
+		{nodeType: 8 | 32, expected: "path-separator|empty-directory"},
+		{nodeType: 2 | 4 | 8 | 16 | 32, expected: "value|edge|path-separator|metadata|empty-directory"},
+		{nodeType: 1 | 2 | 128, expected: "value|0x81"},
+	} {
+		n := mantaray.New()
+		n.SetNodeType(tc.nodeType)
+		if got := n.TypeString(); got != tc.expected {
+			t.Fatalf("type %08b: expected %q, got %q", tc.nodeType, tc.expected, got)
+		}
+	}
+}
+
 func TestRemove(t *testing.T) {
 	for _, tc := range []struct {
 		name     string
@@ -277,6 +513,20 @@ func TestRemove(t *testing.T) {
 				[]byte("img/"),
 			},
 		},
+		{
+			name: "value-with-separator-type",
+			toAdd: []mantaray.NodeEntry{
+				{
+					Path: []byte("a"),
+				},
+				{
+					Path: []byte("a/a//a"),
+				},
+			},
+			toRemove: [][]byte{
+				[]byte("a"),
+			},
+		},
 		{
 			name: "long-prefix-merge",
 			toAdd: []mantaray.NodeEntry{
@@ -334,6 +584,170 @@ func TestRemove(t *testing.T) {
 	}
 }
 
+func TestRemoveDir(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name      string
+		toAdd     []string
+		path      string
+		expectErr error
+		removed   []string
+		kept      []string
+	}{
+		{
+			name:    "simple",
+			toAdd:   []string{"index.html", "img/1.png", "img/2.png", "robots.txt"},
+			path:    "img/",
+			removed: []string{"img/1.png", "img/2.png"},
+			kept:    []string{"index.html", "robots.txt"},
+		},
+		{
+			name:    "collapsed-prefix",
+			toAdd:   []string{"img/1.png", "robots.txt"},
+			path:    "img/",
+			removed: []string{"img/1.png"},
+			kept:    []string{"robots.txt"},
+		},
+		{
+			name:      "no-trailing-slash",
+			toAdd:     []string{"img/1.png", "robots.txt"},
+			path:      "img",
+			expectErr: mantaray.ErrInvalidPath,
+			kept:      []string{"img/1.png", "robots.txt"},
+		},
+		{
+			name:      "file",
+			toAdd:     []string{"img/1.png", "robots.txt"},
+			path:      "robots.txt/",
+			expectErr: mantaray.ErrNotDirectory,
+			kept:      []string{"img/1.png", "robots.txt"},
+		},
+		{
+			name:      "missing",
+			toAdd:     []string{"img/1.png", "robots.txt"},
+			path:      "video/",
+			expectErr: mantaray.ErrNotFound,
+			kept:      []string{"img/1.png", "robots.txt"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ls := newMockLoadSaver()
+			n := newManifest(t, ls, tc.toAdd...)
+			err := n.RemoveDir(ctx, []byte(tc.path), ls)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			checkRemoved(t, n, ls, tc.removed, tc.kept)
+		})
+	}
+}
+
+func TestRemoveFile(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name      string
+		toAdd     []string
+		path      string
+		expectErr error
+		removed   []string
+		kept      []string
+	}{
+		{
+			name:    "simple",
+			toAdd:   []string{"index.html", "img/1.png", "img/2.png", "robots.txt"},
+			path:    "img/1.png",
+			removed: []string{"img/1.png"},
+			kept:    []string{"index.html", "img/2.png", "robots.txt"},
+		},
+		{
+			name:    "collapsed-prefix",
+			toAdd:   []string{"img/1.png", "robots.txt"},
+			path:    "img/1.png",
+			removed: []string{"img/1.png"},
+			kept:    []string{"robots.txt"},
+		},
+		{
+			name:    "value-with-children",
+			toAdd:   []string{"img/a", "img/a/b.png", "robots.txt"},
+			path:    "img/a",
+			removed: []string{"img/a"},
+			kept:    []string{"img/a/b.png", "robots.txt"},
+		},
+		{
+			name:      "trailing-slash",
+			toAdd:     []string{"img/1.png", "robots.txt"},
+			path:      "img/",
+			expectErr: mantaray.ErrIsDirectory,
+			kept:      []string{"img/1.png", "robots.txt"},
+		},
+		{
+			name:      "collapsed-directory",
+			toAdd:     []string{"img/1.png", "robots.txt"},
+			path:      "img",
+			expectErr: mantaray.ErrIsDirectory,
+			kept:      []string{"img/1.png", "robots.txt"},
+		},
+		{
+			name:      "directory",
+			toAdd:     []string{"index.html", "img/1.png", "img/2.png", "robots.txt"},
+			path:      "img",
+			expectErr: mantaray.ErrIsDirectory,
+			kept:      []string{"index.html", "img/1.png", "img/2.png", "robots.txt"},
+		},
+		{
+			name:      "missing",
+			toAdd:     []string{"img/1.png", "robots.txt"},
+			path:      "img/3.png",
+			expectErr: mantaray.ErrNotFound,
+			kept:      []string{"img/1.png", "robots.txt"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ls := newMockLoadSaver()
+			n := newManifest(t, ls, tc.toAdd...)
+			err := n.RemoveFile(ctx, []byte(tc.path), ls)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			checkRemoved(t, n, ls, tc.removed, tc.kept)
+		})
+	}
+}
+
+// checkRemoved checks that the removed paths are gone and the kept ones
+// still hold their entries, before and after saving n.
+func checkRemoved(t *testing.T, n *mantaray.Node, ls mantaray.LoadSaver, removed, kept []string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, m := range []*mantaray.Node{n, mantaray.NewNodeRef(n.Reference())} {
+		for _, c := range removed {
+			if _, err := m.Lookup(ctx, []byte(c), ls); !errors.Is(err, mantaray.ErrNotFound) {
+				t.Fatalf("path %s: expected not found error, got %v", c, err)
+			}
+		}
+		for _, c := range kept {
+			e, err := m.Lookup(ctx, []byte(c), ls)
+			if err != nil {
+				t.Fatalf("path %s: expected no error, got %v", c, err)
+			}
+			if de := entry(c); !bytes.Equal(e, de) {
+				t.Fatalf("path %s: expected value %x, got %x", c, de, e)
+			}
+		}
+	}
+}
+
 func TestHasPrefix(t *testing.T) {
 	for _, tc := range []struct {
 		name        string
@@ -406,6 +820,65 @@ func TestHasPrefix(t *testing.T) {
 	}
 }
 
+func TestHasAnyPrefix(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "index.html", "img/1.png", "img/2.png", "img/icons/a.svg", "robots.txt", "some-path/file.ext")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		prefixes []string
+	}{
+		{
+			name:     "overlapping",
+			prefixes: []string{"img/", "img/1", "img/icons/", "img/icons/b", "img/3", "im", "images/"},
+		},
+		{
+			name:     "disjoint",
+			prefixes: []string{"index", "robots.txt", "some-path/", "some-other-path/", "videos/"},
+		},
+		{
+			name:     "empty-and-duplicate",
+			prefixes: []string{"", "img/", "img/"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			prefixes := make([][]byte, len(tc.prefixes))
+			for i, p := range tc.prefixes {
+				prefixes[i] = []byte(p)
+			}
+			cl := &countingLoader{LoadSaver: ls}
+			exists, err := mantaray.NewNodeRef(n.Reference()).HasAnyPrefix(ctx, prefixes, cl)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			var singleLoads int64
+			for _, p := range tc.prefixes {
+				single := &countingLoader{LoadSaver: ls}
+				expected, err := mantaray.NewNodeRef(n.Reference()).HasPrefix(ctx, []byte(p), single)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				singleLoads += single.loads
+				got, ok := exists[p]
+				if !ok {
+					t.Fatalf("prefix %q: missing from result", p)
+				}
+				if got != expected {
+					t.Fatalf("prefix %q: expected %t, got %t", p, expected, got)
+				}
+			}
+			if cl.loads >= singleLoads {
+				t.Fatalf("expected fewer than %d loads, got %d", singleLoads, cl.loads)
+			}
+		})
+	}
+}
+
 func TestMove(t *testing.T) {
 	for _, tc := range []struct {
 		toAdd    [][]byte
@@ -658,3 +1131,914 @@ func TestMove(t *testing.T) {
 		})
 	}
 }
+
+// failingLoader fails to load the node with the given reference.
+type failingLoader struct {
+	mantaray.LoadSaver
+	ref []byte
+}
+
+var errInjected = errors.New("injected failure")
+
+func (f *failingLoader) Load(ctx context.Context, ref []byte, index int64) ([]byte, error) {
+	if bytes.Equal(ref, f.ref) {
+		return nil, errInjected
+	}
+	return f.LoadSaver.Load(ctx, ref, index)
+}
+
+func TestCopyDirectoryIsolated(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	paths := []string{"src/a.txt", "src/sub/b.txt", "src/sub/c.txt"}
+	for _, tc := range []struct {
+		name  string
+		saved bool
+	}{
+		{name: "in-memory"},
+		{name: "loaded", saved: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := newManifest(t, ls, paths...)
+			if tc.saved {
+				if err := n.Save(ctx, ls); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				n = mantaray.NewNodeRef(n.Reference())
+				if err := n.LoadAll(ctx, ls); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+			if err := n.Copy(ctx, n, []byte("src/"), []byte("dst/"), true, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			for _, c := range []string{"dst/a.txt", "dst/sub/b.txt"} {
+				if err := n.Add(ctx, []byte(c), bytes.Repeat([]byte{1}, 32), map[string]string{"edited": "true"}, ls); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+			if err := n.Save(ctx, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			n = mantaray.NewNodeRef(n.Reference())
+			for _, c := range paths {
+				node, err := n.LookupNode(ctx, []byte(c), ls)
+				if err != nil {
+					t.Fatalf("path %s: expected no error, got %v", c, err)
+				}
+				if !bytes.Equal(node.Entry(), entry(c)) {
+					t.Fatalf("path %s: expected value %x, got %x", c, entry(c), node.Entry())
+				}
+				if node.Metadata() != nil {
+					t.Fatalf("path %s: expected no metadata, got %v", c, node.Metadata())
+				}
+			}
+			for c, e := range map[string][]byte{
+				"dst/a.txt":     bytes.Repeat([]byte{1}, 32),
+				"dst/sub/b.txt": bytes.Repeat([]byte{1}, 32),
+				"dst/sub/c.txt": entry("src/sub/c.txt"),
+			} {
+				m, err := n.Lookup(ctx, []byte(c), ls)
+				if err != nil {
+					t.Fatalf("path %s: expected no error, got %v", c, err)
+				}
+				if !bytes.Equal(m, e) {
+					t.Fatalf("path %s: expected value %x, got %x", c, e, m)
+				}
+			}
+		})
+	}
+}
+
+func TestCopySingleNodeIsolated(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	// dir/aufs/ is within the prefix of the only node below it
+	n := newManifest(t, ls, "dir/aufs/app_new", "dir/aux")
+	if err := n.Copy(ctx, n, []byte("dir/aufs/"), []byte("dir/new/"), true, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Remove(ctx, []byte("dir/new/app_new"), ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	m, err := n.Lookup(ctx, []byte("dir/aufs/app_new"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(m, entry("dir/aufs/app_new")) {
+		t.Fatalf("expected value %x, got %x", entry("dir/aufs/app_new"), m)
+	}
+
+	// removing the origin of a move leaves the moved node intact
+	n = newManifest(t, ls, "dir/aufs/app_new", "dir/aufs.old/app", "dir/aux")
+	if err := n.Move(ctx, n, []byte("dir/aufs/"), []byte("dir/aufs.old/"), true, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	m, err = n.Lookup(ctx, []byte("dir/aufs.old/app_new"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(m, entry("dir/aufs/app_new")) {
+		t.Fatalf("expected value %x, got %x", entry("dir/aufs/app_new"), m)
+	}
+}
+
+func TestBranch(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "img/1.png", "img/2.png", "index.html")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	base := n.Reference()
+	// an unsaved change is copied into the branch
+	if err := n.Add(ctx, []byte("robots.txt"), entry("robots.txt"), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	b := n.Branch()
+	if err := n.Add(ctx, []byte("img/1.png"), bytes.Repeat([]byte{1}, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := b.Add(ctx, []byte("img/2.png"), bytes.Repeat([]byte{2}, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := b.Remove(ctx, []byte("index.html"), ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		node     *mantaray.Node
+		expected map[string][]byte
+	}{
+		{
+			name: "original",
+			node: n,
+			expected: map[string][]byte{
+				"img/1.png":  bytes.Repeat([]byte{1}, 32),
+				"img/2.png":  entry("img/2.png"),
+				"index.html": entry("index.html"),
+				"robots.txt": entry("robots.txt"),
+			},
+		},
+		{
+			name: "branch",
+			node: b,
+			expected: map[string][]byte{
+				"img/1.png":  entry("img/1.png"),
+				"img/2.png":  bytes.Repeat([]byte{2}, 32),
+				"index.html": nil,
+				"robots.txt": entry("robots.txt"),
+			},
+		},
+	} {
+		if err := tc.node.Save(ctx, ls); err != nil {
+			t.Fatalf("%s: expected no error, got %v", tc.name, err)
+		}
+		if bytes.Equal(tc.node.Reference(), base) {
+			t.Fatalf("%s: expected reference to change", tc.name)
+		}
+		m := mantaray.NewNodeRef(tc.node.Reference())
+		for path, e := range tc.expected {
+			got, err := m.Lookup(ctx, []byte(path), ls)
+			if e == nil {
+				if !errors.Is(err, mantaray.ErrNotFound) {
+					t.Fatalf("%s: path %s: expected not found error, got %v", tc.name, path, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: path %s: expected no error, got %v", tc.name, path, err)
+			}
+			if !bytes.Equal(got, e) {
+				t.Fatalf("%s: path %s: expected value %x, got %x", tc.name, path, e, got)
+			}
+		}
+	}
+}
+
+func TestMoveRollback(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := mantaray.New()
+	paths := [][]byte{
+		[]byte("dir/a.txt"),
+		[]byte("dir/b/x"),
+		[]byte("dir/b/y"),
+	}
+	for _, c := range paths {
+		e := entry(string(c))
+		if err := n.Add(ctx, c, e, nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ref := n.Reference()
+	dirB, err := mantaray.NewNodeRef(ref).LookupNode(ctx, []byte("dir/b/"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// removing dir/a.txt merges dir/b/ into its parent, which loads it
+	fl := &failingLoader{LoadSaver: ls, ref: dirB.Reference()}
+	n = mantaray.NewNodeRef(ref)
+	err = n.Move(ctx, n, []byte("dir/a.txt"), []byte("new/a.txt"), true, fl)
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(n.Reference(), ref) {
+		t.Fatalf("expected unchanged reference %x, got %x", ref, n.Reference())
+	}
+	_, err = n.Lookup(ctx, []byte("new/a.txt"), ls)
+	if !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	err = n.Move(ctx, n, []byte("dir/a.txt"), []byte("new/a.txt"), true, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, c := range [][]byte{
+		[]byte("new/a.txt"),
+		[]byte("dir/b/x"),
+		[]byte("dir/b/y"),
+	} {
+		if _, err := n.Lookup(ctx, c, ls); err != nil {
+			t.Fatalf("path %s: expected no error, got %v", c, err)
+		}
+	}
+}
+
+func TestMovePruneEmpty(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		toAdd         []string
+		path, newPath string
+	}{
+		{
+			toAdd:   []string{"index.html", "img/test/oho.png", "img/test/old/test.png", "src/logo.gif"},
+			path:    "img/",
+			newPath: "src/",
+		},
+		{
+			toAdd:   []string{"img/apple.png", "img/apple/1x/1x.png", "img/apple/2x/1x.png", "src/defaults/1/apple.png"},
+			path:    "img/apple/",
+			newPath: "src/defaults/",
+		},
+		{
+			toAdd:   []string{"dir/aufs/app_new", "dir/aufs.old/app", "dir/aux", "dir/video.tar", "dir/video/file"},
+			path:    "dir/aufs/",
+			newPath: "dir/aufs.old/",
+		},
+		{
+			toAdd:   []string{"dir1/dx.txt", "dir1/di/a/b/x.txt", "dir1/di/a/caaa.txt", "dir1/di/ab/c.txt", "dir2/abc/de/1.txt"},
+			path:    "dir1/di/a/",
+			newPath: "dir2/abc/de/",
+		},
+		{
+			toAdd:   []string{"robot/baidu/robots.txt", "robot/google/robots.txt", "src/logo.gif"},
+			path:    "robot/baidu/robots.txt",
+			newPath: "src/",
+		},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			ls := newMockLoadSaver()
+			n := newManifest(t, ls, tc.toAdd...)
+			opts := mantaray.MoveOptions{Create: true, PruneEmpty: true}
+			if err := n.MoveWithOptions(ctx, n, []byte(tc.path), []byte(tc.newPath), opts, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			values := 0
+			err := n.WalkNode(ctx, []byte{}, ls, func(path []byte, node *mantaray.Node, err error) error {
+				if err != nil {
+					return err
+				}
+				if node.IsEmptyDirectory() && len(path) > 0 {
+					t.Fatalf("expected no empty directories, got %s", path)
+				}
+				if node.IsValueType() {
+					values++
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if values != len(tc.toAdd) {
+				t.Fatalf("expected %d values, got %d", len(tc.toAdd), values)
+			}
+		})
+	}
+
+	// empty directories with metadata are kept
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "docs/readme.txt", "src/logo.gif")
+	if err := n.Add(ctx, []byte("docs/"), make([]byte, 32), map[string]string{"owner": "docs"}, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	opts := mantaray.MoveOptions{PruneEmpty: true}
+	if err := n.MoveWithOptions(ctx, n, []byte("docs/readme.txt"), []byte("src/"), opts, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	node, err := n.LookupNode(ctx, []byte("docs/"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !node.IsEmptyDirectory() {
+		t.Fatal("expected empty directory with metadata to be kept")
+	}
+
+	// only the source path and the nodes below it are searched
+	n = newManifest(t, ls, "img/a/1.png", "img/b.png", "other/1.txt", "other/2.txt", "src/logo.gif")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	other, err := mantaray.NewNodeRef(n.Reference()).LookupNode(ctx, []byte("other/"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	fl := &failingLoader{LoadSaver: ls, ref: other.Reference()}
+	n = mantaray.NewNodeRef(n.Reference())
+	opts = mantaray.MoveOptions{Create: true, PruneEmpty: true}
+	if err := n.MoveWithOptions(ctx, n, []byte("img/a/"), []byte("src/"), opts, fl); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := n.LookupNode(ctx, []byte("img/a/"), fl); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestMoveInPlace(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "dir/a.txt", "dir/b/x", "dir/b/y", "other/1.txt", "other/2.txt")
+
+	// nodes looked up before the move stay part of the manifest
+	held, err := n.LookupNode(ctx, []byte("other/1.txt"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Move(ctx, n, []byte("dir/a.txt"), []byte("new/a.txt"), true, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	node, err := n.LookupNode(ctx, []byte("other/1.txt"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if node != held {
+		t.Fatal("expected the looked up node to remain in the manifest")
+	}
+
+	// a failed move to another manifest leaves both unchanged
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	target := newManifest(t, ls, "dir/c.txt", "index.html")
+	if err := target.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ref, targetRef := n.Reference(), target.Reference()
+	merged, err := mantaray.NewNodeRef(ref).LookupNode(ctx, []byte("other/2.txt"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// removing other/1.txt merges other/2.txt into its parent, which loads it
+	// after the target is changed
+	fl := &failingLoader{LoadSaver: ls, ref: merged.Reference()}
+	n, target = mantaray.NewNodeRef(ref), mantaray.NewNodeRef(targetRef)
+	if err := n.Move(ctx, target, []byte("other/1.txt"), []byte("dir/"), false, fl); !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	for _, m := range []struct {
+		node *mantaray.Node
+		ref  []byte
+	}{{n, ref}, {target, targetRef}} {
+		if err := m.node.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal(m.node.Reference(), m.ref) {
+			t.Fatalf("expected unchanged reference %x, got %x", m.ref, m.node.Reference())
+		}
+	}
+}
+
+func TestRemoveRootLevelFiles(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, c := range [][]byte{
+		[]byte("index.html"),
+		[]byte("robots.txt"),
+		[]byte("ab"),
+		[]byte("abc"),
+		[]byte("abd"),
+	} {
+		e := entry(string(c))
+		err := n.Add(ctx, c, e, nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	for _, c := range [][]byte{
+		[]byte("index.html"),
+		[]byte("robots.txt"),
+		[]byte("abc"),
+	} {
+		err := n.Remove(ctx, c, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		_, err = n.Lookup(ctx, c, nil)
+		if !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("expected not found error, got %v", err)
+		}
+		err = n.Remove(ctx, c, nil)
+		if !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("expected not found error on second remove, got %v", err)
+		}
+	}
+
+	// value node with a single remaining child must not be merged away
+	for _, c := range [][]byte{
+		[]byte("ab"),
+		[]byte("abd"),
+	} {
+		m, err := n.Lookup(ctx, c, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		e := entry(string(c))
+		if !bytes.Equal(m, e) {
+			t.Fatalf("expected value %x, got %x", e, m)
+		}
+	}
+}
+
+func TestReferenceString(t *testing.T) {
+	if s := mantaray.New().ReferenceString(); s != "" {
+		t.Fatalf("expected empty reference string, got %q", s)
+	}
+
+	for _, size := range []int{32, 64} {
+		ref := make([]byte, size)
+		for i := range ref {
+			ref[i] = byte(i * 7)
+		}
+		s := mantaray.NewNodeRef(ref).ReferenceString()
+		if len(s) != size*2 {
+			t.Fatalf("expected %d hex characters, got %d", size*2, len(s))
+		}
+		parsed, err := mantaray.ParseReference(s)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal(parsed, ref) {
+			t.Fatalf("expected reference %x, got %x", ref, parsed)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"zz",
+		"abcd",
+		string(make([]byte, 64)),
+		"00000000000000000000000000000000000000000000000000000000000000000",
+	} {
+		_, err := mantaray.ParseReference(s)
+		if !errors.Is(err, mantaray.ErrInvalidReference) {
+			t.Fatalf("input %q: expected invalid reference error, got %v", s, err)
+		}
+	}
+}
+
+func TestExists(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, c := range [][]byte{
+		[]byte("index.html"),
+		[]byte("img/1.png"),
+		[]byte("img/2.png"),
+	} {
+		e := entry(string(c))
+		err := n.Add(ctx, c, e, nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	ls := newMockLoadSaver()
+	err := n.Save(ctx, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n2 := mantaray.NewNodeRef(n.Reference())
+	for path, expected := range map[string]bool{
+		"index.html": true,
+		"img/1.png":  true,
+		"img/":       false,
+		"i":          false,
+		"img/3.png":  false,
+		"robots.txt": false,
+	} {
+		exists, err := n2.Exists(ctx, []byte(path), ls)
+		if err != nil {
+			t.Fatalf("path %s: expected no error, got %v", path, err)
+		}
+		if exists != expected {
+			t.Fatalf("path %s: expected exists %t, got %t", path, expected, exists)
+		}
+	}
+
+	// loader errors are propagated
+	n3 := mantaray.NewNodeRef(n.Reference())
+	_, err = n3.Exists(ctx, []byte("index.html"), nil)
+	if !errors.Is(err, mantaray.ErrNoLoader) {
+		t.Fatalf("expected no loader error, got %v", err)
+	}
+}
+
+func TestNotFoundError(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, c := range [][]byte{
+		[]byte("index.html"),
+		[]byte("img/1.png"),
+		[]byte("img/2/test1.png"),
+		[]byte("img/2/test2.png"),
+		[]byte("robots.txt"),
+	} {
+		e := entry(string(c))
+		err := n.Add(ctx, c, e, nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		path    string
+		matched string
+	}{
+		{"favicon.ico", ""},
+		{"img/3.png", "img/"},
+		{"img/1.jpg", "img/"},
+		{"img/2/test3.png", "img/2/test"},
+		{"img/", "img/"},
+	} {
+		for name, fn := range map[string]func([]byte) error{
+			"lookup-node": func(p []byte) error {
+				_, err := n.LookupNode(ctx, p, nil)
+				return err
+			},
+			"lookup": func(p []byte) error {
+				_, err := n.Lookup(ctx, p, nil)
+				return err
+			},
+		} {
+			if name == "lookup-node" && tc.path == "img/" {
+				continue
+			}
+			err := fn([]byte(tc.path))
+			if !errors.Is(err, mantaray.ErrNotFound) {
+				t.Fatalf("%s %s: expected not found error, got %v", name, tc.path, err)
+			}
+			var nf *mantaray.NotFoundError
+			if !errors.As(err, &nf) {
+				t.Fatalf("%s %s: expected NotFoundError, got %T", name, tc.path, err)
+			}
+			if string(nf.Path) != tc.path {
+				t.Fatalf("%s %s: expected path %q, got %q", name, tc.path, tc.path, nf.Path)
+			}
+			if string(nf.Matched) != tc.matched {
+				t.Fatalf("%s %s: expected matched %q, got %q", name, tc.path, tc.matched, nf.Matched)
+			}
+		}
+	}
+
+	err := n.Remove(ctx, []byte("img/2/test3.png"), nil)
+	var nf *mantaray.NotFoundError
+	if !errors.As(err, &nf) {
+		t.Fatalf("expected NotFoundError, got %v", err)
+	}
+	if string(nf.Matched) != "img/2/test" {
+		t.Fatalf("expected matched %q, got %q", "img/2/test", nf.Matched)
+	}
+}
+
+func TestLink(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, c := range [][]byte{
+		[]byte("index.html"),
+		[]byte("img/1.png"),
+	} {
+		e := entry(string(c))
+		err := n.Add(ctx, c, e, map[string]string{"name": string(c)}, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	err := n.Link(ctx, []byte("img/1.png"), []byte("assets/logo.png"), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	source, err := n.LookupNode(ctx, []byte("img/1.png"), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	link, err := n.LookupNode(ctx, []byte("assets/logo.png"), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(source.Entry(), link.Entry()) {
+		t.Fatalf("expected entry %x, got %x", source.Entry(), link.Entry())
+	}
+	if link.Metadata()["name"] != "img/1.png" {
+		t.Fatalf("expected linked metadata, got %v", link.Metadata())
+	}
+
+	// paths are independent
+	err = n.Remove(ctx, []byte("img/1.png"), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	_, err = n.Lookup(ctx, []byte("assets/logo.png"), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, c := range []string{"img/", "missing.png"} {
+		err = n.Link(ctx, []byte(c), []byte("other.png"), nil)
+		if !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("source %s: expected not found error, got %v", c, err)
+		}
+	}
+}
+
+func TestAddIfAbsent(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, c := range [][]byte{
+		[]byte("index.html"),
+		[]byte("img/1.png"),
+	} {
+		e := entry(string(c))
+		err := n.Add(ctx, c, e, nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	err := n.Add(ctx, []byte("css/"), make([]byte, 32), nil, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	newEntry := make([]byte, 32)
+	newEntry[0] = 1
+	for _, tc := range []struct {
+		path  string
+		added bool
+		err   error
+	}{
+		{path: "robots.txt", added: true},
+		{path: "index.html", added: false},
+		{path: "img/", err: mantaray.ErrIsDirectory},
+		{path: "css/", err: mantaray.ErrIsDirectory},
+	} {
+		added, err := n.AddIfAbsent(ctx, []byte(tc.path), newEntry, nil, nil)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("path %s: expected error %v, got %v", tc.path, tc.err, err)
+		}
+		if added != tc.added {
+			t.Fatalf("path %s: expected added %t, got %t", tc.path, tc.added, added)
+		}
+	}
+
+	m, err := n.Lookup(ctx, []byte("index.html"), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if bytes.Equal(m, newEntry) {
+		t.Fatal("expected existing entry to be unchanged")
+	}
+	m, err = n.Lookup(ctx, []byte("robots.txt"), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(m, newEntry) {
+		t.Fatalf("expected value %x, got %x", newEntry, m)
+	}
+}
+
+func TestAddCounting(t *testing.T) {
+	ctx := context.Background()
+	entry := make([]byte, 32)
+	entry[0] = 1
+
+	for _, tc := range []struct {
+		name     string
+		existing [][]byte
+		path     []byte
+		expected int
+	}{
+		{
+			name:     "empty",
+			path:     []byte("img/1.png"),
+			expected: 0,
+		},
+		{
+			name:     "empty-long-path",
+			path:     bytes.Repeat([]byte("a"), 70),
+			expected: 2,
+		},
+		{
+			name: "split",
+			existing: [][]byte{
+				[]byte("img/1.png"),
+			},
+			path:     []byte("img/2.png"),
+			expected: 1,
+		},
+		{
+			name: "no-split",
+			existing: [][]byte{
+				[]byte("img/1.png"),
+				[]byte("img/2.png"),
+			},
+			path:     []byte("img/3.png"),
+			expected: 0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := mantaray.New()
+			for _, c := range tc.existing {
+				err := n.Add(ctx, c, entry, nil, nil)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+			created, err := n.AddCounting(ctx, tc.path, entry, nil, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if created != tc.expected {
+				t.Fatalf("expected %d nodes created, got %d", tc.expected, created)
+			}
+			_, err = n.Lookup(ctx, tc.path, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLookupOrAncestor(t *testing.T) {
+	ctx := context.Background()
+	entry := func(b byte) []byte {
+		e := make([]byte, 32)
+		e[31] = b
+		return e
+	}
+	n := mantaray.New()
+	for path, e := range map[string][]byte{
+		"docs/":              entry(1),
+		"docs/intro.html":    entry(2),
+		"docs/api/page.html": entry(3),
+		"blog/post.html":     entry(4),
+	} {
+		err := n.Add(ctx, []byte(path), e, nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		path    string
+		entry   []byte
+		matched string
+	}{
+		{"docs/intro.html", entry(2), "docs/intro.html"},
+		{"docs/missing.html", entry(1), "docs/"},
+		{"docs/api/missing.html", entry(1), "docs/"},
+		{"docs/api/", entry(1), "docs/"},
+	} {
+		e, matched, err := n.LookupOrAncestor(ctx, []byte(tc.path), nil)
+		if err != nil {
+			t.Fatalf("path %s: expected no error, got %v", tc.path, err)
+		}
+		if !bytes.Equal(e, tc.entry) {
+			t.Fatalf("path %s: expected entry %x, got %x", tc.path, tc.entry, e)
+		}
+		if string(matched) != tc.matched {
+			t.Fatalf("path %s: expected matched path %s, got %s", tc.path, tc.matched, matched)
+		}
+	}
+
+	// no ancestor default
+	_, _, err := n.LookupOrAncestor(ctx, []byte("blog/missing.html"), nil)
+	if !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	// root default
+	err = n.Add(ctx, []byte("/"), entry(5), nil, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	e, matched, err := n.LookupOrAncestor(ctx, []byte("blog/missing.html"), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(e, entry(5)) || string(matched) != "/" {
+		t.Fatalf("expected root entry, got %x on %s", e, matched)
+	}
+}
+
+func TestAddStrictPrefixOfCollapsedFork(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		toAdd [][]byte
+	}{
+		{
+			name: "single-fork",
+			toAdd: [][]byte{
+				[]byte("abcdef"),
+				[]byte("ab"),
+			},
+		},
+		{
+			name: "first-byte",
+			toAdd: [][]byte{
+				[]byte("abcdef"),
+				[]byte("a"),
+			},
+		},
+		{
+			name: "nested",
+			toAdd: [][]byte{
+				[]byte("abcdef"),
+				[]byte("abcd"),
+				[]byte("ab"),
+			},
+		},
+		{
+			name: "directory",
+			toAdd: [][]byte{
+				[]byte("img/2/test1.png"),
+				[]byte("img"),
+				[]byte("img/2"),
+			},
+		},
+		{
+			name: "beyond-max-prefix",
+			toAdd: [][]byte{
+				[]byte("a/very/long/path/over/the/max.x"),
+				[]byte("a/very/long/path/over/the/max"),
+				[]byte("a/very/long/path"),
+			},
+		},
+	} {
+		ctx := context.Background()
+		t.Run(tc.name, func(t *testing.T) {
+			ls := newMockLoadSaver()
+			n := mantaray.New()
+			for i, c := range tc.toAdd {
+				e := entry(string(c))
+				err := n.Add(ctx, c, e, nil, ls)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if i == 0 {
+					// split a collapsed fork of a lazily loaded node
+					if err := n.Save(ctx, ls); err != nil {
+						t.Fatalf("expected no error, got %v", err)
+					}
+					n = mantaray.NewNodeRef(n.Reference())
+				}
+			}
+			if err := n.Save(ctx, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			for _, root := range []*mantaray.Node{n, mantaray.NewNodeRef(n.Reference())} {
+				for _, c := range tc.toAdd {
+					m, err := root.Lookup(ctx, c, ls)
+					if err != nil {
+						t.Fatalf("path %s: expected no error, got %v", c, err)
+					}
+					e := entry(string(c))
+					if !bytes.Equal(m, e) {
+						t.Fatalf("path %s: expected value %x, got %x", c, e, m)
+					}
+				}
+			}
+		})
+	}
+}
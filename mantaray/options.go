@@ -0,0 +1,138 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// Options configures optional behaviour of a manifest. Options are set on
+// the root node and apply to operations started from it.
+type Options struct {
+	// TrailingSlashInsensitive makes Lookup and LookupNode retry a missing
+	// path with a trailing separator so that `img` resolves to the `img/`
+	// directory.
+	TrailingSlashInsensitive bool
+	// MaxLoads caps the number of Loader calls a single Lookup, LookupNode,
+	// HasPrefix or walk may make. Zero means unlimited.
+	MaxLoads int
+	// ResolveDirsAsValues makes Lookup return the zero entry of explicitly
+	// created empty directories instead of ErrNotFound.
+	ResolveDirsAsValues bool
+	// CopyEntriesOnRead makes Lookup and LookupOrAncestor return copies of
+	// entries, so callers may modify them without corrupting the manifest.
+	CopyEntriesOnRead bool
+	// ValidatePaths makes Add reject paths that fail ValidatePath or contain
+	// any of DisallowedPathBytes.
+	ValidatePaths       bool
+	DisallowedPathBytes []byte
+	// MaxPathLen makes Add reject paths longer than this many bytes with a
+	// PathTooLongError. Zero means unlimited.
+	MaxPathLen int
+	// Codec serialises nodes on Save and deserialises them on load. Nil
+	// means the native binary format. Nodes inherit the codec of the node
+	// they were loaded from, so a manifest must be read with the codec it
+	// was written with.
+	Codec Codec
+	// Logger receives warnings about deprecated usage, such as empty
+	// directories created or encountered by Add through the zero entry
+	// encoding instead of MakeDir. Nil disables the checks entirely.
+	Logger func(string)
+}
+
+// SetOptions sets the options used by operations started from the node.
+func (n *Node) SetOptions(opts Options) {
+	n.opts = &opts
+}
+
+func (n *Node) options() Options {
+	if n.opts == nil {
+		return Options{}
+	}
+	return *n.opts
+}
+
+// checkPath checks path against MaxPathLen and, if ValidatePaths is set,
+// validates it.
+func (n *Node) checkPath(path []byte) error {
+	opts := n.options()
+	if opts.MaxPathLen > 0 && len(path) > opts.MaxPathLen {
+		return &PathTooLongError{Len: len(path), Limit: opts.MaxPathLen}
+	}
+	if !opts.ValidatePaths {
+		return nil
+	}
+	return validatePath(path, opts.DisallowedPathBytes)
+}
+
+// warnZeroEntryDirs reports to Options.Logger if adding nn on path creates
+// an empty directory from a zero entry or descends into an existing one.
+func (n *Node) warnZeroEntryDirs(ctx context.Context, path []byte, nn *Node, l Loader) {
+	logger := n.options().Logger
+	if logger == nil || len(path) == 0 {
+		return
+	}
+	if nn.IsEmptyDirectory() {
+		logger(fmt.Sprintf("mantaray: path '%s': empty directory created from a zero entry, use MakeDir", path))
+	}
+	for i, c := range path[:len(path)-1] {
+		if c != PathSeparator {
+			continue
+		}
+		dir := path[:i+1]
+		node, err := n.lookupNode(ctx, dir, l)
+		if err != nil {
+			continue
+		}
+		if node.IsEmptyDirectory() {
+			logger(fmt.Sprintf("mantaray: path '%s': adding under empty directory '%s' encoded as a zero entry", path, dir))
+		}
+	}
+}
+
+// budgetLoader fails with ErrLoadBudgetExceeded once its budget is spent.
+type budgetLoader struct {
+	Loader
+	remaining int64
+}
+
+func (b *budgetLoader) Load(ctx context.Context, reference []byte, index int64) ([]byte, error) {
+	// the budget may be spent by concurrent loads
+	if atomic.AddInt64(&b.remaining, -1) < 0 {
+		return nil, ErrLoadBudgetExceeded
+	}
+	return b.Loader.Load(ctx, reference, index)
+}
+
+// budgeted wraps l with a per-call load budget if MaxLoads is set.
+func (n *Node) budgeted(l Loader) Loader {
+	maxLoads := n.options().MaxLoads
+	if maxLoads <= 0 || l == nil {
+		return l
+	}
+	if _, ok := l.(*budgetLoader); ok {
+		return l
+	}
+	return &budgetLoader{Loader: l, remaining: int64(maxLoads)}
+}
+
+// isDirectory reports whether node, found on a path with a trailing
+// separator, is a directory rather than a file named like one.
+func isDirectory(node *Node) bool {
+	return node.IsEmptyDirectory() || len(node.forks) > 0
+}
+
+// withTrailingSlash returns a copy of path with a separator appended, or nil
+// if path is empty or already ends with one.
+func withTrailingSlash(path []byte) []byte {
+	if len(path) == 0 || path[len(path)-1] == PathSeparator {
+		return nil
+	}
+	p := make([]byte, len(path), len(path)+1)
+	copy(p, path)
+	return append(p, PathSeparator)
+}
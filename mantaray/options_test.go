@@ -0,0 +1,370 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestTrailingSlashInsensitive(t *testing.T) {
+	ctx := context.Background()
+	toAdd := [][]byte{
+		[]byte("css/"),
+		[]byte("css/app.css"),
+		[]byte("fonts/"),
+		[]byte("img/1.png"),
+		[]byte("img/2.png"),
+		[]byte("index.html"),
+	}
+	n := mantaray.New()
+	for _, c := range toAdd {
+		e := entry(string(c))
+		err := n.Add(ctx, c, e, nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	// strict by default
+	_, err := n.LookupNode(ctx, []byte("img"), nil)
+	if !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	n.SetOptions(mantaray.Options{TrailingSlashInsensitive: true})
+
+	for _, path := range []string{"img", "img/"} {
+		node, err := n.LookupNode(ctx, []byte(path), nil)
+		if err != nil {
+			t.Fatalf("path %q: expected no error, got %v", path, err)
+		}
+		if !node.IsEdgeType() {
+			t.Fatalf("path %q: expected directory node", path)
+		}
+	}
+
+	for _, path := range []string{"css", "css/"} {
+		m, err := n.Lookup(ctx, []byte(path), nil)
+		if err != nil {
+			t.Fatalf("path %q: expected no error, got %v", path, err)
+		}
+		e := entry("css/")
+		if !bytes.Equal(m, e) {
+			t.Fatalf("path %q: expected value %x, got %x", path, e, m)
+		}
+	}
+
+	// files are not matched as directories
+	for _, path := range []string{"img/1", "index", "fonts"} {
+		_, err := n.Lookup(ctx, []byte(path), nil)
+		if !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("path %q: expected not found error, got %v", path, err)
+		}
+		_, err = n.LookupNode(ctx, []byte(path), nil)
+		if !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("path %q: expected not found error, got %v", path, err)
+		}
+		_, errs, err := n.LookupMany(ctx, [][]byte{[]byte(path)}, nil)
+		if err != nil {
+			t.Fatalf("path %q: expected no error, got %v", path, err)
+		}
+		if !errors.Is(errs[0], mantaray.ErrNotFound) {
+			t.Fatalf("path %q: expected not found error, got %v", path, errs[0])
+		}
+	}
+}
+
+type countingLoader struct {
+	mantaray.LoadSaver
+	loads int64
+}
+
+func (c *countingLoader) Load(ctx context.Context, ref []byte, index int64) ([]byte, error) {
+	atomic.AddInt64(&c.loads, 1)
+	return c.LoadSaver.Load(ctx, ref, index)
+}
+
+func TestMaxLoads(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, c := range [][]byte{
+		[]byte("index.html"),
+		[]byte("a/b/c/d/e/f.txt"),
+		[]byte("a/b/c/d/e/g.txt"),
+		[]byte("a/b/c/d/x.txt"),
+		[]byte("a/b/y.txt"),
+	} {
+		e := entry(string(c))
+		err := n.Add(ctx, c, e, nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	ls := newMockLoadSaver()
+	err := n.Save(ctx, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		fn   func(n *mantaray.Node, l mantaray.Loader) error
+	}{
+		{
+			name: "lookup",
+			fn: func(n *mantaray.Node, l mantaray.Loader) error {
+				_, err := n.Lookup(ctx, []byte("a/b/c/d/e/f.txt"), l)
+				return err
+			},
+		},
+		{
+			name: "has-prefix",
+			fn: func(n *mantaray.Node, l mantaray.Loader) error {
+				_, err := n.HasPrefix(ctx, []byte("a/b/c/d/e/"), l)
+				return err
+			},
+		},
+		{
+			name: "walk",
+			fn: func(n *mantaray.Node, l mantaray.Loader) error {
+				return n.Walk(ctx, []byte{}, l, func(_ []byte, _ bool, err error) error {
+					return err
+				})
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// count loads without a budget
+			cl := &countingLoader{LoadSaver: ls}
+			err := tc.fn(mantaray.NewNodeRef(n.Reference()), cl)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			needed := int(cl.loads)
+			if needed < 2 {
+				t.Fatalf("expected at least 2 loads, got %d", needed)
+			}
+
+			// budget is per call
+			limited := mantaray.NewNodeRef(n.Reference())
+			limited.SetOptions(mantaray.Options{MaxLoads: needed})
+			err = tc.fn(limited, ls)
+			if err != nil {
+				t.Fatalf("expected no error within budget, got %v", err)
+			}
+
+			cl = &countingLoader{LoadSaver: ls}
+			limited = mantaray.NewNodeRef(n.Reference())
+			limited.SetOptions(mantaray.Options{MaxLoads: needed - 1})
+			err = tc.fn(limited, cl)
+			if !errors.Is(err, mantaray.ErrLoadBudgetExceeded) {
+				t.Fatalf("expected load budget exceeded error, got %v", err)
+			}
+			if int(cl.loads) != needed-1 {
+				t.Fatalf("expected %d loads, got %d", needed-1, cl.loads)
+			}
+		})
+	}
+}
+
+func TestResolveDirsAsValues(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, c := range [][]byte{
+		[]byte("img/"),
+		[]byte("css/"),
+	} {
+		err := n.Add(ctx, c, make([]byte, 32), nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	err := n.Add(ctx, []byte("js/app.js"), append(make([]byte, 31), 1), nil, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, resolve := range []bool{false, true} {
+		n.SetOptions(mantaray.Options{ResolveDirsAsValues: resolve})
+		for _, c := range []string{"img/", "css/"} {
+			m, err := n.Lookup(ctx, []byte(c), nil)
+			if !resolve {
+				if !errors.Is(err, mantaray.ErrNotFound) {
+					t.Fatalf("path %s: expected not found error, got %v", c, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("path %s: expected no error, got %v", c, err)
+			}
+			if !bytes.Equal(m, make([]byte, 32)) {
+				t.Fatalf("path %s: expected zero entry, got %x", c, m)
+			}
+		}
+		// implicit directories are not resolved
+		_, err := n.Lookup(ctx, []byte("js/"), nil)
+		if !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("expected not found error, got %v", err)
+		}
+	}
+}
+
+func TestCopyEntriesOnRead(t *testing.T) {
+	ctx := context.Background()
+	path := []byte("index.html")
+	e := entry(string(path))
+	n := mantaray.New()
+	err := n.Add(ctx, path, append([]byte{}, e...), map[string]string{"Content-Type": "text/html"}, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	n.SetOptions(mantaray.Options{CopyEntriesOnRead: true})
+
+	m, err := n.Lookup(ctx, path, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	m[0] = 0xff
+	m, _, err = n.LookupOrAncestor(ctx, path, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	m[1] = 0xff
+
+	node, err := n.LookupNode(ctx, path, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	node.Metadata()["Content-Type"] = "text/plain"
+
+	m, err = n.Lookup(ctx, path, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(m, e) {
+		t.Fatalf("expected value %x, got %x", e, m)
+	}
+	if ct := node.Metadata()["Content-Type"]; ct != "text/html" {
+		t.Fatalf("expected metadata text/html, got %s", ct)
+	}
+}
+
+func TestValidatePaths(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	e := append(make([]byte, 31), 1)
+
+	// not validated by default
+	if err := n.Add(ctx, []byte("a\x00b"), e, nil, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	n.SetOptions(mantaray.Options{ValidatePaths: true, DisallowedPathBytes: []byte("\\")})
+	for _, path := range []string{"c\x00d", "dir\\file", "tab\t", "/abs"} {
+		err := n.Add(ctx, []byte(path), e, nil, nil)
+		if !errors.Is(err, mantaray.ErrInvalidPath) {
+			t.Fatalf("path %q: expected invalid path error, got %v", path, err)
+		}
+		_, err = n.AddCounting(ctx, []byte(path), e, nil, nil)
+		if !errors.Is(err, mantaray.ErrInvalidPath) {
+			t.Fatalf("path %q: expected invalid path error, got %v", path, err)
+		}
+	}
+	if err := n.Add(ctx, []byte("dir/file"), e, nil, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestMaxPathLen(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "index.html")
+	n.SetOptions(mantaray.Options{MaxPathLen: 10})
+	e := append(make([]byte, 31), 1)
+
+	if err := n.Add(ctx, []byte("0123456789"), e, nil, ls); err != nil {
+		t.Fatalf("expected no error at the limit, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ref := n.Reference()
+
+	err := n.Add(ctx, []byte("0123456789a"), e, nil, ls)
+	if !errors.Is(err, mantaray.ErrPathTooLong) {
+		t.Fatalf("expected path too long error, got %v", err)
+	}
+	var tooLong *mantaray.PathTooLongError
+	if !errors.As(err, &tooLong) {
+		t.Fatalf("expected path too long error, got %T", err)
+	}
+	if tooLong.Len != 11 || tooLong.Limit != 10 {
+		t.Fatalf("expected length 11 and limit 10, got %d and %d", tooLong.Len, tooLong.Limit)
+	}
+	if _, err := n.AddCounting(ctx, []byte("dir/0123456"), e, nil, ls); !errors.Is(err, mantaray.ErrPathTooLong) {
+		t.Fatalf("expected path too long error, got %v", err)
+	}
+	if err := n.MakeDir(ctx, []byte("0123456789"), nil, ls); !errors.Is(err, mantaray.ErrPathTooLong) {
+		t.Fatalf("expected path too long error, got %v", err)
+	}
+
+	// the tree is untouched
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(n.Reference(), ref) {
+		t.Fatal("expected manifest to be unchanged")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	var warnings []string
+	n := mantaray.New()
+	n.SetOptions(mantaray.Options{Logger: func(msg string) {
+		warnings = append(warnings, msg)
+	}})
+
+	if err := n.Add(ctx, []byte("index.html"), bytes.Repeat([]byte{1}, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.MakeDir(ctx, []byte("explicit"), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got %q", warnings)
+	}
+	node, err := n.LookupNode(ctx, []byte("explicit/"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !node.IsEmptyDirectory() {
+		t.Fatal("expected empty directory")
+	}
+
+	// created from a zero entry
+	if err := n.Add(ctx, []byte("docs/"), make([]byte, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "'docs/'") {
+		t.Fatalf("expected a warning naming docs/, got %q", warnings)
+	}
+
+	// encountered while adding below it
+	if _, err := n.AddCounting(ctx, []byte("docs/readme.md"), bytes.Repeat([]byte{2}, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[1], "'docs/readme.md'") {
+		t.Fatalf("expected a warning naming docs/readme.md, got %q", warnings)
+	}
+}
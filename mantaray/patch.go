@@ -0,0 +1,127 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Patch is the set of changes turning one manifest into another. It can be
+// serialised as JSON and applied to a different copy of the first manifest.
+type Patch struct {
+	Ops []PatchOp `json:"ops"`
+}
+
+// PatchOp changes the value on a single path. Old is the entry expected on
+// the path before the change and is nil for added paths; Entry and Metadata
+// are the value after the change and are nil for removed paths.
+type PatchOp struct {
+	Path     []byte            `json:"path"`
+	Type     DiffType          `json:"type"`
+	Old      []byte            `json:"old,omitempty"`
+	Entry    []byte            `json:"entry,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// MakePatch returns the patch turning from into to, with one operation per
+// value path that is added, removed, or changed in entry or metadata, in
+// sorted order. Subtrees with equal references in both manifests are not
+// descended.
+func MakePatch(ctx context.Context, from, to *Node, l Loader) (*Patch, error) {
+	p := &Patch{}
+	err := diffNodes(ctx, []byte{}, from, to, l, true, func(e DiffEntry, _, node *Node) error {
+		op := PatchOp{Path: e.Path, Type: e.Type, Old: e.Old, Entry: e.New}
+		if node != nil {
+			op.Metadata = node.Metadata()
+		}
+		p.Ops = append(p.Ops, op)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Apply applies the patch to base, which must hold the values the patch was
+// made from: removed and modified paths must have the Old entry and added
+// paths must not exist, otherwise ErrConflict is returned. Applying a patch
+// made from a manifest equal to base yields a manifest with the same value
+// paths, entries and metadata as the one the patch was made to. If any
+// operation fails, base is not modified.
+func (p *Patch) Apply(ctx context.Context, base *Node, ls LoadSaver) error {
+	work := base.deepCopy()
+	for _, op := range p.Ops {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if err := work.applyPatchOp(ctx, op, ls); err != nil {
+			return fmt.Errorf("patch %s '%s': %w", op.Type, op.Path, err)
+		}
+	}
+	*base = *work
+	return nil
+}
+
+func (n *Node) applyPatchOp(ctx context.Context, op PatchOp, ls LoadSaver) error {
+	node, err := n.LookupNode(ctx, op.Path, ls)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	exists := err == nil && node.IsValueType()
+	switch op.Type {
+	case DiffAdded:
+		if exists {
+			return ErrConflict
+		}
+	case DiffRemoved, DiffModified:
+		if !exists || !bytes.Equal(node.entry, op.Old) {
+			return ErrConflict
+		}
+		if op.Type == DiffRemoved {
+			return n.removeValue(ctx, op.Path, ls)
+		}
+	default:
+		return ErrInvalidInput
+	}
+	// the metadata is set separately to replace rather than merge it
+	if err := n.Add(ctx, op.Path, op.Entry, nil, ls); err != nil {
+		return err
+	}
+	spine, err := n.lookupSpine(ctx, op.Path, ls)
+	if err != nil {
+		return err
+	}
+	metadata := make(map[string]string, len(op.Metadata))
+	for k, v := range op.Metadata {
+		metadata[k] = v
+	}
+	if err := spine[len(spine)-1].setMetadata(metadata); err != nil {
+		return err
+	}
+	rebornSpine(spine)
+	return nil
+}
+
+// removeValue removes the value on path, keeping the paths below it, as
+// RemoveFile does for files.
+func (n *Node) removeValue(ctx context.Context, path []byte, ls LoadSaver) error {
+	spine, err := n.lookupSpine(ctx, path, ls)
+	if err != nil {
+		return err
+	}
+	node := spine[len(spine)-1]
+	if len(node.forks) == 0 {
+		return n.Remove(ctx, path, ls)
+	}
+	node.makeNotValue()
+	rebornSpine(spine)
+	return nil
+}
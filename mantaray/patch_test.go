@@ -0,0 +1,144 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestPatch(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	from := newManifest(t, ls, "docs/readme.txt", "img/1.png", "img/2.png", "index.html", "robots.txt")
+	if err := from.Add(ctx, []byte("docs/readme.txt"), entry("docs/readme.txt"), map[string]string{"lang": "en"}, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := from.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	to := newManifest(t, ls, "docs/readme.txt", "index.html", "robots.txt")
+	for _, e := range []mantaray.NodeEntry{
+		{Path: []byte("docs/readme.txt"), Entry: entry("docs/readme.txt"), Metadata: map[string]string{"lang": "de"}},
+		{Path: []byte("img/1.png"), Entry: bytes.Repeat([]byte{1}, 32)},
+		{Path: []byte("img/3.png"), Entry: entry("img/3.png"), Metadata: map[string]string{"content-type": "image/png"}},
+	} {
+		if err := to.Add(ctx, e.Path, e.Entry, e.Metadata, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := to.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	patch, err := mantaray.MakePatch(ctx, mantaray.NewNodeRef(from.Reference()), mantaray.NewNodeRef(to.Reference()), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := []mantaray.PatchOp{
+		{Path: []byte("docs/readme.txt"), Type: mantaray.DiffModified, Old: entry("docs/readme.txt"), Entry: entry("docs/readme.txt"), Metadata: map[string]string{"lang": "de"}},
+		{Path: []byte("img/1.png"), Type: mantaray.DiffModified, Old: entry("img/1.png"), Entry: bytes.Repeat([]byte{1}, 32)},
+		{Path: []byte("img/2.png"), Type: mantaray.DiffRemoved, Old: entry("img/2.png")},
+		{Path: []byte("img/3.png"), Type: mantaray.DiffAdded, Entry: entry("img/3.png"), Metadata: map[string]string{"content-type": "image/png"}},
+	}
+	if !reflect.DeepEqual(patch.Ops, expected) {
+		t.Fatalf("expected ops %+v, got %+v", expected, patch.Ops)
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	decoded := &mantaray.Patch{}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(decoded, patch) {
+		t.Fatalf("expected decoded patch %+v, got %+v", patch, decoded)
+	}
+
+	base := mantaray.NewNodeRef(from.Reference())
+	if err := decoded.Apply(ctx, base, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := base.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	rest, err := mantaray.MakePatch(ctx, mantaray.NewNodeRef(base.Reference()), mantaray.NewNodeRef(to.Reference()), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(rest.Ops) != 0 {
+		t.Fatalf("expected patched manifest to equal target, got ops %+v", rest.Ops)
+	}
+
+	// the base no longer holds the values the patch was made from
+	ref := base.Reference()
+	if err := decoded.Apply(ctx, base, ls); !errors.Is(err, mantaray.ErrConflict) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+	if !bytes.Equal(base.Reference(), ref) {
+		t.Fatal("expected failed patch to leave the base unchanged")
+	}
+
+	if err := json.Unmarshal([]byte(`{"ops":[{"path":"YQ==","type":"renamed"}]}`), &mantaray.Patch{}); !errors.Is(err, mantaray.ErrInvalidInput) {
+		t.Fatalf("expected invalid input error, got %v", err)
+	}
+}
+
+func TestPatchValueWithDescendants(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	// removing a value keeps the paths below it
+	from := newManifest(t, ls, "img", "img/img/b.png")
+	to := newManifest(t, ls, "img/img/b.png")
+	for _, n := range []*mantaray.Node{from, to} {
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	patch, err := mantaray.MakePatch(ctx, mantaray.NewNodeRef(from.Reference()), mantaray.NewNodeRef(to.Reference()), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	base := mantaray.NewNodeRef(from.Reference())
+	if err := patch.Apply(ctx, base, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := base.Lookup(ctx, []byte("img/img/b.png"), ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := base.Lookup(ctx, []byte("img"), ls); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	// removing a value and then a path below it
+	from = newManifest(t, ls, "ab/ab", "ab/ab/img")
+	if err := from.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	patch = &mantaray.Patch{Ops: []mantaray.PatchOp{
+		{Path: []byte("ab/ab"), Type: mantaray.DiffRemoved, Old: entry("ab/ab")},
+		{Path: []byte("ab/ab/img"), Type: mantaray.DiffRemoved, Old: entry("ab/ab/img")},
+	}}
+	base = mantaray.NewNodeRef(from.Reference())
+	if err := patch.Apply(ctx, base, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, c := range []string{"ab/ab", "ab/ab/img"} {
+		if _, err := base.Lookup(ctx, []byte(c), ls); !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("path '%s': expected not found error, got %v", c, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// RelPath returns the relative reference from the file on path from to the
+// path to, e.g. `../img/logo.png` from `css/app.css` to `img/logo.png`.
+func RelPath(from, to []byte) []byte {
+	var fromDir [][]byte
+	if i := bytes.LastIndexByte(from, PathSeparator); i >= 0 {
+		fromDir = bytes.Split(from[:i], []byte{PathSeparator})
+	}
+	toSegments := bytes.Split(to, []byte{PathSeparator})
+	toDir := toSegments[:len(toSegments)-1]
+
+	common := 0
+	for common < len(fromDir) && common < len(toDir) && bytes.Equal(fromDir[common], toDir[common]) {
+		common++
+	}
+
+	var rel []byte
+	for i := common; i < len(fromDir); i++ {
+		rel = append(rel, '.', '.', PathSeparator)
+	}
+	rel = append(rel, bytes.Join(toSegments[common:], []byte{PathSeparator})...)
+	if len(rel) == 0 {
+		return []byte{'.', PathSeparator}
+	}
+	return rel
+}
+
+// ValidatePath checks that p is not empty, does not start with a separator
+// unless it is the root path "/", and contains no NUL or other control bytes,
+// returning an ErrInvalidPath error naming the index of the first offending
+// byte. Manifest paths are relative, so "/a" would not resolve as "a".
+func ValidatePath(p []byte) error {
+	return validatePath(p, nil)
+}
+
+func validatePath(p, disallowed []byte) error {
+	if len(p) == 0 {
+		return ErrEmptyPath
+	}
+	if len(p) > 1 && p[0] == PathSeparator {
+		return fmt.Errorf("path %q: leading separator at index 0: %w", p, ErrInvalidPath)
+	}
+	for i, b := range p {
+		if b < 0x20 || b == 0x7f || bytes.IndexByte(disallowed, b) >= 0 {
+			return fmt.Errorf("path %q: byte 0x%02x at index %d: %w", p, b, i, ErrInvalidPath)
+		}
+	}
+	return nil
+}
+
+// ComparePaths compares two paths in the canonical order of the manifest,
+// which is the order value paths are visited by a forward Walk. Paths are
+// compared byte-wise, so the separator sorts by its byte value: after '-'
+// and '.' but before digits and letters. A path sorts before any longer
+// path it is a prefix of.
+func ComparePaths(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+// ancestors returns the directory paths containing path, nearest first,
+// ending with the root directory path "/".
+func ancestors(path []byte) [][]byte {
+	var dirs [][]byte
+	end := len(path)
+	if end > 0 && path[end-1] == PathSeparator {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if path[i] == PathSeparator {
+			dirs = append(dirs, path[:i+1])
+		}
+	}
+	if len(dirs) == 0 || len(dirs[len(dirs)-1]) != 1 {
+		dirs = append(dirs, []byte{PathSeparator})
+	}
+	return dirs
+}
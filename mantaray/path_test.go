@@ -0,0 +1,118 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestRelPath(t *testing.T) {
+	for _, tc := range []struct {
+		from, to, expected string
+	}{
+		{"index.html", "robots.txt", "robots.txt"},
+		{"index.html", "img/logo.png", "img/logo.png"},
+		{"css/app.css", "css/print.css", "print.css"},
+		{"css/app.css", "img/logo.png", "../img/logo.png"},
+		{"css/app.css", "index.html", "../index.html"},
+		{"a/b/c/d.html", "a/x.png", "../../x.png"},
+		{"a/b.html", "a/b/c/d.png", "b/c/d.png"},
+		{"a/b/c.html", "a/b/", "./"},
+		{"a/b/c.html", "a/", "../"},
+	} {
+		got := mantaray.RelPath([]byte(tc.from), []byte(tc.to))
+		if string(got) != tc.expected {
+			t.Errorf("from %q to %q: expected %q, got %q", tc.from, tc.to, tc.expected, got)
+		}
+	}
+}
+
+func TestComparePaths(t *testing.T) {
+	ctx := context.Background()
+	paths := [][]byte{
+		[]byte("a.txt"),
+		[]byte("a/b"),
+		[]byte("a-b/c"),
+		[]byte("a0"),
+		[]byte("aa"),
+		[]byte("img/1.png"),
+		[]byte("img/1.png.bak"),
+		[]byte("img/10.png"),
+		[]byte("img/2/test1.png"),
+		[]byte("img/2/test2.png"),
+		[]byte("img.png"),
+		[]byte("index.html"),
+		[]byte("robots.txt"),
+		[]byte("A"),
+		[]byte("_"),
+	}
+	r := rand.New(rand.NewSource(1))
+	r.Shuffle(len(paths), func(i, j int) { paths[i], paths[j] = paths[j], paths[i] })
+
+	n := mantaray.New()
+	for _, c := range paths {
+		e := entry(string(c))
+		err := n.Add(ctx, c, e, nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	var walked [][]byte
+	err := n.Walk(ctx, []byte{}, nil, func(path []byte, isDir bool, err error) error {
+		if err != nil {
+			return err
+		}
+		if !isDir {
+			walked = append(walked, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("no error expected, found: %s", err)
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		return mantaray.ComparePaths(paths[i], paths[j]) < 0
+	})
+	if !reflect.DeepEqual(paths, walked) {
+		t.Fatalf("expected walk order %s, got %s", paths, walked)
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		path     []byte
+		expected error
+		index    string
+	}{
+		{name: "valid", path: []byte("img/1.png")},
+		{name: "root", path: []byte("/")},
+		{name: "leading-separator", path: []byte("/img/1.png"), expected: mantaray.ErrInvalidPath, index: "index 0"},
+		{name: "empty", path: []byte{}, expected: mantaray.ErrEmptyPath},
+		{name: "nul", path: []byte("img/\x00.png"), expected: mantaray.ErrInvalidPath, index: "index 4"},
+		{name: "newline", path: []byte("a\nb"), expected: mantaray.ErrInvalidPath, index: "index 1"},
+		{name: "delete", path: []byte("\x7f"), expected: mantaray.ErrInvalidPath, index: "index 0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := mantaray.ValidatePath(tc.path)
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("expected error %v, got %v", tc.expected, err)
+			}
+			if tc.index != "" && !strings.Contains(err.Error(), tc.index) {
+				t.Fatalf("expected error naming %s, got %v", tc.index, err)
+			}
+		})
+	}
+}
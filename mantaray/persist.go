@@ -5,9 +5,16 @@
 package mantaray
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
@@ -15,6 +22,8 @@ var (
 	ErrNoSaver = errors.New("Node is not persisted but no saver")
 	// ErrNoLoader saver interface not given
 	ErrNoLoader = errors.New("Node is reference but no loader")
+	// ErrLoadBudgetExceeded an operation exceeded Options.MaxLoads
+	ErrLoadBudgetExceeded = errors.New("load budget exceeded")
 )
 
 // Loader defines a generic interface to retrieve nodes
@@ -48,7 +57,156 @@ func (n *Node) load(ctx context.Context, l Loader) error {
 	if err != nil {
 		return err
 	}
-	return n.UnmarshalBinary(b)
+	return n.decode(b)
+}
+
+// decode unmarshals the loaded data b of n with its codec.
+func (n *Node) decode(b []byte) error {
+	c := n.persistCodec()
+	if c == nil {
+		return n.UnmarshalBinary(b)
+	}
+	if err := c.UnmarshalNode(b, n); err != nil {
+		return err
+	}
+	n.codec = c
+	for _, f := range n.forks {
+		f.Node.codec = c
+	}
+	return nil
+}
+
+// sharedLoads is the state of the concurrent lookups started from one node.
+// Loads of the same node are run once by group, and mu guards the load
+// indexes, which lookups pass down to the forks and back up the path.
+type sharedLoads struct {
+	group singleflight.Group
+	mu    sync.Mutex
+}
+
+// sharedLoadsMu guards the creation of the shared loads of a node.
+var sharedLoadsMu sync.Mutex
+
+// sharedLoads returns the state shared by the lookups started from n.
+func (n *Node) sharedLoads() *sharedLoads {
+	sharedLoadsMu.Lock()
+	defer sharedLoadsMu.Unlock()
+	if n.shared == nil {
+		n.shared = &sharedLoads{}
+	}
+	return n.shared
+}
+
+// loadShared loads n unless it is loaded already. Concurrent calls on the same
+// node share a single decode, and the loaded forks are published to all of
+// them when it completes, so read-only lookups may run concurrently on one
+// trie. The data is fetched with loadRef, shared with the loads of other nodes
+// holding the same reference. A load that fails on the context or load budget
+// of another caller is retried rather than failing the callers that waited for
+// it.
+func (n *Node) loadShared(ctx context.Context, s *sharedLoads, l Loader) error {
+	for {
+		if atomic.LoadUint32(&n.loaded) == 1 {
+			return nil
+		}
+		ran := false
+		_, err, _ := s.group.Do(fmt.Sprintf("%p", n), func() (interface{}, error) {
+			ran = true
+			if n.forks == nil && n.ref != nil {
+				if l == nil {
+					return nil, ErrNoLoader
+				}
+				s.mu.Lock()
+				index := n.index
+				s.mu.Unlock()
+				b, err := loadRef(ctx, l, n.ref, index)
+				if err != nil {
+					return nil, err
+				}
+				s.mu.Lock()
+				err = n.decode(b)
+				s.mu.Unlock()
+				if err != nil {
+					return nil, err
+				}
+			}
+			atomic.StoreUint32(&n.loaded, 1)
+			return nil, nil
+		})
+		if err == nil || ran || !callerError(err) {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// refLoad is a Load call in flight, shared by the callers of loadRef.
+type refLoad struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// refLoadKey identifies the loads that are shared: those of one reference
+// through one Loader.
+type refLoadKey struct {
+	l   Loader
+	ref string
+}
+
+var (
+	refLoadsMu sync.Mutex
+	refLoads   = make(map[refLoadKey]*refLoad)
+)
+
+// loadRef loads ref with l. Concurrent calls for the same reference through
+// the same Loader share a single Load, whichever nodes or tries they load,
+// unless the Loader is not comparable. The callers that waited get a copy of
+// the data, and retry if the shared Load failed on the context or load budget
+// of the caller that ran it.
+func loadRef(ctx context.Context, l Loader, ref []byte, index int64) ([]byte, error) {
+	if !reflect.TypeOf(l).Comparable() {
+		return l.Load(ctx, ref, index)
+	}
+	key := refLoadKey{l: l, ref: string(ref)}
+	for {
+		refLoadsMu.Lock()
+		c, ok := refLoads[key]
+		if !ok {
+			c = &refLoad{done: make(chan struct{})}
+			refLoads[key] = c
+			refLoadsMu.Unlock()
+			c.data, c.err = l.Load(ctx, ref, index)
+			refLoadsMu.Lock()
+			delete(refLoads, key)
+			refLoadsMu.Unlock()
+			close(c.done)
+			return c.data, c.err
+		}
+		refLoadsMu.Unlock()
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if c.err == nil {
+			return copyBytes(c.data), nil
+		}
+		if !callerError(c.err) {
+			return nil, c.err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// callerError reports whether err stems from the context or load budget of
+// the caller rather than from the node being loaded.
+func callerError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrLoadBudgetExceeded)
 }
 
 // Save persists a trie recursively  traversing the nodes
@@ -60,6 +218,12 @@ func (n *Node) Save(ctx context.Context, s Saver) error {
 }
 
 func (n *Node) save(ctx context.Context, s Saver) error {
+	return n.saveTree(ctx, s, n.persistCodec())
+}
+
+// saveTree saves the node after its forks with codec c, or the native
+// format if c is nil.
+func (n *Node) saveTree(ctx context.Context, s Saver, c Codec) error {
 	if n != nil && n.ref != nil {
 		return nil
 	}
@@ -72,20 +236,235 @@ func (n *Node) save(ctx context.Context, s Saver) error {
 	for _, f := range n.forks {
 		f := f
 		eg.Go(func() error {
-			return f.Node.save(ectx, s)
+			return f.Node.saveTree(ectx, s, c)
 		})
 	}
 	if err := eg.Wait(); err != nil {
 		return err
 	}
-	bytes, err := n.MarshalBinary()
+	return n.saveNode(ctx, s, c)
+}
+
+// saveNode saves the node itself, whose forks must already be saved, with
+// codec c, or the native format if c is nil.
+func (n *Node) saveNode(ctx context.Context, s Saver, c Codec) error {
+	var data []byte
+	var err error
+	if c == nil {
+		data, err = n.MarshalBinary()
+	} else {
+		data, err = c.MarshalNode(n)
+		n.codec = c
+	}
 	if err != nil {
 		return err
 	}
-	n.ref, err = s.Save(ctx, bytes)
+	n.ref, err = s.Save(ctx, data)
 	if err != nil {
 		return err
 	}
 	n.forks = nil
+	atomic.StoreUint32(&n.loaded, 0)
+	return nil
+}
+
+// SaveConcurrent persists the trie like Save with a pool of parallelism
+// worker goroutines, each saving a node once all of its forks are saved. It
+// returns the reference of the root. The first error stops the workers.
+func (n *Node) SaveConcurrent(ctx context.Context, ls LoadSaver, parallelism int) ([]byte, error) {
+	if ls == nil {
+		return nil, ErrNoSaver
+	}
+	if n.ref != nil {
+		return n.ref, nil
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	c := n.persistCodec()
+
+	// the nodes ready to be saved are queued without blocking, since the
+	// queue holds every node at most once
+	var tasks []*saveTask
+	root := collectSaveTasks(n, nil, &tasks)
+	ready := make(chan *saveTask, len(tasks))
+	for _, t := range tasks {
+		if t.pending == 0 {
+			ready <- t
+		}
+	}
+	done := make(chan struct{})
+
+	eg, ectx := errgroup.WithContext(ctx)
+	for i := 0; i < parallelism; i++ {
+		eg.Go(func() error {
+			for {
+				select {
+				case <-done:
+					return nil
+				case <-ectx.Done():
+					return ectx.Err()
+				case t := <-ready:
+					if err := t.node.saveNode(ectx, ls, c); err != nil {
+						return err
+					}
+					if t == root {
+						close(done)
+						return nil
+					}
+					if atomic.AddInt32(&t.parent.pending, -1) == 0 {
+						ready <- t.parent
+					}
+				}
+			}
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return n.ref, nil
+}
+
+// saveTask is a node to be saved by SaveConcurrent once pending, the number
+// of its unsaved forks, drops to zero.
+type saveTask struct {
+	node    *Node
+	parent  *saveTask
+	pending int32
+}
+
+// collectSaveTasks appends a task for n and every unsaved node below it to
+// tasks and returns the task of n.
+func collectSaveTasks(n *Node, parent *saveTask, tasks *[]*saveTask) *saveTask {
+	t := &saveTask{node: n, parent: parent}
+	*tasks = append(*tasks, t)
+	for _, f := range n.forks {
+		if f.Node.ref == nil {
+			t.pending++
+			collectSaveTasks(f.Node, t, tasks)
+		}
+	}
+	return t
+}
+
+// IsFullyLoaded returns false if any node reachable from n is only known by
+// its reference and would require a Loader to be accessed.
+func (n *Node) IsFullyLoaded() bool {
+	if n.forks == nil {
+		return n.ref == nil
+	}
+	for _, f := range n.forks {
+		if !f.Node.IsFullyLoaded() {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadAll loads every node reachable from n into memory.
+func (n *Node) LoadAll(ctx context.Context, l Loader) error {
+	return walkNode(ctx, []byte{}, l, n, func(_ []byte, _ *Node, _ error) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		return nil
+	})
+}
+
+// Evict returns the nodes deeper than keepDepth below n to reference-only
+// state, so that they are loaded again on demand, and reports how many
+// subtrees were dropped. The root is at depth zero. Only persisted nodes are
+// evicted; a node changed since it was last saved has lost its reference,
+// and so have all of its ancestors, so unsaved changes are never dropped.
+func (n *Node) Evict(ctx context.Context, keepDepth int) (evicted int) {
+	return n.evict(ctx, 0, keepDepth)
+}
+
+func (n *Node) evict(ctx context.Context, depth, keepDepth int) (evicted int) {
+	select {
+	case <-ctx.Done():
+		return 0
+	default:
+	}
+	if n.forks == nil {
+		return 0
+	}
+	if depth > keepDepth && n.ref != nil {
+		n.forks = nil
+		atomic.StoreUint32(&n.loaded, 0)
+		return 1
+	}
+	for _, f := range n.forks {
+		evicted += f.Node.evict(ctx, depth+1, keepDepth)
+	}
+	return evicted
+}
+
+// LoadBounded loads at most maxNodes nodes reachable from n, breadth first,
+// and reports how many were loaded and whether the whole trie is now in
+// memory. Nodes already in memory do not count against the budget.
+func (n *Node) LoadBounded(ctx context.Context, l Loader, maxNodes int) (loaded int, complete bool, err error) {
+	queue := []*Node{n}
+	for len(queue) > 0 {
+		select {
+		case <-ctx.Done():
+			return loaded, false, ctx.Err()
+		default:
+		}
+		node := queue[0]
+		if node.forks == nil {
+			if loaded >= maxNodes {
+				return loaded, false, nil
+			}
+			if err := node.load(ctx, l); err != nil {
+				return loaded, false, err
+			}
+			loaded++
+		}
+		queue = queue[1:]
+		for _, k := range sortedForkKeys(node) {
+			queue = append(queue, node.forks[k].Node)
+		}
+	}
+	return loaded, true, nil
+}
+
+// VerifyManifest loads the manifest with reference ref and checks that every
+// expected path resolves to a value node. The error names all missing paths
+// and wraps ErrNotFound.
+func VerifyManifest(ctx context.Context, ref []byte, expected [][]byte, l Loader) error {
+	n := NewNodeRef(ref)
+	if err := n.load(ctx, l); err != nil {
+		return err
+	}
+	var missing [][]byte
+	for _, path := range expected {
+		node, err := n.LookupNode(ctx, path, l)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if node == nil || !node.IsValueType() {
+			missing = append(missing, path)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("manifest %x: missing paths '%s': %w", ref, bytes.Join(missing, []byte("', '")), ErrNotFound)
+	}
 	return nil
 }
+
+// DetectEncryption loads only the root node with reference ref and reports
+// whether the manifest is encrypted, meaning it stores references longer
+// than 32 bytes or its root is obfuscated with a non-zero key, along with
+// the reference size it stores. The reference size is zero for a manifest
+// without entries.
+func DetectEncryption(ctx context.Context, ref []byte, l Loader) (encrypted bool, refSize int, err error) {
+	n := NewNodeRef(ref)
+	if err := n.load(ctx, l); err != nil {
+		return false, 0, err
+	}
+	encrypted = n.refBytesSize > 32 || !bytes.Equal(n.obfuscationKey, zero32)
+	return encrypted, n.refBytesSize, nil
+}
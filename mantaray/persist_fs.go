@@ -0,0 +1,77 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+type fsLoadSaver struct {
+	dir string
+}
+
+// NewFSLoadSaver returns a LoadSaver that stores each node as a file in dir
+// named by the hex encoding of its reference. The directory is created if
+// it does not exist.
+func NewFSLoadSaver(dir string) (LoadSaver, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &fsLoadSaver{dir: dir}, nil
+}
+
+func (s *fsLoadSaver) Save(ctx context.Context, data []byte) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	sum := sha256.Sum256(data)
+	ref := sum[:]
+	name := filepath.Join(s.dir, hex.EncodeToString(ref))
+	if _, err := os.Stat(name); err == nil {
+		// content addressed, already stored
+		return ref, nil
+	}
+	// write to a temporary file and rename so that concurrent readers
+	// never observe a partially written node
+	f, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return ref, nil
+}
+
+func (s *fsLoadSaver) Load(ctx context.Context, reference []byte, _ int64) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, hex.EncodeToString(reference)))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("reference '%x': %w", reference, ErrNotFound)
+	}
+	return data, err
+}
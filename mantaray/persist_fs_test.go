@@ -0,0 +1,71 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestFSLoadSaver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mantaray-fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	paths := [][]byte{
+		[]byte("index.html"),
+		[]byte("img/1.png"),
+		[]byte("img/2/test1.png"),
+		[]byte("img/2/test2.png"),
+		[]byte("robots.txt"),
+	}
+
+	ls, err := mantaray.NewFSLoadSaver(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := mantaray.New()
+	for _, c := range paths {
+		e := entry(string(c))
+		err := n.Add(ctx, c, e, nil, ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	err = n.Save(ctx, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ls2, err := mantaray.NewFSLoadSaver(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2 := mantaray.NewNodeRef(n.Reference())
+	for _, c := range paths {
+		m, err := n2.Lookup(ctx, c, ls2)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		e := entry(string(c))
+		if !bytes.Equal(m, e) {
+			t.Fatalf("expected value %x, got %x", e, m)
+		}
+	}
+
+	_, err = ls2.Load(ctx, make([]byte, 32), 0)
+	if !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
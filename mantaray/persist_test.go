@@ -8,8 +8,13 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/FavorLabs/manifest/mantaray"
 )
@@ -97,3 +102,515 @@ func (m *mockLoadSaver) Load(_ context.Context, ab []byte, _ int64) ([]byte, err
 	}
 	return b, nil
 }
+
+func TestLoadAll(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	paths := [][]byte{
+		[]byte("index.html"),
+		[]byte("img/1.png"),
+		[]byte("img/2.png"),
+		[]byte("robots.txt"),
+	}
+	for _, c := range paths {
+		e := entry(string(c))
+		err := n.Add(ctx, c, e, nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if !n.IsFullyLoaded() {
+		t.Fatal("expected in-memory manifest to be fully loaded")
+	}
+
+	ls := newMockLoadSaver()
+	err := n.Save(ctx, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2 := mantaray.NewNodeRef(n.Reference())
+	if n2.IsFullyLoaded() {
+		t.Fatal("expected reference manifest not to be fully loaded")
+	}
+
+	// partially loaded
+	_, err = n2.Lookup(ctx, []byte("img/1.png"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n2.IsFullyLoaded() {
+		t.Fatal("expected partially loaded manifest not to be fully loaded")
+	}
+
+	err = n2.LoadAll(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !n2.IsFullyLoaded() {
+		t.Fatal("expected manifest to be fully loaded")
+	}
+	for _, c := range paths {
+		_, err := n2.Lookup(ctx, c, nil)
+		if err != nil {
+			t.Fatalf("expected no error without loader, got %v", err)
+		}
+	}
+}
+
+func TestEvict(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "img/1.png", "img/2.png", "index.html", "robots.txt")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	n = mantaray.NewNodeRef(n.Reference())
+	if err := n.LoadAll(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// unsaved changes are kept
+	if err := n.Add(ctx, []byte("img/3.png"), bytes.Repeat([]byte{1}, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if evicted := n.Evict(ctx, 0); evicted == 0 {
+		t.Fatal("expected nodes to be evicted")
+	}
+	if n.IsFullyLoaded() {
+		t.Fatal("expected manifest not to be fully loaded")
+	}
+	if _, err := n.Lookup(ctx, []byte("img/3.png"), nil); err != nil {
+		t.Fatalf("expected unsaved path without loader, got %v", err)
+	}
+	if _, err := n.Lookup(ctx, []byte("robots.txt"), nil); !errors.Is(err, mantaray.ErrNoLoader) {
+		t.Fatalf("expected no loader error, got %v", err)
+	}
+
+	// evicted paths load on demand
+	cl := &countingLoader{LoadSaver: ls}
+	for _, c := range []string{"img/1.png", "robots.txt"} {
+		m, err := n.Lookup(ctx, []byte(c), cl)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if e := entry(c); !bytes.Equal(m, e) {
+			t.Fatalf("expected value %x, got %x", e, m)
+		}
+	}
+	if cl.loads == 0 {
+		t.Fatal("expected evicted nodes to be loaded")
+	}
+}
+
+type slowSaver struct {
+	*mockLoadSaver
+	latency time.Duration
+	fail    bool
+}
+
+func (s *slowSaver) Save(ctx context.Context, b []byte) ([]byte, error) {
+	time.Sleep(s.latency)
+	if s.fail {
+		return nil, errSaveFailed
+	}
+	return s.mockLoadSaver.Save(ctx, b)
+}
+
+var errSaveFailed = errors.New("save failed")
+
+func TestLoadBounded(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := mantaray.New()
+	for i := 0; i < 200; i++ {
+		c := []byte(fmt.Sprintf("dir%d/file%d.txt", i%10, i))
+		e := entry(string(c))
+		if err := n.Add(ctx, c, e, nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	n2 := mantaray.NewNodeRef(n.Reference())
+	loaded, complete, err := n2.LoadBounded(ctx, ls, 10)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if complete {
+		t.Fatal("expected partial load")
+	}
+	if loaded != 10 {
+		t.Fatalf("expected 10 nodes loaded, got %d", loaded)
+	}
+	if n2.IsFullyLoaded() {
+		t.Fatal("expected manifest not to be fully loaded")
+	}
+
+	// continue where the previous call stopped
+	loaded, complete, err = n2.LoadBounded(ctx, ls, 10000)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !complete {
+		t.Fatal("expected complete load")
+	}
+	if loaded == 0 || loaded >= 10000 {
+		t.Fatalf("expected remaining nodes loaded, got %d", loaded)
+	}
+	if !n2.IsFullyLoaded() {
+		t.Fatal("expected manifest to be fully loaded")
+	}
+}
+
+// inFlightSaver records the highest number of concurrent Save calls.
+type inFlightSaver struct {
+	*mockLoadSaver
+	mtx      sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (s *inFlightSaver) Save(ctx context.Context, b []byte) ([]byte, error) {
+	s.mtx.Lock()
+	s.inFlight++
+	if s.inFlight > s.max {
+		s.max = s.inFlight
+	}
+	s.mtx.Unlock()
+	defer func() {
+		s.mtx.Lock()
+		s.inFlight--
+		s.mtx.Unlock()
+	}()
+	time.Sleep(100 * time.Microsecond)
+	return s.mockLoadSaver.Save(ctx, b)
+}
+
+func buildConcurrentSaveTree(t testing.TB, ctx context.Context) *mantaray.Node {
+	n := mantaray.New()
+	n.SetObfuscationKey(bytes.Repeat([]byte{1}, 32))
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 8; j++ {
+			c := []byte(fmt.Sprintf("dir%d/sub%d/file.txt", i, j))
+			e := entry(string(c))
+			err := n.Add(ctx, c, e, nil, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+	}
+	return n
+}
+
+func TestSaveConcurrent(t *testing.T) {
+	ctx := context.Background()
+
+	serial := buildConcurrentSaveTree(t, ctx)
+	err := serial.Save(ctx, newMockLoadSaver())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ls := newMockLoadSaver()
+	concurrent := buildConcurrentSaveTree(t, ctx)
+	ref, err := concurrent.SaveConcurrent(ctx, ls, 4)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(ref, serial.Reference()) {
+		t.Fatalf("expected reference %x, got %x", serial.Reference(), ref)
+	}
+
+	n := mantaray.NewNodeRef(ref)
+	_, err = n.Lookup(ctx, []byte("dir7/sub7/file.txt"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	failing := buildConcurrentSaveTree(t, ctx)
+	_, err = failing.SaveConcurrent(ctx, &slowSaver{mockLoadSaver: newMockLoadSaver(), fail: true}, 4)
+	if !errors.Is(err, errSaveFailed) {
+		t.Fatalf("expected save failed error, got %v", err)
+	}
+
+	// parallelism bounds the Saver calls in flight
+	is := &inFlightSaver{mockLoadSaver: newMockLoadSaver()}
+	bounded := buildConcurrentSaveTree(t, ctx)
+	if _, err := bounded.SaveConcurrent(ctx, is, 2); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if is.max > 2 {
+		t.Fatalf("expected at most 2 concurrent saves, got %d", is.max)
+	}
+}
+
+// refCountingLoader counts the loads of every reference.
+type refCountingLoader struct {
+	*mockLoadSaver
+	latency time.Duration
+	mtx     sync.Mutex
+	loads   map[string]int
+}
+
+func (r *refCountingLoader) Load(ctx context.Context, ref []byte, index int64) ([]byte, error) {
+	r.mtx.Lock()
+	r.loads[string(ref)]++
+	r.mtx.Unlock()
+	time.Sleep(r.latency)
+	return r.mockLoadSaver.Load(ctx, ref, index)
+}
+
+func TestConcurrentLookup(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := buildConcurrentSaveTree(t, ctx)
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// lookups from separate roots share the loads of the same references
+	l := &refCountingLoader{mockLoadSaver: ls, latency: 5 * time.Millisecond, loads: make(map[string]int)}
+	roots := []*mantaray.Node{mantaray.NewNodeRef(n.Reference()), mantaray.NewNodeRef(n.Reference())}
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 8; j++ {
+			c := []byte(fmt.Sprintf("dir%d/sub%d/file.txt", i%2, j%2))
+			n := roots[(i+j)%2]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				e, err := n.Lookup(ctx, c, l)
+				if err == nil && !bytes.Equal(e, entry(string(c))) {
+					err = fmt.Errorf("path %s: unexpected entry %x", c, e)
+				}
+				errs <- err
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if len(l.loads) == 0 {
+		t.Fatal("expected loads")
+	}
+	for ref, count := range l.loads {
+		if count != 1 {
+			t.Fatalf("expected reference %x to be loaded once, got %d", ref, count)
+		}
+	}
+}
+
+// indexLoader records the index of every load.
+type indexLoader struct {
+	mantaray.LoadSaver
+	indexes []int64
+}
+
+func (l *indexLoader) Load(ctx context.Context, ref []byte, index int64) ([]byte, error) {
+	l.indexes = append(l.indexes, index)
+	return l.LoadSaver.Load(ctx, ref, index)
+}
+
+func TestLookupIndex(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "a/x", "a/b/x", "a/b/c/x", "a/b/c/d/x", "a/b/c/d/y")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// the index is passed down to every loaded fork and back up the path
+	n = mantaray.NewNodeRef(n.Reference())
+	for _, tc := range []struct {
+		path    string
+		indexes []int64
+		index   int64
+	}{
+		{path: "a/b/c/d/x", indexes: []int64{0, 1, 2, 3, 4, 5}, index: 6},
+		{path: "a/b/c/d/y", indexes: []int64{6}, index: 7},
+		{path: "a/b/x", indexes: []int64{7}, index: 8},
+	} {
+		l := &indexLoader{LoadSaver: ls}
+		if _, err := n.Lookup(ctx, []byte(tc.path), l); err != nil {
+			t.Fatalf("path %s: expected no error, got %v", tc.path, err)
+		}
+		if !reflect.DeepEqual(l.indexes, tc.indexes) {
+			t.Fatalf("path %s: expected load indexes %v, got %v", tc.path, tc.indexes, l.indexes)
+		}
+		if n.Index() != tc.index {
+			t.Fatalf("path %s: expected index %d, got %d", tc.path, tc.index, n.Index())
+		}
+	}
+}
+
+// blockingLoader fails its loads with err once released.
+type blockingLoader struct {
+	mantaray.LoadSaver
+	started chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func (b *blockingLoader) Load(ctx context.Context, ref []byte, index int64) ([]byte, error) {
+	close(b.started)
+	<-b.release
+	return nil, b.err
+}
+
+func TestConcurrentLookupCallerError(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "img/1.png", "index.html")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, failure := range []error{context.Canceled, mantaray.ErrLoadBudgetExceeded} {
+		t.Run(failure.Error(), func(t *testing.T) {
+			n := mantaray.NewNodeRef(n.Reference())
+			first := &blockingLoader{LoadSaver: ls, started: make(chan struct{}), release: make(chan struct{}), err: failure}
+			errs := make(chan error, 1)
+			go func() {
+				_, err := n.Lookup(ctx, []byte("index.html"), first)
+				errs <- err
+			}()
+			<-first.started
+
+			// a lookup waiting for the failing load retries it on its own
+			done := make(chan error, 1)
+			go func() {
+				_, err := n.Lookup(ctx, []byte("img/1.png"), ls)
+				done <- err
+			}()
+			time.Sleep(10 * time.Millisecond)
+			close(first.release)
+
+			if err := <-errs; !errors.Is(err, failure) {
+				t.Fatalf("expected error %v, got %v", failure, err)
+			}
+			if err := <-done; err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func BenchmarkSaveConcurrent(b *testing.B) {
+	ctx := context.Background()
+	for _, parallelism := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("parallelism-%d", parallelism), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				n := buildConcurrentSaveTree(b, ctx)
+				ls := &slowSaver{mockLoadSaver: newMockLoadSaver(), latency: time.Millisecond}
+				b.StartTimer()
+				if _, err := n.SaveConcurrent(ctx, ls, parallelism); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "img/1.png", "index.html")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	incomplete := n.Reference()
+	if err := n.Add(ctx, []byte("robots.txt"), bytes.Repeat([]byte{1}, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := [][]byte{[]byte("img/1.png"), []byte("index.html"), []byte("robots.txt")}
+
+	if err := mantaray.VerifyManifest(ctx, n.Reference(), expected, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	err := mantaray.VerifyManifest(ctx, incomplete, append(expected, []byte("img/")), ls)
+	if !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	for _, path := range []string{"'robots.txt'", "'img/'"} {
+		if !strings.Contains(err.Error(), path) {
+			t.Fatalf("expected error to name %s, got %v", path, err)
+		}
+	}
+	if strings.Contains(err.Error(), "'index.html'") {
+		t.Fatalf("expected error not to name index.html, got %v", err)
+	}
+
+	tampered := append([]byte{}, n.Reference()...)
+	tampered[0] ^= 0xff
+	if err := mantaray.VerifyManifest(ctx, tampered, expected, ls); err == nil {
+		t.Fatal("expected error for tampered reference")
+	}
+}
+
+// encryptedRefSaver returns 64 byte references like an encrypting store,
+// padding the address with a decryption key.
+type encryptedRefSaver struct {
+	*mockLoadSaver
+}
+
+func (s *encryptedRefSaver) Save(ctx context.Context, b []byte) ([]byte, error) {
+	ref, err := s.mockLoadSaver.Save(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	return append(ref, bytes.Repeat([]byte{0xee}, 32)...), nil
+}
+
+func TestDetectEncryption(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name      string
+		key       []byte
+		entrySize int
+		encrypted bool
+	}{
+		{name: "plain", key: mantaray.ZeroObfuscationKey, entrySize: 32},
+		{name: "obfuscated", key: bytes.Repeat([]byte{0xab}, 32), entrySize: 32, encrypted: true},
+		{name: "encrypted-references", key: mantaray.ZeroObfuscationKey, entrySize: 64, encrypted: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var ls mantaray.LoadSaver = newMockLoadSaver()
+			if tc.entrySize == 64 {
+				ls = &encryptedRefSaver{ls.(*mockLoadSaver)}
+			}
+			n := mantaray.New()
+			n.SetObfuscationKey(tc.key)
+			for _, c := range []string{"img/1.png", "index.html"} {
+				if err := n.Add(ctx, []byte(c), bytes.Repeat([]byte{1}, tc.entrySize), nil, ls); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+			if err := n.Save(ctx, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			cl := &countingLoader{LoadSaver: ls}
+			encrypted, refSize, err := mantaray.DetectEncryption(ctx, n.Reference(), cl)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if encrypted != tc.encrypted || refSize != tc.entrySize {
+				t.Fatalf("expected encrypted %t with %d byte references, got %t with %d", tc.encrypted, tc.entrySize, encrypted, refSize)
+			}
+			if cl.loads != 1 {
+				t.Fatalf("expected only the root to be loaded, got %d loads", cl.loads)
+			}
+		})
+	}
+}
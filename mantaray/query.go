@@ -0,0 +1,580 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Dirs returns every directory path in the manifest, including the ones
+// implied by file paths, each ending with a separator and sorted.
+func (n *Node) Dirs(ctx context.Context, l Loader) ([][]byte, error) {
+	seen := make(map[string]struct{})
+	err := n.WalkNode(ctx, []byte{}, l, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		// collapsed prefixes may hold several directory levels
+		for i, b := range path {
+			if b == PathSeparator {
+				seen[string(path[:i+1])] = struct{}{}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	dirs := make([][]byte, 0, len(seen))
+	for d := range seen {
+		dirs = append(dirs, []byte(d))
+	}
+	sortPaths(dirs)
+	return dirs, nil
+}
+
+func sortPaths(paths [][]byte) {
+	sort.Slice(paths, func(i, j int) bool {
+		return bytes.Compare(paths[i], paths[j]) < 0
+	})
+}
+
+// Depth returns the number of separators in path if it exists in the
+// manifest, counting every directory level of collapsed prefixes.
+func (n *Node) Depth(ctx context.Context, path []byte, l Loader) (int, error) {
+	_, err := n.LookupNode(ctx, path, l)
+	if errors.Is(err, ErrNotFound) && len(path) > 0 && path[len(path)-1] == PathSeparator {
+		// directory within a collapsed prefix
+		exists, err := n.HasPrefix(ctx, path, l)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, notFound(path)
+		}
+	} else if err != nil {
+		return 0, err
+	}
+	return bytes.Count(path, []byte{PathSeparator}), nil
+}
+
+// References returns the deduplicated references of all saved nodes and the
+// deduplicated entries of all value nodes, in walk order. Together they are
+// the set of chunks the manifest keeps alive.
+func (n *Node) References(ctx context.Context, l Loader) (nodeRefs [][]byte, entryRefs [][]byte, err error) {
+	seenNodes := make(map[string]struct{})
+	seenEntries := make(map[string]struct{})
+	err = n.WalkNode(ctx, []byte{}, l, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if ref := node.Reference(); len(ref) > 0 {
+			if _, ok := seenNodes[string(ref)]; !ok {
+				seenNodes[string(ref)] = struct{}{}
+				nodeRefs = append(nodeRefs, ref)
+			}
+		}
+		if entry := node.Entry(); node.IsValueType() && len(entry) > 0 {
+			if _, ok := seenEntries[string(entry)]; !ok {
+				seenEntries[string(entry)] = struct{}{}
+				entryRefs = append(entryRefs, entry)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return nodeRefs, entryRefs, nil
+}
+
+// MaxPrefixLen returns the length and bytes of the longest fork prefix in
+// the manifest. A length of nodePrefixMaxSize indicates paths being split at
+// the prefix size limit.
+func (n *Node) MaxPrefixLen(ctx context.Context, l Loader) (int, []byte, error) {
+	var longest []byte
+	err := n.WalkNode(ctx, []byte{}, l, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, f := range node.forks {
+			if len(f.prefix) > len(longest) {
+				longest = f.prefix
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return len(longest), append([]byte{}, longest...), nil
+}
+
+// ValidateEntries checks that the entry of every value node is exactly the
+// reference size of the manifest, 32 bytes if not established yet, and
+// returns an ErrInvalidEntrySize error naming the first path that is not.
+func (n *Node) ValidateEntries(ctx context.Context, l Loader) error {
+	if n.forks == nil {
+		if err := n.load(ctx, l); err != nil {
+			return err
+		}
+	}
+	expected := n.refBytesSize
+	if expected == 0 {
+		expected = 32
+	}
+	return n.WalkNode(ctx, []byte{}, l, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if node.IsValueType() && len(node.entry) != expected {
+			return fmt.Errorf("path '%s': entry size %d, expected %d: %w", path, len(node.entry), expected, ErrInvalidEntrySize)
+		}
+		return nil
+	})
+}
+
+// ValidateConnected checks that every node is reachable from n through its
+// forks alone: every fork reference must be found, the fork be stored under
+// the first byte of its prefix and not reference the node itself or one of
+// its ancestors. The error for a broken node names its path and wraps
+// ErrNotConnected. Other load errors, such as those of ctx or the load
+// budget, are returned as they are.
+func (n *Node) ValidateConnected(ctx context.Context, l Loader) error {
+	return n.validateConnected(ctx, []byte{}, make(map[string]struct{}), n.budgeted(l))
+}
+
+// validateConnected checks the subtree of n reached on path, with ancestors
+// holding the references of the nodes above it.
+func (n *Node) validateConnected(ctx context.Context, path []byte, ancestors map[string]struct{}, l Loader) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if n.ref != nil {
+		if _, ok := ancestors[string(n.ref)]; ok {
+			return fmt.Errorf("path '%s': reference %x refers back to an ancestor: %w", path, n.ref, ErrNotConnected)
+		}
+		ancestors[string(n.ref)] = struct{}{}
+		defer delete(ancestors, string(n.ref))
+	}
+	if n.forks == nil {
+		err := n.load(ctx, l)
+		if errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("path '%s': %v: %w", path, err, ErrNotConnected)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, k := range sortedForkKeys(n) {
+		f := n.forks[k]
+		if f == nil || f.Node == nil || len(f.prefix) == 0 || f.prefix[0] != k {
+			return fmt.Errorf("path '%s': fork '%x' detached: %w", path, []byte{k}, ErrNotConnected)
+		}
+		if err := f.Node.validateConnected(ctx, append(copyBytes(path), f.prefix...), ancestors, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompressionStats returns the number of path bytes stored in fork prefixes
+// and the sum of the lengths of all value paths, which is what storing every
+// path separately would take. sharedBytes/rawBytes is the fraction of path
+// bytes the trie keeps.
+func (n *Node) CompressionStats(ctx context.Context, l Loader) (sharedBytes int, rawBytes int, err error) {
+	err = n.WalkNode(ctx, []byte{}, l, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if node.IsValueType() {
+			rawBytes += len(path)
+		}
+		for _, f := range node.forks {
+			sharedBytes += len(f.prefix)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return sharedBytes, rawBytes, nil
+}
+
+// IsEmpty reports whether the manifest holds no forks and no value, loading
+// the node first if it is only known by reference.
+func (n *Node) IsEmpty(ctx context.Context, l Loader) (bool, error) {
+	if n.forks == nil {
+		if err := n.load(ctx, l); err != nil {
+			return false, err
+		}
+	}
+	return len(n.forks) == 0 && !n.IsValueType(), nil
+}
+
+// DirCounts returns the number of immediate children, files and
+// subdirectories, of every directory, including the ones implied by
+// collapsed prefixes. The root directory is keyed by the empty path.
+func (n *Node) DirCounts(ctx context.Context, l Loader) (map[string]int, error) {
+	counts := map[string]int{"": 0}
+	err := n.Walk(ctx, []byte{}, l, func(path []byte, isDir bool, err error) error {
+		if err != nil {
+			return err
+		}
+		if len(path) == 0 {
+			return nil
+		}
+		if isDir {
+			// Walk reports directories without the trailing separator
+			dir := string(path) + string(PathSeparator)
+			if _, ok := counts[dir]; !ok {
+				counts[dir] = 0
+			}
+		}
+		parent := path[:bytes.LastIndexByte(path, PathSeparator)+1]
+		counts[string(parent)]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// SameTarget reports whether pathA and pathB resolve to the same content:
+// value nodes with equal entries, or directory nodes that are the same node
+// or have the same reference.
+func (n *Node) SameTarget(ctx context.Context, pathA, pathB []byte, l Loader) (bool, error) {
+	a, err := n.LookupNode(ctx, pathA, l)
+	if err != nil {
+		return false, err
+	}
+	b, err := n.LookupNode(ctx, pathB, l)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case a.IsValueType() && b.IsValueType():
+		return bytes.Equal(a.entry, b.entry), nil
+	case a.IsValueType() || b.IsValueType():
+		return false, nil
+	case a == b:
+		return true, nil
+	default:
+		return a.ref != nil && bytes.Equal(a.ref, b.ref), nil
+	}
+}
+
+// Skeleton returns every file and directory path in sorted order, without
+// the entries. Directory paths end with a separator.
+func (n *Node) Skeleton(ctx context.Context, l Loader) ([][]byte, error) {
+	var paths [][]byte
+	err := n.Walk(ctx, []byte{}, l, func(path []byte, isDir bool, err error) error {
+		if err != nil {
+			return err
+		}
+		if isDir {
+			// Walk reports directories without the trailing separator
+			path = append(path, PathSeparator)
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortPaths(paths)
+	return paths, nil
+}
+
+// DedupStats returns the number of distinct entries and the total number of
+// value nodes. A low ratio of the two means many paths share content.
+func (n *Node) DedupStats(ctx context.Context, l Loader) (uniqueEntries int, totalValues int, err error) {
+	seen := make(map[string]struct{})
+	err = n.WalkNode(ctx, []byte{}, l, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if !node.IsValueType() {
+			return nil
+		}
+		totalValues++
+		seen[string(node.entry)] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(seen), totalValues, nil
+}
+
+// Files returns the sorted paths of all value nodes under prefix. Unlike
+// Skeleton, directories are left out, including explicitly created empty
+// ones. Only the nodes along prefix and below it are loaded.
+func (n *Node) Files(ctx context.Context, prefix []byte, l Loader) ([][]byte, error) {
+	l = n.budgeted(l)
+	node, rest, err := n.lookupClosest(ctx, prefix, l)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var paths [][]byte
+	root := append(append([]byte{}, prefix...), rest...)
+	err = walkNode(ctx, root, l, node, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if node.IsValueType() && !node.IsEmptyDirectory() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// Count returns the number of files in the manifest, the value paths that are
+// not empty directories. The count of every subtree is cached and kept until
+// the subtree changes, so after an Add or Remove only the changed path is
+// counted again.
+func (n *Node) Count(ctx context.Context, l Loader) (int, error) {
+	count, err := n.countBelow(ctx, l)
+	if err != nil {
+		return 0, err
+	}
+	if n.isFile() {
+		count++
+	}
+	return count, nil
+}
+
+// CachedCount returns the number of files counted by the last Count and
+// whether it is still valid. It is invalid until Count is called and after
+// every change to the manifest.
+func (n *Node) CachedCount() (int, bool) {
+	if !n.countValid {
+		return 0, false
+	}
+	if n.isFile() {
+		return n.count + 1, true
+	}
+	return n.count, true
+}
+
+func (n *Node) isFile() bool {
+	return n.IsValueType() && !n.IsEmptyDirectory()
+}
+
+// countBelow returns the number of files below n. The type of a fork is
+// stored in its parent, so it changes only along with a reborn parent and
+// the cached count excludes n itself.
+func (n *Node) countBelow(ctx context.Context, l Loader) (int, error) {
+	if n.countValid {
+		return n.count, nil
+	}
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, l); err != nil {
+			return 0, err
+		}
+	}
+	count := 0
+	for _, f := range n.forks {
+		c, err := f.Node.countBelow(ctx, l)
+		if err != nil {
+			return 0, err
+		}
+		count += c
+		if f.Node.isFile() {
+			count++
+		}
+	}
+	n.count, n.countValid = count, true
+	return count, nil
+}
+
+// LookupCost returns the number of forks descended to reach the node on path
+// and the number of nodes loaded on the way. Nodes loaded already are not
+// loaded again, so a repeated call reports no loads.
+func (n *Node) LookupCost(ctx context.Context, path []byte, l Loader) (hops int, loads int, err error) {
+	cl := &countLoader{Loader: n.budgeted(l)}
+	spine, err := n.lookupSpine(ctx, path, cl)
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(spine) - 1, cl.loads, nil
+}
+
+// countLoader counts the loads passed on to Loader.
+type countLoader struct {
+	Loader
+	loads int
+}
+
+func (c *countLoader) Load(ctx context.Context, ref []byte, index int64) ([]byte, error) {
+	if c.Loader == nil {
+		return nil, ErrNoLoader
+	}
+	c.loads++
+	return c.Loader.Load(ctx, ref, index)
+}
+
+// FindFirst returns the first value path in sorted order, with its entry,
+// for which pred returns true. The walk stops at the first match, so nodes
+// after it are not loaded. Empty directories are not passed to pred.
+func (n *Node) FindFirst(ctx context.Context, l Loader, pred func(path, entry []byte, meta map[string]string) bool) (path []byte, entry []byte, found bool, err error) {
+	return n.findFirst(ctx, []byte{}, l, pred)
+}
+
+func (n *Node) findFirst(ctx context.Context, path []byte, l Loader, pred func(path, entry []byte, meta map[string]string) bool) ([]byte, []byte, bool, error) {
+	select {
+	case <-ctx.Done():
+		return nil, nil, false, ctx.Err()
+	default:
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, l); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	if n.IsValueType() && !n.IsEmptyDirectory() && pred(copyBytes(path), copyBytes(n.entry), n.Metadata()) {
+		return path, copyBytes(n.entry), true, nil
+	}
+	for _, b := range sortedForkKeys(n) {
+		f := n.forks[b]
+		nextPath := append(path[:0:0], path...)
+		nextPath = append(nextPath, f.prefix...)
+		p, e, found, err := f.Node.findFirst(ctx, nextPath, l, pred)
+		if err != nil || found {
+			return p, e, found, err
+		}
+	}
+	return nil, nil, false, nil
+}
+
+// NameIndex groups the value paths of the manifest by their leaf name, the
+// bytes after the last path separator. The paths of each name are sorted.
+// Empty directories are not indexed.
+func (n *Node) NameIndex(ctx context.Context, l Loader) (map[string][][]byte, error) {
+	index := make(map[string][][]byte)
+	err := n.WalkNode(ctx, []byte{}, l, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if !node.IsValueType() || node.IsEmptyDirectory() {
+			return nil
+		}
+		name := string(path[bytes.LastIndexByte(path, PathSeparator)+1:])
+		index[name] = append(index[name], path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, paths := range index {
+		sortPaths(paths)
+	}
+	return index, nil
+}
+
+// MinPath returns the lexicographically smallest value path, descending
+// along the smallest forks only, or ErrNotFound if there is none.
+func (n *Node) MinPath(ctx context.Context, l Loader) ([]byte, error) {
+	return n.extremePath(ctx, []byte{}, false, l)
+}
+
+// MaxPath returns the lexicographically largest value path, descending
+// along the largest forks only, or ErrNotFound if there is none.
+func (n *Node) MaxPath(ctx context.Context, l Loader) ([]byte, error) {
+	return n.extremePath(ctx, []byte{}, true, l)
+}
+
+// extremePath returns the smallest or, if max is set, the largest value path
+// under n, reached on path. Forks leading to no value, such as empty
+// directories, are backtracked from.
+func (n *Node) extremePath(ctx context.Context, path []byte, max bool, l Loader) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, l); err != nil {
+			return nil, err
+		}
+	}
+	// a value sorts before every path below it
+	if !max && n.IsValueType() && len(path) > 0 {
+		return path, nil
+	}
+	keys := sortedForkKeys(n)
+	for i := range keys {
+		k := keys[i]
+		if max {
+			k = keys[len(keys)-1-i]
+		}
+		f := n.forks[k]
+		p, err := f.Node.extremePath(ctx, append(append([]byte{}, path...), f.prefix...), max, l)
+		if err == nil {
+			return p, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+	}
+	if max && n.IsValueType() && len(path) > 0 {
+		return path, nil
+	}
+	return nil, ErrNotFound
+}
+
+// ExtStat aggregates the value nodes sharing a file extension.
+type ExtStat struct {
+	Count      int // number of files
+	EntryBytes int // summed entry sizes
+}
+
+// ExtensionStats groups the files by extension, the part of the last path
+// segment from its last '.' on, such as ".js". Files without one are counted
+// under the empty string. Values on directory paths are left out.
+func (n *Node) ExtensionStats(ctx context.Context, l Loader) (map[string]ExtStat, error) {
+	stats := make(map[string]ExtStat)
+	err := n.WalkNode(ctx, []byte{}, l, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if !node.IsValueType() || len(path) == 0 || path[len(path)-1] == PathSeparator {
+			return nil
+		}
+		name := path[bytes.LastIndexByte(path, PathSeparator)+1:]
+		var ext string
+		if i := bytes.LastIndexByte(name, '.'); i >= 0 {
+			ext = string(name[i:])
+		}
+		s := stats[ext]
+		s.Count++
+		s.EntryBytes += len(node.entry)
+		stats[ext] = s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
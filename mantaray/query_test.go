@@ -0,0 +1,853 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestDirs(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, c := range [][]byte{
+		[]byte("index.html"),
+		[]byte("img/1.png"),
+		[]byte("img/2/test1.png"),
+		[]byte("img/2/test2.png"),
+		[]byte("robots.txt"),
+		[]byte("a/b/c/d.txt"),
+	} {
+		e := entry(string(c))
+		err := n.Add(ctx, c, e, nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	expected := [][]byte{
+		[]byte("a/"),
+		[]byte("a/b/"),
+		[]byte("a/b/c/"),
+		[]byte("img/"),
+		[]byte("img/2/"),
+	}
+
+	ls := newMockLoadSaver()
+	err := n.Save(ctx, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range []*mantaray.Node{n, mantaray.NewNodeRef(n.Reference())} {
+		dirs, err := node.Dirs(ctx, ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(dirs, expected) {
+			t.Fatalf("expected dirs %s, got %s", expected, dirs)
+		}
+	}
+}
+
+func TestDepth(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name  string
+		toAdd [][]byte
+	}{
+		{
+			name: "collapsed",
+			toAdd: [][]byte{
+				[]byte("a/b/c/d.txt"),
+				[]byte("index.html"),
+			},
+		},
+		{
+			name: "expanded",
+			toAdd: [][]byte{
+				[]byte("a/"),
+				[]byte("a/b/"),
+				[]byte("a/b/c/"),
+				[]byte("a/b/c/d.txt"),
+				[]byte("a/b/c/e.txt"),
+				[]byte("a/x.txt"),
+				[]byte("index.html"),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := mantaray.New()
+			for _, c := range tc.toAdd {
+				e := entry(string(c))
+				err := n.Add(ctx, c, e, nil, nil)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+			for path, expected := range map[string]int{
+				"index.html":  0,
+				"a/":          1,
+				"a/b/":        2,
+				"a/b/c/":      3,
+				"a/b/c/d.txt": 3,
+			} {
+				depth, err := n.Depth(ctx, []byte(path), nil)
+				if err != nil {
+					t.Fatalf("path %s: expected no error, got %v", path, err)
+				}
+				if depth != expected {
+					t.Fatalf("path %s: expected depth %d, got %d", path, expected, depth)
+				}
+			}
+			_, err := n.Depth(ctx, []byte("a/b/missing/"), nil)
+			if !errors.Is(err, mantaray.ErrNotFound) {
+				t.Fatalf("expected not found error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReferences(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := mantaray.New()
+	shared := entry("shared")
+	for _, e := range []mantaray.NodeEntry{
+		{Path: []byte("index.html"), Entry: entry("index.html")},
+		{Path: []byte("img/1.png"), Entry: shared},
+		{Path: []byte("img/2.png"), Entry: shared},
+		{Path: []byte("robots.txt"), Entry: entry("robots.txt")},
+	} {
+		if err := n.Add(ctx, e.Path, e.Entry, nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	nodeRefs, entryRefs, err := mantaray.NewNodeRef(n.Reference()).References(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(nodeRefs) != len(ls.store) {
+		t.Fatalf("expected %d node references, got %d", len(ls.store), len(nodeRefs))
+	}
+	for _, ref := range nodeRefs {
+		var a addr
+		copy(a[:], ref)
+		if _, ok := ls.store[a]; !ok {
+			t.Fatalf("unexpected node reference %x", ref)
+		}
+	}
+
+	expected := [][]byte{
+		shared,
+		entry("index.html"),
+		entry("robots.txt"),
+	}
+	if !reflect.DeepEqual(entryRefs, expected) {
+		t.Fatalf("expected entries %x, got %x", expected, entryRefs)
+	}
+}
+
+func TestMaxPrefixLen(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, c := range [][]byte{
+		[]byte("index.html"),
+		[]byte("a-very-long-file-name-exceeding-limits.png"),
+		[]byte("img/b.png"),
+	} {
+		if err := n.Add(ctx, c, bytes.Repeat([]byte{1}, 32), nil, nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	size, prefix, err := n.MaxPrefixLen(ctx, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := []byte("a-very-long-file-name-exceedin")
+	if size != len(expected) || !bytes.Equal(prefix, expected) {
+		t.Fatalf("expected prefix %q of length %d, got %q of length %d", expected, len(expected), prefix, size)
+	}
+
+	size, prefix, err = mantaray.New().MaxPrefixLen(ctx, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if size != 0 || len(prefix) != 0 {
+		t.Fatalf("expected no prefix, got %q", prefix)
+	}
+}
+
+func TestValidateEntries(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "img/1.png", "index.html")
+	if err := n.ValidateEntries(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := mantaray.NewNodeRef(n.Reference()).ValidateEntries(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// an empty entry passes the size check on add
+	if err := n.Add(ctx, []byte("img/2.png"), nil, nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err := n.ValidateEntries(ctx, ls)
+	if !errors.Is(err, mantaray.ErrInvalidEntrySize) {
+		t.Fatalf("expected invalid entry size error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "img/2.png") {
+		t.Fatalf("expected error to name the path, got %v", err)
+	}
+}
+
+func TestCompressionStats(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls,
+		"assets/images/icons/a.png",
+		"assets/images/icons/b.png",
+		"assets/images/icons/c.png",
+		"assets/images/logo.svg",
+	)
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	shared, raw, err := mantaray.NewNodeRef(n.Reference()).CompressionStats(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// "assets/images/" + "icons/" + 3 * "?.png" + "logo.svg"
+	if shared != 14+6+3*5+8 {
+		t.Fatalf("expected %d shared bytes, got %d", 14+6+3*5+8, shared)
+	}
+	if raw != 3*25+22 {
+		t.Fatalf("expected %d raw bytes, got %d", 3*25+22, raw)
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	n := mantaray.New()
+	empty, err := n.IsEmpty(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !empty {
+		t.Fatal("expected new manifest to be empty")
+	}
+
+	n = newManifest(t, ls, "index.html")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	n = mantaray.NewNodeRef(n.Reference())
+	empty, err = n.IsEmpty(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if empty {
+		t.Fatal("expected single entry manifest not to be empty")
+	}
+
+	if err := n.Remove(ctx, []byte("index.html"), ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	empty, err = n.IsEmpty(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !empty {
+		t.Fatal("expected manifest to be empty after removing its only entry")
+	}
+}
+
+func TestDirCounts(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, pathStrings(spaWebsite)...)
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	counts, err := mantaray.NewNodeRef(n.Reference()).DirCounts(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := map[string]int{
+		"":              6,
+		"assets/":       1,
+		"assets/fonts/": 1,
+		"css/":          1,
+		"img/":          1,
+		"js/":           4,
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("expected %v, got %v", expected, counts)
+	}
+}
+
+func TestSameTarget(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "docs/a.txt", "docs/b.txt", "img/1.png", "img/2.png")
+	if err := n.Link(ctx, []byte("docs/a.txt"), []byte("docs/latest.txt"), ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	n = mantaray.NewNodeRef(n.Reference())
+
+	for _, tc := range []struct {
+		a, b     string
+		expected bool
+	}{
+		{a: "docs/a.txt", b: "docs/latest.txt", expected: true},
+		{a: "docs/a.txt", b: "docs/b.txt", expected: false},
+		{a: "docs/a.txt", b: "docs/a.txt", expected: true},
+		{a: "img/", b: "img/", expected: true},
+		{a: "img/", b: "docs/", expected: false},
+		{a: "img/1.png", b: "img/", expected: false},
+	} {
+		same, err := n.SameTarget(ctx, []byte(tc.a), []byte(tc.b), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if same != tc.expected {
+			t.Fatalf("%s and %s: expected %t, got %t", tc.a, tc.b, tc.expected, same)
+		}
+	}
+
+	_, err := n.SameTarget(ctx, []byte("docs/a.txt"), []byte("docs/missing.txt"), ls)
+	if !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, pathStrings(spaWebsite)...)
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	n = mantaray.NewNodeRef(n.Reference())
+
+	skeleton, err := n.Skeleton(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expected, err := n.Dirs(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = n.WalkNode(ctx, []byte{}, ls, func(path []byte, node *mantaray.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if node.IsValueType() && path[len(path)-1] != mantaray.PathSeparator {
+			expected = append(expected, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		return mantaray.ComparePaths(expected[i], expected[j]) < 0
+	})
+	if !reflect.DeepEqual(skeleton, expected) {
+		t.Fatalf("expected %s, got %s", expected, skeleton)
+	}
+}
+
+func TestDedupStats(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "a.txt", "b.txt")
+	for _, link := range [][2]string{
+		{"a.txt", "copy/a.txt"},
+		{"a.txt", "copy/a2.txt"},
+		{"b.txt", "copy/b.txt"},
+	} {
+		if err := n.Link(ctx, []byte(link[0]), []byte(link[1]), ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	unique, total, err := mantaray.NewNodeRef(n.Reference()).DedupStats(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if unique != 2 || total != 5 {
+		t.Fatalf("expected 2 unique of 5 entries, got %d of %d", unique, total)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "docs/readme.txt", "photos/2020/a.jpg", "photos/2020/b.jpg", "photos/c.jpg")
+	for _, dir := range []string{"photos/2021/", "photos/empty/", "docs/empty/"} {
+		if err := n.Add(ctx, []byte(dir), make([]byte, 32), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	n = mantaray.NewNodeRef(n.Reference())
+
+	for _, tc := range []struct {
+		prefix   string
+		expected [][]byte
+	}{
+		{
+			prefix: "photos/",
+			expected: [][]byte{
+				[]byte("photos/2020/a.jpg"),
+				[]byte("photos/2020/b.jpg"),
+				[]byte("photos/c.jpg"),
+			},
+		},
+		{
+			prefix: "photos/20",
+			expected: [][]byte{
+				[]byte("photos/2020/a.jpg"),
+				[]byte("photos/2020/b.jpg"),
+			},
+		},
+		{
+			prefix: "",
+			expected: [][]byte{
+				[]byte("docs/readme.txt"),
+				[]byte("photos/2020/a.jpg"),
+				[]byte("photos/2020/b.jpg"),
+				[]byte("photos/c.jpg"),
+			},
+		},
+		{
+			prefix: "photos/empty/",
+		},
+		{
+			prefix: "videos/",
+		},
+	} {
+		files, err := n.Files(ctx, []byte(tc.prefix), ls)
+		if err != nil {
+			t.Fatalf("prefix %q: expected no error, got %v", tc.prefix, err)
+		}
+		if !reflect.DeepEqual(files, tc.expected) {
+			t.Fatalf("prefix %q: expected %s, got %s", tc.prefix, tc.expected, files)
+		}
+	}
+
+	// only the nodes along the prefix and below it are loaded
+	cl := &countingLoader{LoadSaver: ls}
+	if _, err := mantaray.NewNodeRef(n.Reference()).Files(ctx, []byte("docs/"), cl); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cl.loads != 4 {
+		t.Fatalf("expected 4 loads, got %d", cl.loads)
+	}
+}
+
+func TestCachedCount(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "img/1.png", "img/2.png", "img/icons/a.svg", "index.html", "robots.txt")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	n = mantaray.NewNodeRef(n.Reference())
+	if _, ok := n.CachedCount(); ok {
+		t.Fatal("expected no cached count before Count")
+	}
+
+	cl := &countingLoader{LoadSaver: ls}
+	count, err := n.Count(ctx, cl)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if count != 5 {
+		t.Fatalf("expected count 5, got %d", count)
+	}
+	loads := cl.loads
+	if _, err := n.Count(ctx, cl); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cl.loads != loads {
+		t.Fatalf("expected cached count without loads, got %d loads", cl.loads-loads)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func() error
+	}{
+		{
+			name: "add",
+			change: func() error {
+				return n.Add(ctx, []byte("img/3.png"), bytes.Repeat([]byte{3}, 32), nil, ls)
+			},
+		},
+		{
+			name: "overwrite",
+			change: func() error {
+				return n.Add(ctx, []byte("index.html"), bytes.Repeat([]byte{4}, 32), nil, ls)
+			},
+		},
+		{
+			name: "add-empty-dir",
+			change: func() error {
+				return n.Add(ctx, []byte("docs/"), make([]byte, 32), nil, ls)
+			},
+		},
+		{
+			name: "remove-file",
+			change: func() error {
+				return n.Remove(ctx, []byte("robots.txt"), ls)
+			},
+		},
+		{
+			name: "remove-dir",
+			change: func() error {
+				return n.Remove(ctx, []byte("img/icons/"), ls)
+			},
+		},
+		{
+			name: "save",
+			change: func() error {
+				return n.Save(ctx, ls)
+			},
+		},
+	} {
+		if _, ok := n.CachedCount(); !ok {
+			t.Fatalf("%s: expected cached count", tc.name)
+		}
+		if err := tc.change(); err != nil {
+			t.Fatalf("%s: expected no error, got %v", tc.name, err)
+		}
+		if _, ok := n.CachedCount(); ok == (tc.name != "save") {
+			t.Fatalf("%s: expected cached count valid %v", tc.name, !ok)
+		}
+		files, err := n.Files(ctx, nil, ls)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", tc.name, err)
+		}
+		count, err := n.Count(ctx, ls)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", tc.name, err)
+		}
+		if count != len(files) {
+			t.Fatalf("%s: expected count %d, got %d", tc.name, len(files), count)
+		}
+		if cached, ok := n.CachedCount(); !ok || cached != count {
+			t.Fatalf("%s: expected cached count %d, got %d (valid %v)", tc.name, count, cached, ok)
+		}
+	}
+}
+
+func TestLookupCost(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "a/b/c/d.txt", "a/b/c/e.txt", "a/b/x.txt", "a/y.txt", "z.txt")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	n = mantaray.NewNodeRef(n.Reference())
+
+	for _, tc := range []struct {
+		path  string
+		hops  int
+		loads int
+	}{
+		// root, a/, b/, c/ and d.txt
+		{path: "a/b/c/d.txt", hops: 4, loads: 5},
+		// the shared nodes are loaded already
+		{path: "a/b/c/e.txt", hops: 4, loads: 1},
+		{path: "a/b/c/d.txt", hops: 4, loads: 0},
+		{path: "z.txt", hops: 1, loads: 1},
+		{path: "a/b/", hops: 2, loads: 0},
+	} {
+		cl := &countingLoader{LoadSaver: ls}
+		hops, loads, err := n.LookupCost(ctx, []byte(tc.path), cl)
+		if err != nil {
+			t.Fatalf("path %s: expected no error, got %v", tc.path, err)
+		}
+		if hops != tc.hops {
+			t.Fatalf("path %s: expected %d hops, got %d", tc.path, tc.hops, hops)
+		}
+		if loads != tc.loads || cl.loads != int64(tc.loads) {
+			t.Fatalf("path %s: expected %d loads, got %d (loader saw %d)", tc.path, tc.loads, loads, cl.loads)
+		}
+	}
+
+	if _, _, err := n.LookupCost(ctx, []byte("a/b/c/f.txt"), ls); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestFindFirst(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "a/1.txt", "a/2.txt", "b/1.txt", "b/2.txt", "c/1.txt")
+	if err := n.Add(ctx, []byte("a/empty/"), make([]byte, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Add(ctx, []byte("b/3.txt"), bytes.Repeat([]byte{3}, 32), map[string]string{"tag": "x"}, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ref := n.Reference()
+
+	all := &countingLoader{LoadSaver: ls}
+	if _, err := mantaray.NewNodeRef(ref).Files(ctx, nil, all); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	cl := &countingLoader{LoadSaver: ls}
+	var visited []string
+	path, e, found, err := mantaray.NewNodeRef(ref).FindFirst(ctx, cl, func(path, entry []byte, meta map[string]string) bool {
+		visited = append(visited, string(path))
+		return bytes.HasSuffix(path, []byte("2.txt"))
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !found || string(path) != "a/2.txt" {
+		t.Fatalf("expected to find a/2.txt, got %q (found %v)", path, found)
+	}
+	if !bytes.Equal(e, entry("a/2.txt")) {
+		t.Fatalf("expected entry %x, got %x", entry("a/2.txt"), e)
+	}
+	if expected := []string{"a/1.txt", "a/2.txt"}; !reflect.DeepEqual(visited, expected) {
+		t.Fatalf("expected visited %v, got %v", expected, visited)
+	}
+	if cl.loads >= all.loads {
+		t.Fatalf("expected fewer than %d loads, got %d", all.loads, cl.loads)
+	}
+
+	path, _, found, err = mantaray.NewNodeRef(ref).FindFirst(ctx, ls, func(path, entry []byte, meta map[string]string) bool {
+		return meta["tag"] == "x"
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !found || string(path) != "b/3.txt" {
+		t.Fatalf("expected to find b/3.txt, got %q (found %v)", path, found)
+	}
+
+	path, e, found, err = mantaray.NewNodeRef(ref).FindFirst(ctx, ls, func(path, entry []byte, meta map[string]string) bool {
+		return false
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if found || path != nil || e != nil {
+		t.Fatalf("expected no match, got %q", path)
+	}
+}
+
+func TestNameIndex(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "config.json", "web/config.json", "api/v1/config.json", "api/v1/routes.json", "readme")
+	if err := n.Add(ctx, []byte("web/empty/"), make([]byte, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	index, err := mantaray.NewNodeRef(n.Reference()).NameIndex(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := map[string][][]byte{
+		"config.json": {
+			[]byte("api/v1/config.json"),
+			[]byte("config.json"),
+			[]byte("web/config.json"),
+		},
+		"routes.json": {[]byte("api/v1/routes.json")},
+		"readme":      {[]byte("readme")},
+	}
+	if !reflect.DeepEqual(index, expected) {
+		t.Fatalf("expected index %s, got %s", expected, index)
+	}
+}
+
+func TestMinMaxPath(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	n := mantaray.New()
+	if _, err := n.MinPath(ctx, ls); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if _, err := n.MaxPath(ctx, ls); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	n = newManifest(t, ls, "b/1.txt", "b", "img/1.png", "img/2.png", "index.html", "zz/a.txt", "zz/b.txt")
+	for _, dir := range []string{"a/", "zzz/"} {
+		if err := n.Add(ctx, []byte(dir), make([]byte, 32), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	n = mantaray.NewNodeRef(n.Reference())
+
+	min, err := n.MinPath(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(min, []byte("b")) {
+		t.Fatalf("expected min path b, got %s", min)
+	}
+	max, err := n.MaxPath(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(max, []byte("zz/b.txt")) {
+		t.Fatalf("expected max path zz/b.txt, got %s", max)
+	}
+}
+
+func TestValidateConnected(t *testing.T) {
+	ctx := context.Background()
+	save := func(ls *mockLoadSaver) []byte {
+		t.Helper()
+		n := newManifest(t, ls, "img/1.png", "img/2.png", "index.html")
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return n.Reference()
+	}
+	childRef := func(ls *mockLoadSaver, root []byte, path string) []byte {
+		t.Helper()
+		node, err := mantaray.NewNodeRef(root).LookupNode(ctx, []byte(path), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return node.Reference()
+	}
+	var a addr
+
+	t.Run("well-formed", func(t *testing.T) {
+		ls := newMockLoadSaver()
+		n := newManifest(t, ls, "img/1.png", "img/2.png", "index.html")
+		if err := n.ValidateConnected(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		ref := save(ls)
+		if err := mantaray.NewNodeRef(ref).ValidateConnected(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("missing-node", func(t *testing.T) {
+		ls := newMockLoadSaver()
+		ref := save(ls)
+		copy(a[:], childRef(ls, ref, "img/"))
+		delete(ls.store, a)
+		err := mantaray.NewNodeRef(ref).ValidateConnected(ctx, ls)
+		if !errors.Is(err, mantaray.ErrNotConnected) {
+			t.Fatalf("expected not connected error, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "'img/'") {
+			t.Fatalf("expected error to name img/, got %v", err)
+		}
+	})
+
+	t.Run("self-reference", func(t *testing.T) {
+		ls := newMockLoadSaver()
+		ref := save(ls)
+		// the node on img/ is replaced by the root, which forks back to it
+		var root addr
+		copy(root[:], ref)
+		copy(a[:], childRef(ls, ref, "img/"))
+		ls.store[a] = ls.store[root]
+		err := mantaray.NewNodeRef(ref).ValidateConnected(ctx, ls)
+		if !errors.Is(err, mantaray.ErrNotConnected) {
+			t.Fatalf("expected not connected error, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "ancestor") {
+			t.Fatalf("expected error to name the loop, got %v", err)
+		}
+	})
+
+	t.Run("load-error", func(t *testing.T) {
+		ls := newMockLoadSaver()
+		ref := save(ls)
+		fl := &failingLoader{LoadSaver: ls, ref: childRef(ls, ref, "img/")}
+		err := mantaray.NewNodeRef(ref).ValidateConnected(ctx, fl)
+		if !errors.Is(err, errInjected) || errors.Is(err, mantaray.ErrNotConnected) {
+			t.Fatalf("expected injected error, got %v", err)
+		}
+		m := mantaray.NewNodeRef(ref)
+		m.SetOptions(mantaray.Options{MaxLoads: 1})
+		err = m.ValidateConnected(ctx, ls)
+		if !errors.Is(err, mantaray.ErrLoadBudgetExceeded) || errors.Is(err, mantaray.ErrNotConnected) {
+			t.Fatalf("expected load budget error, got %v", err)
+		}
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		err = mantaray.NewNodeRef(ref).ValidateConnected(cctx, ls)
+		if !errors.Is(err, context.Canceled) || errors.Is(err, mantaray.ErrNotConnected) {
+			t.Fatalf("expected context canceled error, got %v", err)
+		}
+	})
+}
+
+func TestExtensionStats(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, append(pathStrings(spaWebsite), "LICENSE")...)
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	stats, err := mantaray.NewNodeRef(n.Reference()).ExtensionStats(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := map[string]mantaray.ExtStat{
+		".woff": {Count: 1, EntryBytes: 32},
+		".css":  {Count: 1, EntryBytes: 32},
+		".ico":  {Count: 1, EntryBytes: 32},
+		".png":  {Count: 1, EntryBytes: 32},
+		".html": {Count: 1, EntryBytes: 32},
+		".map":  {Count: 2, EntryBytes: 64},
+		".js":   {Count: 2, EntryBytes: 64},
+		"":      {Count: 1, EntryBytes: 32},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("expected %v, got %v", expected, stats)
+	}
+}
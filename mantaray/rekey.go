@@ -0,0 +1,120 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// RekeyPrefix sets the obfuscation key of every node under prefix to newKey,
+// leaving nodes outside the prefix untouched. The affected nodes and their
+// ancestors lose their references, so the manifest must be saved again and
+// its root reference changes. ErrNotFound is returned if no node is under
+// prefix.
+func (n *Node) RekeyPrefix(ctx context.Context, prefix, newKey []byte, ls LoadSaver) error {
+	if len(newKey) != nodeObfuscationKeySize {
+		return fmt.Errorf("obfuscation key length %d: %w", len(newKey), ErrInvalidInput)
+	}
+	found, err := n.rekeyPrefix(ctx, []byte{}, prefix, newKey, ls)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return notFound(prefix)
+	}
+	return nil
+}
+
+// rekeyPrefix rekeys n, reached on path, if it is under prefix, and descends
+// into the forks that lead to or are under prefix. It reports whether any
+// node was rekeyed.
+func (n *Node) rekeyPrefix(ctx context.Context, path, prefix, newKey []byte, ls LoadSaver) (bool, error) {
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	default:
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, ls); err != nil {
+			return false, err
+		}
+	}
+	under := bytes.HasPrefix(path, prefix)
+	found := under
+	if under {
+		n.SetObfuscationKey(newKey)
+	}
+	for _, f := range n.forks {
+		childPath := append(append([]byte{}, path...), f.prefix...)
+		if !bytes.HasPrefix(childPath, prefix) && !bytes.HasPrefix(prefix, childPath) {
+			continue
+		}
+		ok, err := f.Node.rekeyPrefix(ctx, childPath, prefix, newKey, ls)
+		if err != nil {
+			return false, err
+		}
+		found = found || ok
+	}
+	if found {
+		n.reborn()
+	}
+	return found, nil
+}
+
+// ObfuscationKeys returns the distinct non-zero obfuscation keys of all nodes
+// in sorted order. A consistently keyed manifest returns at most one key;
+// more reveal nodes left over from a partial rekey.
+func (n *Node) ObfuscationKeys(ctx context.Context, l Loader) ([][]byte, error) {
+	seen := make(map[string]bool)
+	var keys [][]byte
+	err := n.WalkNode(ctx, []byte{}, l, func(_ []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		key := node.obfuscationKey
+		if len(key) == 0 || bytes.Equal(key, zero32) || seen[string(key)] {
+			return nil
+		}
+		seen[string(key)] = true
+		keys = append(keys, copyBytes(key))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortPaths(keys)
+	return keys, nil
+}
+
+// ExportSubtree copies everything under prefix into a new manifest, rebased
+// so that prefix becomes its root, with newKey as the obfuscation key of
+// every node. Only the subtree under prefix is copied and loaded, and empty
+// directories are kept as such. The new manifest is saved and returned with
+// its reference; the node itself is left untouched.
+func (n *Node) ExportSubtree(ctx context.Context, prefix, newKey []byte, ls LoadSaver) (*Node, []byte, error) {
+	if len(newKey) != nodeObfuscationKeySize {
+		return nil, nil, fmt.Errorf("obfuscation key length %d: %w", len(newKey), ErrInvalidInput)
+	}
+	if len(prefix) == 0 {
+		return nil, nil, ErrEmptyPath
+	}
+	forks, err := n.subtreeForks(ctx, prefix, ls)
+	if err != nil {
+		return nil, nil, err
+	}
+	for b, f := range forks {
+		forks[b] = &fork{copyBytes(f.prefix), f.Node.deepCopy()}
+	}
+	exported := newSubtreeRoot(forks, n)
+	if _, err := exported.rekeyPrefix(ctx, []byte{}, []byte{}, newKey, ls); err != nil {
+		return nil, nil, err
+	}
+	if err := exported.Save(ctx, ls); err != nil {
+		return nil, nil, err
+	}
+	return exported, exported.Reference(), nil
+}
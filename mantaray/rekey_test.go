@@ -0,0 +1,238 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestRekeyPrefix(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	paths := [][]byte{
+		[]byte("public/index.html"),
+		[]byte("public/style.css"),
+		[]byte("secret/a.txt"),
+		[]byte("secret/b.txt"),
+		[]byte("secret/deep/c.txt"),
+	}
+	n := mantaray.New()
+	for _, c := range paths {
+		e := entry(string(c))
+		if err := n.Add(ctx, c, e, nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	oldRef := n.Reference()
+
+	n = mantaray.NewNodeRef(oldRef)
+	newKey := bytes.Repeat([]byte{0xab}, 32)
+	if err := n.RekeyPrefix(ctx, []byte("secret/"), newKey[:16], ls); !errors.Is(err, mantaray.ErrInvalidInput) {
+		t.Fatalf("expected invalid input error, got %v", err)
+	}
+	if err := n.RekeyPrefix(ctx, []byte("missing/"), newKey, ls); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if err := n.RekeyPrefix(ctx, []byte("secret/"), newKey, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if bytes.Equal(n.Reference(), oldRef) {
+		t.Fatal("expected root reference to change")
+	}
+
+	n = mantaray.NewNodeRef(n.Reference())
+	for _, c := range paths {
+		m, err := n.Lookup(ctx, c, ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		e := entry(string(c))
+		if !bytes.Equal(m, e) {
+			t.Fatalf("expected value %x, got %x", e, m)
+		}
+	}
+
+	for path, rekeyed := range map[string]bool{
+		"secret/":           true,
+		"secret/deep/c.txt": true,
+		"public/":           false,
+	} {
+		node, err := n.LookupNode(ctx, []byte(path), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		data, err := ls.Load(ctx, node.Reference(), node.Index())
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if got := bytes.Equal(data[:32], newKey); got != rekeyed {
+			t.Fatalf("path %s: expected rekeyed %t, got %t", path, rekeyed, got)
+		}
+	}
+}
+
+func TestObfuscationKeys(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	oldKey := bytes.Repeat([]byte{0x01}, 32)
+	newKey := bytes.Repeat([]byte{0xab}, 32)
+	n := mantaray.New()
+	n.SetObfuscationKey(oldKey)
+	for _, c := range []string{"public/index.html", "public/style.css", "secret/a.txt", "secret/deep/b.txt"} {
+		if err := n.Add(ctx, []byte(c), entry(c), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	keys, err := mantaray.NewNodeRef(n.Reference()).ObfuscationKeys(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if expected := [][]byte{oldKey}; !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected keys %x, got %x", expected, keys)
+	}
+
+	if err := n.RekeyPrefix(ctx, []byte("secret/"), newKey, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	keys, err = mantaray.NewNodeRef(n.Reference()).ObfuscationKeys(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if expected := [][]byte{oldKey, newKey}; !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected keys %x, got %x", expected, keys)
+	}
+
+	zero := mantaray.New()
+	zero.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+	if err := zero.Add(ctx, []byte("a.txt"), bytes.Repeat([]byte{1}, 32), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := zero.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	keys, err = mantaray.NewNodeRef(zero.Reference()).ObfuscationKeys(ctx, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("expected no keys, got %x", keys)
+	}
+}
+
+func TestExportSubtree(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	oldKey := bytes.Repeat([]byte{0x01}, 32)
+	n := mantaray.New()
+	n.SetObfuscationKey(oldKey)
+	paths := []string{"private/key.pem", "public/img/1.png", "public/index.html"}
+	for i := 0; i < 50; i++ {
+		paths = append(paths, fmt.Sprintf("private/dir%02d/a.txt", i))
+	}
+	for _, c := range paths {
+		e := entry(c)
+		if err := n.Add(ctx, []byte(c), e, nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := n.MakeDir(ctx, []byte("public/empty/"), nil, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	oldRef := n.Reference()
+
+	newKey := bytes.Repeat([]byte{0xab}, 32)
+	n = mantaray.NewNodeRef(oldRef)
+	if _, _, err := n.ExportSubtree(ctx, []byte("public/"), newKey[:16], ls); !errors.Is(err, mantaray.ErrInvalidInput) {
+		t.Fatalf("expected invalid input error, got %v", err)
+	}
+	if _, _, err := n.ExportSubtree(ctx, []byte("missing/"), newKey, ls); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	cl := &countingLoader{LoadSaver: ls}
+	n = mantaray.NewNodeRef(oldRef)
+	_, ref, err := n.ExportSubtree(ctx, []byte("public/"), newKey, cl)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// the source spine and every node of the copy, which is rekeyed, but
+	// none of the private/ subtrees
+	if cl.loads > 7 {
+		t.Fatalf("expected only the exported subtree to be loaded, got %d loads", cl.loads)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(n.Reference(), oldRef) {
+		t.Fatal("expected original manifest to be unchanged")
+	}
+
+	// every node must be obfuscated with the expected key
+	checkKey := func(root []byte, key []byte) {
+		t.Helper()
+		err := mantaray.NewNodeRef(root).WalkNode(ctx, []byte{}, ls, func(path []byte, node *mantaray.Node, err error) error {
+			if err != nil {
+				return err
+			}
+			data, err := ls.Load(ctx, node.Reference(), node.Index())
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(data[:32], key) {
+				t.Fatalf("path %s: expected key %x, got %x", path, key, data[:32])
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	checkKey(ref, newKey)
+	checkKey(oldRef, oldKey)
+
+	exported := mantaray.NewNodeRef(ref)
+	for _, c := range []string{"img/1.png", "index.html"} {
+		m, err := exported.Lookup(ctx, []byte(c), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		e := entry("public/" + c)
+		if !bytes.Equal(m, e) {
+			t.Fatalf("expected value %x, got %x", e, m)
+		}
+	}
+	if _, err := exported.Lookup(ctx, []byte("private/key.pem"), ls); !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	m, err := exported.LookupNode(ctx, []byte("empty/"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !m.IsEmptyDirectory() || m.IsValueType() {
+		t.Fatal("expected empty directory to be kept")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// RenameSegment replaces the segment at index at, counted from zero, with to
+// in every value path where that segment equals from, and returns the number
+// of paths renamed. If a renamed path would collide with an existing path or
+// another renamed path, ErrConflict is returned before the manifest is
+// modified. If a renamed path cannot be added, the manifest is left as it
+// was. Directories left empty by the rename are removed.
+func (n *Node) RenameSegment(ctx context.Context, at int, from, to []byte, ls LoadSaver) (renamed int, err error) {
+	if at < 0 || len(from) == 0 || len(to) == 0 {
+		return 0, ErrInvalidInput
+	}
+	sep := []byte{PathSeparator}
+	var (
+		oldPaths [][]byte
+		entries  []NodeEntry
+	)
+	err = n.WalkNode(ctx, []byte{}, ls, func(path []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if !node.IsValueType() {
+			return nil
+		}
+		segments := bytes.Split(path, sep)
+		if at >= len(segments) || !bytes.Equal(segments[at], from) {
+			return nil
+		}
+		segments[at] = to
+		oldPaths = append(oldPaths, path)
+		entries = append(entries, NodeEntry{
+			Path:     bytes.Join(segments, sep),
+			Entry:    append([]byte{}, node.entry...),
+			Metadata: node.metadata,
+		})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	renaming := make(map[string]struct{}, len(oldPaths))
+	for _, p := range oldPaths {
+		renaming[string(p)] = struct{}{}
+	}
+	targets := make(map[string]struct{}, len(entries))
+	for _, e := range entries {
+		if _, ok := targets[string(e.Path)]; ok {
+			return 0, fmt.Errorf("path '%s': %w", e.Path, ErrConflict)
+		}
+		targets[string(e.Path)] = struct{}{}
+		if _, ok := renaming[string(e.Path)]; ok {
+			continue
+		}
+		exists, err := n.Exists(ctx, e.Path, ls)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			return 0, fmt.Errorf("path '%s': %w", e.Path, ErrConflict)
+		}
+	}
+
+	undo := newUndoLog()
+	for i := range oldPaths {
+		undo.recordPath(n, oldPaths[i])
+		undo.recordPath(n, entries[i].Path)
+	}
+	if _, err := n.removePaths(ctx, oldPaths, ls); err != nil {
+		undo.rollback()
+		return 0, err
+	}
+	for _, e := range entries {
+		if err := n.Add(ctx, e.Path, e.Entry, e.Metadata, ls); err != nil {
+			undo.rollback()
+			return 0, err
+		}
+	}
+	return len(entries), nil
+}
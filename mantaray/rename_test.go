@@ -0,0 +1,131 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestRenameSegment(t *testing.T) {
+	paths := []string{
+		"assets/app.js",
+		"assets/img/assets/logo.png",
+		"docs/assets/guide.html",
+		"index.html",
+	}
+	for _, tc := range []struct {
+		name     string
+		at       int
+		from, to string
+		renamed  int
+		expected string
+	}{
+		{
+			name:     "top-level",
+			at:       0,
+			from:     "assets",
+			to:       "static",
+			renamed:  2,
+			expected: "docs/assets/guide.html\nindex.html\nstatic/app.js\nstatic/img/assets/logo.png\n",
+		},
+		{
+			name:     "second-level",
+			at:       1,
+			from:     "assets",
+			to:       "static",
+			renamed:  1,
+			expected: "assets/app.js\nassets/img/assets/logo.png\ndocs/static/guide.html\nindex.html\n",
+		},
+		{
+			name:     "third-level",
+			at:       2,
+			from:     "assets",
+			to:       "a",
+			renamed:  1,
+			expected: "assets/app.js\nassets/img/a/logo.png\ndocs/assets/guide.html\nindex.html\n",
+		},
+		{
+			name:     "file-name",
+			at:       0,
+			from:     "index.html",
+			to:       "home.html",
+			renamed:  1,
+			expected: "assets/app.js\nassets/img/assets/logo.png\ndocs/assets/guide.html\nhome.html\n",
+		},
+		{
+			name:     "no-match",
+			at:       5,
+			from:     "assets",
+			to:       "static",
+			expected: "assets/app.js\nassets/img/assets/logo.png\ndocs/assets/guide.html\nindex.html\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			ls := newMockLoadSaver()
+			n := newManifest(t, ls, paths...)
+			renamed, err := n.RenameSegment(ctx, tc.at, []byte(tc.from), []byte(tc.to), ls)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if renamed != tc.renamed {
+				t.Fatalf("expected %d paths renamed, got %d", tc.renamed, renamed)
+			}
+			var b bytes.Buffer
+			if err := n.WriteListing(ctx, ls, &b, mantaray.ListingOptions{EmptyDirectories: true}); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if b.String() != tc.expected {
+				t.Fatalf("expected listing %q, got %q", tc.expected, b.String())
+			}
+		})
+	}
+
+	t.Run("conflict", func(t *testing.T) {
+		ctx := context.Background()
+		ls := newMockLoadSaver()
+		n := newManifest(t, ls, append([]string{"static/app.js"}, paths...)...)
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		ref := n.Reference()
+		_, err := n.RenameSegment(ctx, 0, []byte("assets"), []byte("static"), ls)
+		if !errors.Is(err, mantaray.ErrConflict) {
+			t.Fatalf("expected conflict error, got %v", err)
+		}
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal(n.Reference(), ref) {
+			t.Fatal("expected manifest to be unchanged")
+		}
+	})
+
+	t.Run("add-error", func(t *testing.T) {
+		ctx := context.Background()
+		ls := newMockLoadSaver()
+		n := newManifest(t, ls, "img/a.png", "img/b.png")
+		n.SetOptions(mantaray.Options{MaxPathLen: 11})
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		ref := n.Reference()
+		_, err := n.RenameSegment(ctx, 0, []byte("img"), []byte("images"), ls)
+		if !errors.Is(err, mantaray.ErrPathTooLong) {
+			t.Fatalf("expected path too long error, got %v", err)
+		}
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal(n.Reference(), ref) {
+			t.Fatal("expected manifest to be unchanged")
+		}
+	})
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// AddStream adds entries received from the channel until it is closed.
+// Paths must arrive in non-decreasing order, otherwise ErrNotSorted is
+// returned. Consecutive paths share an insertion cursor, so each entry is
+// added below the deepest node it has in common with the previous one
+// instead of descending from the root. The first error encountered stops
+// consumption; producers should watch ctx to stop sending.
+func (n *Node) AddStream(ctx context.Context, entries <-chan NodeEntry, ls LoadSaver) error {
+	c := newInsertCursor(n)
+	var last []byte
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-entries:
+			if !ok {
+				return nil
+			}
+			if last != nil && bytes.Compare(e.Path, last) < 0 {
+				return fmt.Errorf("path '%s' after '%s': %w", e.Path, last, ErrNotSorted)
+			}
+			if err := c.add(ctx, e.Path, e.Entry, e.Metadata, ls); err != nil {
+				return err
+			}
+			last = e.Path
+		}
+	}
+}
+
+// insertCursor adds sorted paths to a manifest, keeping the nodes along the
+// last added path so that the next path is added below the deepest of them
+// it shares instead of from the root. Adding a path only modifies nodes
+// below the one it is added to, so the nodes kept above remain in place.
+// The manifest must not be modified by other means while the cursor is used.
+type insertCursor struct {
+	root  *Node
+	last  []byte
+	spine []cursorNode
+}
+
+// cursorNode is a node on the last added path, reached after its first depth
+// bytes.
+type cursorNode struct {
+	node  *Node
+	depth int
+}
+
+func newInsertCursor(root *Node) *insertCursor {
+	return &insertCursor{root: root}
+}
+
+// add adds entry on path like Add does, with path not less than the paths
+// added before.
+func (c *insertCursor) add(ctx context.Context, path, entry []byte, metadata map[string]string, ls LoadSaver) error {
+	if err := c.root.checkPath(path); err != nil {
+		return err
+	}
+	nn, err := newEntryNode(path, entry, metadata)
+	if err != nil {
+		return err
+	}
+	c.root.warnZeroEntryDirs(ctx, path, nn, ls)
+	// the entry size is checked against the whole manifest
+	if err := c.root.fitEntrySize(nn.entry); err != nil {
+		return err
+	}
+
+	// the deepest node strictly above path on the common part; an empty
+	// directory is replaced by its parent when a path is added below it, so
+	// the add has to start above it
+	shared := len(common(c.last, path))
+	i := len(c.spine) - 1
+	for ; i >= 0; i-- {
+		cn := c.spine[i]
+		if cn.depth <= shared && cn.depth < len(path) && !cn.node.IsEmptyDirectory() {
+			break
+		}
+	}
+	if i < 0 {
+		c.spine = append(c.spine[:0], cursorNode{c.root, 0})
+		i = 0
+	}
+	// Add passes the nodes above start too, setting their separator type
+	// from the rest of path
+	for k := 1; k <= i; k++ {
+		c.spine[k].node.updateIsWithPathSeparator(path[c.spine[k-1].depth:])
+	}
+	start := c.spine[i]
+	if err := start.node.addNode(ctx, path[start.depth:], nn, ls); err != nil {
+		// the failed add may have left the nodes below start changed
+		c.spine, c.last = c.spine[:0], nil
+		return err
+	}
+
+	// descend along path from start through the loaded nodes
+	c.spine = c.spine[:i+1]
+	cur := start
+	for cur.depth < len(path) && cur.node.forks != nil {
+		f := cur.node.forks[path[cur.depth]]
+		if f == nil || !bytes.HasPrefix(path[cur.depth:], f.prefix) {
+			break
+		}
+		cur = cursorNode{f.Node, cur.depth + len(f.prefix)}
+		c.spine = append(c.spine, cur)
+	}
+	c.last = path
+	return nil
+}
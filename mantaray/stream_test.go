@@ -0,0 +1,127 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestAddStream(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		toAdd    [][]byte
+		expected error
+	}{
+		{
+			name: "sorted",
+			toAdd: [][]byte{
+				[]byte("img/1.png"),
+				[]byte("img/2.png"),
+				[]byte("index.html"),
+				[]byte("robots.txt"),
+			},
+		},
+		{
+			name: "unsorted",
+			toAdd: [][]byte{
+				[]byte("index.html"),
+				[]byte("img/1.png"),
+			},
+			expected: mantaray.ErrNotSorted,
+		},
+	} {
+		ctx := context.Background()
+		t.Run(tc.name, func(t *testing.T) {
+			n := mantaray.New()
+			entries := make(chan mantaray.NodeEntry, len(tc.toAdd))
+			for _, c := range tc.toAdd {
+				entries <- mantaray.NodeEntry{
+					Path:  c,
+					Entry: entry(string(c)),
+				}
+			}
+			close(entries)
+
+			err := n.AddStream(ctx, entries, nil)
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("expected error %v, got %v", tc.expected, err)
+			}
+			if tc.expected != nil {
+				return
+			}
+			for _, c := range tc.toAdd {
+				m, err := n.Lookup(ctx, c, nil)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				e := entry(string(c))
+				if !bytes.Equal(m, e) {
+					t.Fatalf("expected value %x, got %x", e, m)
+				}
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		n := mantaray.New()
+		entries := make(chan mantaray.NodeEntry)
+		err := n.AddStream(ctx, entries, nil)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context canceled, got %v", err)
+		}
+	})
+}
+
+func TestAddStreamMatchesAdd(t *testing.T) {
+	ctx := context.Background()
+	long := strings.Repeat("x", 100)
+	for _, paths := range [][]string{
+		{"img/1.png", "img/2.png", "img/2.png", "index.html", "robots.txt"},
+		{"ab/x", "abc", "abd/", "abd/e", "b"},
+		{"a/", "a/b", "a/b/", "a/b/c", "a/bc", "ab"},
+		{"dir/" + long + "/1", "dir/" + long + "/2", "dir/" + long + "x", "dir/z"},
+		{"dir1/di/a/b/x.txt", "dir1/di/a/b/x/cv.txt", "dir1/di/a/c/aaa.txt", "dir1/di/a/caaa.txt", "dir1/di/ab/c.txt", "dir1/dx.txt"},
+	} {
+		entry := func(c string) []byte {
+			if c[len(c)-1] == '/' {
+				return make([]byte, 32)
+			}
+			return append(make([]byte, 32-len(c)%32), c[:len(c)%32]...)
+		}
+		ls := newMockLoadSaver()
+		expected := mantaray.New()
+		expected.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+		entries := make(chan mantaray.NodeEntry, len(paths))
+		for _, c := range paths {
+			if err := expected.Add(ctx, []byte(c), entry(c), nil, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			entries <- mantaray.NodeEntry{Path: []byte(c), Entry: entry(c)}
+		}
+		close(entries)
+		n := mantaray.New()
+		n.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+		if err := n.AddStream(ctx, entries, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		for _, m := range []*mantaray.Node{expected, n} {
+			if err := m.Save(ctx, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if !bytes.Equal(n.Reference(), expected.Reference()) {
+			t.Fatalf("paths %q: expected reference %x, got %x", paths, expected.Reference(), n.Reference())
+		}
+	}
+}
@@ -0,0 +1,458 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// removeSubtree detaches every path starting with prefix, clearing the
+// references along the way.
+func (n *Node) removeSubtree(ctx context.Context, prefix []byte, ls LoadSaver) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if len(prefix) == 0 {
+		return ErrEmptyPath
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, ls); err != nil {
+			return err
+		}
+	}
+	f := n.forks[prefix[0]]
+	if f == nil {
+		return notFound(prefix)
+	}
+	if bytes.HasPrefix(f.prefix, prefix) {
+		// every path through the fork starts with prefix
+		delete(n.forks, prefix[0])
+		n.reborn()
+		return nil
+	}
+	if !bytes.HasPrefix(prefix, f.prefix) {
+		return notFound(prefix)
+	}
+	if err := f.Node.removeSubtree(ctx, prefix[len(f.prefix):], ls); err != nil {
+		return descended(err, prefix, f.prefix)
+	}
+	if len(f.forks) == 0 && !f.IsValueType() && !f.IsEmptyDirectory() {
+		delete(n.forks, prefix[0])
+	} else if err := n.mergeFork(ctx, prefix[0], ls); err != nil {
+		return err
+	}
+	n.reborn()
+	return nil
+}
+
+// subtreeForks returns the forks of the subtree strictly under prefix,
+// rebased so that their prefixes start after prefix. The nodes are shared
+// with n, which is not modified. ErrNotFound is returned if nothing is under
+// prefix.
+func (n *Node) subtreeForks(ctx context.Context, prefix []byte, l Loader) (map[byte]*fork, error) {
+	if len(prefix) == 0 {
+		return nil, ErrEmptyPath
+	}
+	cur, rest := n, prefix
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		if cur.forks == nil {
+			if err := cur.load(ctx, l); err != nil {
+				return nil, err
+			}
+		}
+		f := cur.forks[rest[0]]
+		if f == nil {
+			return nil, notFound(prefix)
+		}
+		if len(rest) < len(f.prefix) {
+			// prefix ends within the fork, so everything under the fork is
+			// under prefix
+			if !bytes.HasPrefix(f.prefix, rest) {
+				return nil, notFound(prefix)
+			}
+			p := f.prefix[len(rest):]
+			return map[byte]*fork{p[0]: {p, f.Node}}, nil
+		}
+		if !bytes.HasPrefix(rest, f.prefix) {
+			return nil, notFound(prefix)
+		}
+		cur, rest = f.Node, rest[len(f.prefix):]
+		if len(rest) > 0 {
+			continue
+		}
+		if cur.forks == nil {
+			if err := cur.load(ctx, l); err != nil {
+				return nil, err
+			}
+		}
+		if len(cur.forks) == 0 {
+			return nil, notFound(prefix)
+		}
+		forks := make(map[byte]*fork, len(cur.forks))
+		for k, f := range cur.forks {
+			forks[k] = &fork{f.prefix, f.Node}
+		}
+		return forks, nil
+	}
+}
+
+// detachSubtree removes the subtree strictly under prefix from n and returns
+// its forks rebased like subtreeForks does. A value or empty directory on
+// prefix itself stays in n. Only the nodes along prefix are reborn.
+func (n *Node) detachSubtree(ctx context.Context, prefix []byte, ls LoadSaver) (map[byte]*fork, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if len(prefix) == 0 {
+		return nil, ErrEmptyPath
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, ls); err != nil {
+			return nil, err
+		}
+	}
+	f := n.forks[prefix[0]]
+	if f == nil {
+		return nil, notFound(prefix)
+	}
+	if len(prefix) < len(f.prefix) {
+		if !bytes.HasPrefix(f.prefix, prefix) {
+			return nil, notFound(prefix)
+		}
+		delete(n.forks, prefix[0])
+		n.reborn()
+		p := f.prefix[len(prefix):]
+		return map[byte]*fork{p[0]: {p, f.Node}}, nil
+	}
+	if !bytes.HasPrefix(prefix, f.prefix) {
+		return nil, notFound(prefix)
+	}
+	var forks map[byte]*fork
+	if rest := prefix[len(f.prefix):]; len(rest) > 0 {
+		var err error
+		if forks, err = f.Node.detachSubtree(ctx, rest, ls); err != nil {
+			return nil, descended(err, prefix, f.prefix)
+		}
+	} else {
+		if f.forks == nil {
+			if err := f.load(ctx, ls); err != nil {
+				return nil, err
+			}
+		}
+		if len(f.forks) == 0 {
+			return nil, notFound(prefix)
+		}
+		forks = f.forks
+		f.forks = make(map[byte]*fork)
+		f.makeNotEdge()
+		f.reborn()
+	}
+	if len(f.forks) == 0 && !f.IsValueType() && !f.IsEmptyDirectory() && !f.IsWithMetadataType() {
+		delete(n.forks, prefix[0])
+	} else if err := n.mergeFork(ctx, prefix[0], ls); err != nil {
+		return nil, err
+	}
+	n.reborn()
+	return forks, nil
+}
+
+// newSubtreeRoot returns a manifest root holding forks, as returned by
+// subtreeForks or detachSubtree, with the obfuscation key and entry size of
+// like. The forks must not be shared with another manifest.
+func newSubtreeRoot(forks map[byte]*fork, like *Node) *Node {
+	root := New()
+	if len(like.obfuscationKey) > 0 {
+		root.SetObfuscationKey(like.obfuscationKey)
+	}
+	root.refBytesSize = like.refBytesSize
+	root.forks = forks
+	root.makeEdge()
+	for _, f := range forks {
+		// a fork cut within its prefix may no longer hold a separator
+		f.Node.updateIsWithPathSeparator(f.prefix)
+	}
+	return root
+}
+
+// Split moves everything under prefix into a new manifest, rebased so that
+// prefix becomes its root, and removes it from the node. A value stored on
+// prefix itself stays in the node. The subtree is detached as a whole, so
+// only the nodes along prefix are loaded and reborn, and empty directories
+// are kept as such. If any step fails, the node is left unchanged.
+func (n *Node) Split(ctx context.Context, prefix []byte, ls LoadSaver) (removed *Node, err error) {
+	if len(prefix) == 0 {
+		return nil, ErrEmptyPath
+	}
+	undo := newUndoLog()
+	undo.recordPath(n, prefix)
+	forks, err := n.detachSubtree(ctx, prefix, ls)
+	if err != nil {
+		undo.rollback()
+		return nil, err
+	}
+	return newSubtreeRoot(forks, n), nil
+}
+
+// ImportOptions configures ImportPrefixWithOptions.
+type ImportOptions struct {
+	// Rekey gives the imported entry nodes the obfuscation key of the node.
+	// Otherwise they keep the key they have in source.
+	Rekey bool
+}
+
+// ImportPrefix adds every entry of source under sourcePrefix to the node,
+// rebased under destPrefix, and returns the number of entries imported.
+// The imported entries take the obfuscation key of the node.
+func (n *Node) ImportPrefix(ctx context.Context, source *Node, sourcePrefix, destPrefix []byte, ls LoadSaver) (imported int, err error) {
+	return n.ImportPrefixWithOptions(ctx, source, sourcePrefix, destPrefix, ImportOptions{Rekey: true}, ls)
+}
+
+// ImportPrefixWithOptions is like ImportPrefix, configured by opts. Only the
+// subtree of source under sourcePrefix is walked. Values, empty directories
+// and metadata are preserved, and nodes created along the imported paths
+// take the obfuscation key of the node.
+func (n *Node) ImportPrefixWithOptions(ctx context.Context, source *Node, sourcePrefix, destPrefix []byte, opts ImportOptions, ls LoadSaver) (imported int, err error) {
+	forks, err := source.subtreeForks(ctx, sourcePrefix, ls)
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range forks {
+		err := walkNode(ctx, f.prefix, ls, f.Node, func(path []byte, node *Node, err error) error {
+			if err != nil {
+				return err
+			}
+			if !node.IsValueType() && !node.IsEmptyDirectory() && !node.IsWithMetadataType() {
+				return nil
+			}
+			nn := New()
+			nn.entry = append([]byte{}, node.entry...)
+			nn.nodeType = node.nodeType & (nodeTypeValue | nodeTypeEmptyDirectory | nodeTypeWithMetadata)
+			if len(node.metadata) > 0 {
+				nn.metadata = make(map[string]string, len(node.metadata))
+				for k, v := range node.metadata {
+					nn.metadata[k] = v
+				}
+			}
+			if !opts.Rekey && len(node.obfuscationKey) > 0 {
+				nn.SetObfuscationKey(node.obfuscationKey)
+			}
+			dest := append(append([]byte{}, destPrefix...), path...)
+			if err := n.checkPath(dest); err != nil {
+				return err
+			}
+			if err := n.addNode(ctx, dest, nn, ls); err != nil {
+				return err
+			}
+			imported++
+			return nil
+		})
+		if err != nil {
+			return imported, err
+		}
+	}
+	return imported, nil
+}
+
+// removePaths removes every value path in paths, along with the directories
+// left empty by the removal, and returns the number of paths removed, also
+// when it fails part way. Explicitly created empty directories are kept.
+func (n *Node) removePaths(ctx context.Context, paths [][]byte, ls LoadSaver) (removed int, err error) {
+	before, err := n.emptyDirs(ctx, ls)
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range paths {
+		if err := n.Remove(ctx, p, ls); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	// Remove leaves the directory of a removed path behind
+	after, err := n.emptyDirs(ctx, ls)
+	if err != nil {
+		return removed, err
+	}
+	for dir := range after {
+		if _, ok := before[dir]; ok {
+			continue
+		}
+		if err := n.removeSubtree(ctx, []byte(dir), ls); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// emptyDirs returns the set of empty directory paths.
+func (n *Node) emptyDirs(ctx context.Context, l Loader) (map[string]struct{}, error) {
+	dirs := make(map[string]struct{})
+	err := n.WalkNode(ctx, []byte{}, l, func(p []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if node.IsEmptyDirectory() && len(p) > 0 {
+			dirs[string(p)] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// emptyDirsAround returns the set of empty directory paths on, above or
+// below path. Only the nodes along path and below it are loaded.
+func (n *Node) emptyDirsAround(ctx context.Context, path []byte, l Loader) (map[string]struct{}, error) {
+	l = n.budgeted(l)
+	dirs := make(map[string]struct{})
+	collect := func(p []byte, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if node.IsEmptyDirectory() && len(p) > 0 {
+			dirs[string(p)] = struct{}{}
+		}
+		return nil
+	}
+	cur, prefix, rest := n, []byte{}, path
+	for len(rest) > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		if cur.forks == nil {
+			if err := cur.load(ctx, l); err != nil {
+				return nil, err
+			}
+		}
+		if cur.IsEmptyDirectory() && len(prefix) > 0 {
+			dirs[string(prefix)] = struct{}{}
+		}
+		f := cur.forks[rest[0]]
+		if f == nil || !bytes.HasPrefix(rest, f.prefix) && !bytes.HasPrefix(f.prefix, rest) {
+			return dirs, nil
+		}
+		cur, prefix = f.Node, append(copyBytes(prefix), f.prefix...)
+		if len(rest) <= len(f.prefix) {
+			// path ends within the prefix, so the fork is below path
+			break
+		}
+		rest = rest[len(f.prefix):]
+	}
+	if err := walkNode(ctx, prefix, l, cur, collect); err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// ReplaceSet replaces everything under prefix with entries, whose paths are
+// relative to prefix. The replacement is built apart from the node and then
+// attached under prefix, so only the nodes along prefix are loaded and
+// reborn. Entries are validated before anything is changed, and a failure
+// leaves the node unchanged.
+func (n *Node) ReplaceSet(ctx context.Context, prefix []byte, entries []NodeEntry, ls LoadSaver) error {
+	if len(prefix) == 0 {
+		return ErrEmptyPath
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, ls); err != nil {
+			return err
+		}
+	}
+	size := n.refBytesSize
+	for _, e := range entries {
+		if len(e.Entry) == 0 {
+			continue
+		}
+		if size == 0 {
+			size = len(e.Entry)
+		}
+		if len(e.Entry) != size {
+			return fmt.Errorf("path '%s%s': entry size %d, expected %d: %w", prefix, e.Path, len(e.Entry), size, ErrInvalidEntrySize)
+		}
+	}
+
+	// the replacement is rooted at prefix, with an entry on prefix itself
+	// kept apart from the forks
+	set := New()
+	if len(n.obfuscationKey) > 0 {
+		set.SetObfuscationKey(n.obfuscationKey)
+	}
+	set.refBytesSize = size
+	var own *Node
+	for _, e := range entries {
+		path := append(append([]byte{}, prefix...), e.Path...)
+		if err := n.checkPath(path); err != nil {
+			return err
+		}
+		nn, err := newEntryNode(path, e.Entry, e.Metadata)
+		if err != nil {
+			return err
+		}
+		if len(e.Path) == 0 {
+			own = nn
+			continue
+		}
+		if err := set.addNode(ctx, e.Path, nn, nil); err != nil {
+			return err
+		}
+	}
+
+	undo := newUndoLog()
+	undo.recordPath(n, prefix)
+	err := n.removeSubtree(ctx, prefix, ls)
+	if err == nil || errors.Is(err, ErrNotFound) {
+		err = n.attachSubtree(ctx, prefix, own, set.forks, ls)
+	}
+	if err != nil {
+		undo.rollback()
+		return err
+	}
+	return nil
+}
+
+// attachSubtree adds node, if not nil, on prefix and forks, as returned by
+// subtreeForks or detachSubtree, under it. Nothing may be stored under
+// prefix yet.
+func (n *Node) attachSubtree(ctx context.Context, prefix []byte, node *Node, forks map[byte]*fork, ls LoadSaver) error {
+	if node != nil {
+		if err := n.addNode(ctx, prefix, node, ls); err != nil {
+			return err
+		}
+	}
+	// forks are added in byte order so that the result does not depend on
+	// map iteration
+	keys := make([]byte, 0, len(forks))
+	for b := range forks {
+		keys = append(keys, b)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	for _, b := range keys {
+		f := forks[b]
+		path := append(append([]byte{}, prefix...), f.prefix...)
+		if err := n.addNode(ctx, path, f.Node, ls); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestSplit(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	paths := []string{
+		"index.html",
+		"archive/2019/a.txt",
+		"archive/2019/b.txt",
+		"archive/2020/c.txt",
+		"archive.txt",
+		"archived/d.txt",
+	}
+	for _, c := range paths {
+		e := entry(c)
+		err := n.Add(ctx, []byte(c), e, nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	ls := newMockLoadSaver()
+	err := n.Save(ctx, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n = mantaray.NewNodeRef(n.Reference())
+
+	removed, err := n.Split(ctx, []byte("archive/"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, m := range []*mantaray.Node{n, removed} {
+		err := m.Save(ctx, ls)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	n = mantaray.NewNodeRef(n.Reference())
+	removed = mantaray.NewNodeRef(removed.Reference())
+
+	for _, c := range []string{"2019/a.txt", "2019/b.txt", "2020/c.txt"} {
+		m, err := removed.Lookup(ctx, []byte(c), ls)
+		if err != nil {
+			t.Fatalf("path %s: expected no error, got %v", c, err)
+		}
+		original := "archive/" + c
+		e := entry(original)
+		if !bytes.Equal(m, e) {
+			t.Fatalf("path %s: expected value %x, got %x", c, e, m)
+		}
+		_, err = n.Lookup(ctx, []byte(original), ls)
+		if !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("path %s: expected not found error, got %v", original, err)
+		}
+	}
+	for _, c := range []string{"index.html", "archive.txt", "archived/d.txt"} {
+		_, err := n.Lookup(ctx, []byte(c), ls)
+		if err != nil {
+			t.Fatalf("path %s: expected no error, got %v", c, err)
+		}
+	}
+	exists, err := n.HasPrefix(ctx, []byte("archive/"), ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected prefix to be removed")
+	}
+
+	_, err = n.Split(ctx, []byte("missing/"), ls)
+	if !errors.Is(err, mantaray.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	t.Run("empty-dir", func(t *testing.T) {
+		n := mantaray.New()
+		for _, c := range []string{"site/docs/a/1.txt", "site/docs/a/2.txt", "site/e/", "site/x"} {
+			e := entry(c)
+			if strings.HasSuffix(c, "/") {
+				e = make([]byte, 32)
+			}
+			if err := n.Add(ctx, []byte(c), e, nil, nil); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := n.Remove(ctx, []byte("site/docs/"), nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		ls := newMockLoadSaver()
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatal(err)
+		}
+		n = mantaray.NewNodeRef(n.Reference())
+		files, err := n.Files(ctx, []byte("site/"), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		removed, err := n.Split(ctx, []byte("site/"), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, c := range []string{"docs/", "e/"} {
+			m, err := removed.LookupNode(ctx, []byte(c), ls)
+			if err != nil {
+				t.Fatalf("path %s: expected no error, got %v", c, err)
+			}
+			if !m.IsEmptyDirectory() || m.IsValueType() {
+				t.Fatalf("path %s: expected empty directory to be kept", c)
+			}
+		}
+		got, err := removed.Files(ctx, nil, ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for i := range files {
+			files[i] = bytes.TrimPrefix(files[i], []byte("site/"))
+		}
+		if !reflect.DeepEqual(got, files) {
+			t.Fatalf("expected files %s, got %s", files, got)
+		}
+	})
+
+	t.Run("unrelated-subtrees", func(t *testing.T) {
+		n := mantaray.New()
+		for i := 0; i < 50; i++ {
+			c := fmt.Sprintf("dir%02d/sub/a.txt", i)
+			e := entry(c)
+			if err := n.Add(ctx, []byte(c), e, nil, nil); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		ls := newMockLoadSaver()
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatal(err)
+		}
+		cl := &countingLoader{LoadSaver: ls}
+		n = mantaray.NewNodeRef(n.Reference())
+		if _, err := n.Split(ctx, []byte("dir07/"), cl); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if cl.loads > 3 {
+			t.Fatalf("expected only the spine to be loaded, got %d loads", cl.loads)
+		}
+	})
+}
+
+func TestImportPrefix(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+
+	build := newManifest(t, ls, "dist/app.js", "dist/css/main.css", "src/app.ts", "distance.txt")
+	build.SetObfuscationKey(bytes.Repeat([]byte{1}, 32))
+	meta := map[string]string{"Content-Type": "text/html"}
+	if err := build.Add(ctx, []byte("dist/index.html"), bytes.Repeat([]byte{2}, 32), meta, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := build.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	n := newManifest(t, ls, "index.html")
+	n.SetObfuscationKey(bytes.Repeat([]byte{3}, 32))
+	imported, err := n.ImportPrefix(ctx, mantaray.NewNodeRef(build.Reference()), []byte("dist/"), []byte("release/"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if imported != 3 {
+		t.Fatalf("expected 3 entries imported, got %d", imported)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	n = mantaray.NewNodeRef(n.Reference())
+	for path, origin := range map[string]string{
+		"index.html":           "index.html",
+		"release/app.js":       "dist/app.js",
+		"release/css/main.css": "dist/css/main.css",
+	} {
+		m, err := n.Lookup(ctx, []byte(path), ls)
+		if err != nil {
+			t.Fatalf("path %s: expected no error, got %v", path, err)
+		}
+		e := entry(origin)
+		if !bytes.Equal(m, e) {
+			t.Fatalf("path %s: expected value %x, got %x", path, e, m)
+		}
+	}
+	node, err := n.LookupNode(ctx, []byte("release/index.html"), ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if node.Metadata()["Content-Type"] != "text/html" {
+		t.Fatalf("expected metadata to be preserved, got %v", node.Metadata())
+	}
+	for _, c := range []string{"release/src/app.ts", "src/app.ts", "releaseance.txt"} {
+		_, err := n.Lookup(ctx, []byte(c), ls)
+		if !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("path %s: expected not found error, got %v", c, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		rekey bool
+		keys  int
+	}{
+		{name: "rekey", rekey: true, keys: 1},
+		{name: "keep-keys", rekey: false, keys: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			build := func(key byte, paths ...string) *mantaray.Node {
+				n := mantaray.New()
+				n.SetObfuscationKey(bytes.Repeat([]byte{key}, 32))
+				for _, c := range paths {
+					e := entry(c)
+					if err := n.Add(ctx, []byte(c), e, nil, nil); err != nil {
+						t.Fatalf("expected no error, got %v", err)
+					}
+				}
+				return n
+			}
+			paths := []string{"index.html"}
+			for i := 0; i < 50; i++ {
+				paths = append(paths, fmt.Sprintf("src/dir%02d/a.ts", i))
+			}
+			source := build(1, append(paths, "dist/app.js")...)
+			if err := source.MakeDir(ctx, []byte("dist/empty/"), nil, nil); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := source.Save(ctx, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			n := build(3, "index.html")
+			cl := &countingLoader{LoadSaver: ls}
+			imported, err := n.ImportPrefixWithOptions(ctx, mantaray.NewNodeRef(source.Reference()), []byte("dist/"), []byte("release/"), mantaray.ImportOptions{Rekey: tc.rekey}, cl)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if imported != 2 {
+				t.Fatalf("expected 2 entries imported, got %d", imported)
+			}
+			// only the source root and the subtree are loaded
+			if cl.loads > 4 {
+				t.Fatalf("expected only the imported subtree to be loaded, got %d loads", cl.loads)
+			}
+			if err := n.Save(ctx, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			n = mantaray.NewNodeRef(n.Reference())
+			m, err := n.LookupNode(ctx, []byte("release/empty/"), ls)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !m.IsEmptyDirectory() || m.IsValueType() {
+				t.Fatal("expected empty directory to be kept")
+			}
+			keys, err := n.ObfuscationKeys(ctx, ls)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(keys) != tc.keys {
+				t.Fatalf("expected %d obfuscation keys, got %d", tc.keys, len(keys))
+			}
+		})
+	}
+}
+
+func TestReplaceSet(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "current/app.js", "current/old.js", "current/css/main.css", "index.html", "currently.txt")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ref := n.Reference()
+
+	n = mantaray.NewNodeRef(ref)
+	err := n.ReplaceSet(ctx, []byte("current/"), []mantaray.NodeEntry{
+		{Path: []byte("app.js"), Entry: entry("new/app.js")},
+		{Path: []byte("short.js"), Entry: []byte("short")},
+	}, ls)
+	if !errors.Is(err, mantaray.ErrInvalidEntrySize) {
+		t.Fatalf("expected invalid entry size error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(n.Reference(), ref) {
+		t.Fatal("expected manifest to be unchanged")
+	}
+
+	err = n.ReplaceSet(ctx, []byte("current/"), []mantaray.NodeEntry{
+		{Path: []byte("app.js"), Entry: entry("new/app.js")},
+		{Path: []byte("vendor.js"), Entry: entry("new/vendor.js")},
+	}, ls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	n = mantaray.NewNodeRef(n.Reference())
+	for path, origin := range map[string]string{
+		"current/app.js":    "new/app.js",
+		"current/vendor.js": "new/vendor.js",
+		"index.html":        "index.html",
+		"currently.txt":     "currently.txt",
+	} {
+		m, err := n.Lookup(ctx, []byte(path), ls)
+		if err != nil {
+			t.Fatalf("path %s: expected no error, got %v", path, err)
+		}
+		if !bytes.Equal(m, entry(origin)) {
+			t.Fatalf("path %s: expected value %x, got %x", path, entry(origin), m)
+		}
+	}
+	for _, path := range []string{"current/old.js", "current/css/main.css", "current/css/"} {
+		_, err := n.LookupNode(ctx, []byte(path), ls)
+		if !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("path %s: expected not found error, got %v", path, err)
+		}
+	}
+
+	t.Run("same-as-add", func(t *testing.T) {
+		build := func(paths ...string) *mantaray.Node {
+			n := mantaray.New()
+			n.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+			for _, c := range paths {
+				if err := n.Add(ctx, []byte(c), entry(c), nil, nil); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+			return n
+		}
+		n := build("current/app.js", "current/css/main.css", "currently.txt", "index.html")
+		err := n.ReplaceSet(ctx, []byte("current"), []mantaray.NodeEntry{
+			{Path: []byte(""), Entry: entry("current")},
+			{Path: []byte("/a.js"), Entry: entry("current/a.js")},
+			{Path: []byte("/css/b.css"), Entry: entry("current/css/b.css")},
+			{Path: []byte("ly.txt"), Entry: entry("currently.txt")},
+		}, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		expected := build("current", "current/a.js", "current/css/b.css", "currently.txt", "index.html")
+		for _, m := range []*mantaray.Node{n, expected} {
+			if err := m.Save(ctx, ls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if !bytes.Equal(n.Reference(), expected.Reference()) {
+			t.Fatal("expected the same manifest as built by adding the entries")
+		}
+	})
+
+	t.Run("unrelated-subtrees", func(t *testing.T) {
+		n := mantaray.New()
+		for i := 0; i < 50; i++ {
+			c := fmt.Sprintf("dir%02d/sub/a.txt", i)
+			if err := n.Add(ctx, []byte(c), entry(c), nil, nil); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatal(err)
+		}
+		cl := &countingLoader{LoadSaver: ls}
+		n = mantaray.NewNodeRef(n.Reference())
+		err := n.ReplaceSet(ctx, []byte("dir07/"), []mantaray.NodeEntry{
+			{Path: []byte("b.txt"), Entry: entry("dir07/b.txt")},
+		}, cl)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if cl.loads > 3 {
+			t.Fatalf("expected only the spine to be loaded, got %d loads", cl.loads)
+		}
+	})
+}
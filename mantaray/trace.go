@@ -0,0 +1,100 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// TraceOp is the kind of a traced storage call.
+type TraceOp int
+
+const (
+	TraceLoad TraceOp = iota // Loader.Load call
+	TraceSave                // Saver.Save call
+)
+
+func (o TraceOp) String() string {
+	if o == TraceSave {
+		return "save"
+	}
+	return "load"
+}
+
+// TraceEvent is a single Load or Save call recorded by a tracing LoadSaver.
+type TraceEvent struct {
+	Op        TraceOp
+	Reference string // hex encoded reference
+	Size      int    // bytes loaded or saved
+	Err       error
+}
+
+// Trace accumulates the events of a tracing LoadSaver in call order. It is
+// safe for concurrent use.
+type Trace struct {
+	mtx    sync.Mutex
+	events []TraceEvent
+}
+
+// Events returns a copy of the events recorded so far.
+func (t *Trace) Events() []TraceEvent {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return append([]TraceEvent(nil), t.events...)
+}
+
+// Reset discards the recorded events.
+func (t *Trace) Reset() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.events = nil
+}
+
+// String formats the events one per line.
+func (t *Trace) String() string {
+	var b strings.Builder
+	for _, e := range t.Events() {
+		fmt.Fprintf(&b, "%s %s %d", e.Op, e.Reference, e.Size)
+		if e.Err != nil {
+			fmt.Fprintf(&b, " %v", e.Err)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func (t *Trace) record(e TraceEvent) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.events = append(t.events, e)
+}
+
+// NewTracingLoadSaver wraps inner so that every Load and Save is recorded in
+// the returned Trace.
+func NewTracingLoadSaver(inner LoadSaver) (LoadSaver, *Trace) {
+	t := &Trace{}
+	return &tracingLoadSaver{inner: inner, trace: t}, t
+}
+
+type tracingLoadSaver struct {
+	inner LoadSaver
+	trace *Trace
+}
+
+func (t *tracingLoadSaver) Load(ctx context.Context, ref []byte, index int64) ([]byte, error) {
+	data, err := t.inner.Load(ctx, ref, index)
+	t.trace.record(TraceEvent{Op: TraceLoad, Reference: hex.EncodeToString(ref), Size: len(data), Err: err})
+	return data, err
+}
+
+func (t *tracingLoadSaver) Save(ctx context.Context, data []byte) ([]byte, error) {
+	ref, err := t.inner.Save(ctx, data)
+	t.trace.record(TraceEvent{Op: TraceSave, Reference: hex.EncodeToString(ref), Size: len(data), Err: err})
+	return ref, err
+}
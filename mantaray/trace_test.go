@@ -0,0 +1,60 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestTracingLoadSaver(t *testing.T) {
+	ctx := context.Background()
+	ls, trace := mantaray.NewTracingLoadSaver(newMockLoadSaver())
+	n := newManifest(t, ls, "img/1.png", "img/2.png", "robots.txt")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	events := trace.Events()
+	if len(events) == 0 {
+		t.Fatal("expected save events")
+	}
+	for _, e := range events {
+		if e.Op != mantaray.TraceSave || e.Size == 0 {
+			t.Fatalf("expected non-empty save event, got %v", e)
+		}
+	}
+	if last := events[len(events)-1]; last.Reference != hex.EncodeToString(n.Reference()) {
+		t.Fatalf("expected root to be saved last, got %s", last.Reference)
+	}
+
+	// collect the references on the path of the lookup
+	var expected []string
+	m := mantaray.NewNodeRef(n.Reference())
+	for _, p := range []string{"img/", "img/1.png"} {
+		node, err := m.LookupNode(ctx, []byte(p), ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		expected = append(expected, hex.EncodeToString(node.Reference()))
+	}
+	expected = append([]string{hex.EncodeToString(n.Reference())}, expected...)
+
+	trace.Reset()
+	if _, err := mantaray.NewNodeRef(n.Reference()).Lookup(ctx, []byte("img/1.png"), ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	events = trace.Events()
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d loads, got:\n%s", len(expected), trace)
+	}
+	for i, e := range events {
+		if e.Op != mantaray.TraceLoad || e.Reference != expected[i] || e.Size == 0 || e.Err != nil {
+			t.Fatalf("event %d: expected load of %s, got %v", i, expected[i], e)
+		}
+	}
+}
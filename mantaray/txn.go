@@ -0,0 +1,127 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// ErrTxnDone is returned when a transaction is used after Commit or Abort.
+var ErrTxnDone = errors.New("transaction already committed or aborted")
+
+// Txn buffers mutations of a manifest on a copy of it. The manifest is only
+// changed when the transaction is committed.
+type Txn struct {
+	root *Node
+	work *Node
+	done bool
+}
+
+// Begin starts a transaction on the node.
+func (n *Node) Begin() *Txn {
+	return &Txn{root: n, work: n.deepCopy()}
+}
+
+// Add adds an entry to the path within the transaction.
+func (t *Txn) Add(ctx context.Context, path, entry []byte, metadata map[string]string, ls LoadSaver) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	return t.work.Add(ctx, path, entry, metadata, ls)
+}
+
+// Remove removes a path within the transaction.
+func (t *Txn) Remove(ctx context.Context, path []byte, ls LoadSaver) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	return t.work.Remove(ctx, path, ls)
+}
+
+// Move moves path to newPath within the transaction.
+func (t *Txn) Move(ctx context.Context, path, newPath []byte, create bool, ls LoadSaver) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	return t.work.Move(ctx, t.work, path, newPath, create, ls)
+}
+
+// Commit saves the mutated manifest, replaces the node the transaction was
+// started on with it and returns the new root reference. If saving fails the
+// node is left unchanged and the transaction remains open.
+func (t *Txn) Commit(ctx context.Context, ls LoadSaver) ([]byte, error) {
+	if t.done {
+		return nil, ErrTxnDone
+	}
+	if err := t.work.Save(ctx, ls); err != nil {
+		return nil, err
+	}
+	*t.root = *t.work
+	t.done = true
+	return t.root.ref, nil
+}
+
+// Abort discards the mutations of the transaction.
+func (t *Txn) Abort() {
+	t.work = nil
+	t.done = true
+}
+
+// undoLog keeps the state of the nodes an operation may modify, so that the
+// operation can be rolled back in place if it fails. Adding, removing or
+// detaching a path only modifies the nodes along it and their direct forks,
+// so recording those suffices, at a cost proportional to the spine rather
+// than to the manifest.
+type undoLog struct {
+	saved map[*Node]Node
+}
+
+func newUndoLog() *undoLog {
+	return &undoLog{saved: make(map[*Node]Node)}
+}
+
+// recordPath records n, the loaded nodes along path below it, and their
+// forks.
+func (u *undoLog) recordPath(n *Node, path []byte) {
+	for {
+		u.record(n)
+		for _, f := range n.forks {
+			u.record(f.Node)
+		}
+		if len(path) == 0 || n.forks == nil {
+			return
+		}
+		f := n.forks[path[0]]
+		if f == nil || !bytes.HasPrefix(path, f.prefix) {
+			return
+		}
+		n, path = f.Node, path[len(f.prefix):]
+	}
+}
+
+// record keeps the state of n unless it is already kept.
+func (u *undoLog) record(n *Node) {
+	if _, ok := u.saved[n]; ok {
+		return
+	}
+	s := *n
+	s.entry = copyBytes(n.entry)
+	if n.forks != nil {
+		s.forks = make(map[byte]*fork, len(n.forks))
+		for k, f := range n.forks {
+			s.forks[k] = &fork{f.prefix, f.Node}
+		}
+	}
+	u.saved[n] = s
+}
+
+// rollback restores every recorded node to its recorded state.
+func (u *undoLog) rollback() {
+	for n, s := range u.saved {
+		*n = s
+	}
+}
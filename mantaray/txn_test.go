@@ -0,0 +1,86 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func TestTxn(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "img/1.png", "index.html")
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ref := n.Reference()
+
+	mutate := func(txn *mantaray.Txn) {
+		t.Helper()
+		c := []byte("robots.txt")
+		if err := txn.Add(ctx, c, entry(string(c)), nil, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := txn.Remove(ctx, []byte("index.html"), ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := txn.Move(ctx, []byte("img/1.png"), []byte("img/one.png"), true, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	t.Run("abort", func(t *testing.T) {
+		txn := n.Begin()
+		mutate(txn)
+		txn.Abort()
+		if err := n.Save(ctx, ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal(n.Reference(), ref) {
+			t.Fatal("expected aborted transaction to leave the manifest unchanged")
+		}
+		if _, err := n.Lookup(ctx, []byte("index.html"), ls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := txn.Commit(ctx, ls); !errors.Is(err, mantaray.ErrTxnDone) {
+			t.Fatalf("expected transaction done error, got %v", err)
+		}
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		txn := n.Begin()
+		mutate(txn)
+		if _, err := n.Lookup(ctx, []byte("robots.txt"), ls); !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("expected uncommitted path to be missing, got %v", err)
+		}
+		newRef, err := txn.Commit(ctx, ls)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if bytes.Equal(newRef, ref) || !bytes.Equal(n.Reference(), newRef) {
+			t.Fatalf("expected new root reference, got %x", newRef)
+		}
+		if err := txn.Add(ctx, []byte("x"), nil, nil, ls); !errors.Is(err, mantaray.ErrTxnDone) {
+			t.Fatalf("expected transaction done error, got %v", err)
+		}
+
+		loaded := mantaray.NewNodeRef(newRef)
+		for _, c := range []string{"robots.txt", "img/one.png"} {
+			if _, err := loaded.Lookup(ctx, []byte(c), ls); err != nil {
+				t.Fatalf("path %s: expected no error, got %v", c, err)
+			}
+		}
+		for _, c := range []string{"index.html", "img/1.png"} {
+			if _, err := loaded.Lookup(ctx, []byte(c), ls); !errors.Is(err, mantaray.ErrNotFound) {
+				t.Fatalf("path %s: expected not found error, got %v", c, err)
+			}
+		}
+	})
+}
@@ -4,7 +4,11 @@
 
 package mantaray
 
-import "context"
+import (
+	"bytes"
+	"context"
+	"sort"
+)
 
 // WalkNodeFunc is the type of the function called for each node visited
 // by WalkNode.
@@ -14,6 +18,18 @@ func walkNodeFnCopyBytes(ctx context.Context, path []byte, node *Node, err error
 	return walkFn(append(path[:0:0], path...), node, nil)
 }
 
+// sortedForkKeys returns the fork keys of n in ascending order.
+func sortedForkKeys(n *Node) []byte {
+	keys := make([]byte, 0, len(n.forks))
+	for b := range n.forks {
+		keys = append(keys, b)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
 // walkNode recursively descends path, calling walkFn.
 func walkNode(ctx context.Context, path []byte, l Loader, n *Node, walkFn WalkNodeFunc) error {
 	if n.forks == nil {
@@ -27,7 +43,8 @@ func walkNode(ctx context.Context, path []byte, l Loader, n *Node, walkFn WalkNo
 		return err
 	}
 
-	for _, v := range n.forks {
+	for _, b := range sortedForkKeys(n) {
+		v := n.forks[b]
 		nextPath := append(path[:0:0], path...)
 		nextPath = append(nextPath, v.prefix...)
 
@@ -44,7 +61,8 @@ func walkNode(ctx context.Context, path []byte, l Loader, n *Node, walkFn WalkNo
 // each node in the tree, including root. All errors that arise visiting nodes
 // are filtered by walkFn.
 func (n *Node) WalkNode(ctx context.Context, root []byte, l Loader, walkFn WalkNodeFunc) error {
-	node, err := n.LookupNode(ctx, root, l)
+	l = n.budgeted(l)
+	node, err := n.lookupNodeWithOptions(ctx, root, l)
 	if err != nil {
 		err = walkFn(root, nil, err)
 	} else {
@@ -61,8 +79,9 @@ func walkFnCopyBytes(path []byte, isDir bool, err error, walkFn WalkFunc) error
 	return walkFn(append(path[:0:0], path...), isDir, nil)
 }
 
-// walk recursively descends path, calling walkFn.
-func walk(ctx context.Context, path, prefix []byte, l Loader, n *Node, walkFn WalkFunc) error {
+// walk recursively descends path, calling walkFn. In reverse order the
+// callbacks are the exact reverse of the forward order.
+func walk(ctx context.Context, path, prefix []byte, l Loader, n *Node, reverse bool, walkFn WalkFunc) error {
 	if n.forks == nil {
 		if err := n.load(ctx, l); err != nil {
 			return err
@@ -71,47 +90,147 @@ func walk(ctx context.Context, path, prefix []byte, l Loader, n *Node, walkFn Wa
 
 	nextPath := append(path[:0:0], path...)
 
+	// directories ending within the prefix
+	var dirs [][]byte
 	for i := 0; i < len(prefix); i++ {
 		if prefix[i] == PathSeparator {
 			// path ends with separator
-			err := walkFnCopyBytes(nextPath, true, nil, walkFn)
-			if err != nil {
+			dirs = append(dirs, nextPath)
+		}
+		nextPath = append(nextPath, prefix[i])
+	}
+
+	walkDirs := func() error {
+		for i := range dirs {
+			if reverse {
+				i = len(dirs) - 1 - i
+			}
+			if err := walkFnCopyBytes(dirs[i], true, nil, walkFn); err != nil {
 				return err
 			}
 		}
-		nextPath = append(nextPath, prefix[i])
+		return nil
 	}
 
-	if n.IsValueType() {
+	walkValue := func() error {
+		if !n.IsValueType() || len(nextPath) == 0 {
+			return nil
+		}
 		if nextPath[len(nextPath)-1] == PathSeparator {
 			// path ends with separator; already reported
-		} else {
-			err := walkFnCopyBytes(nextPath, false, nil, walkFn)
-			if err != nil {
-				return err
-			}
+			return nil
 		}
+		return walkFnCopyBytes(nextPath, false, nil, walkFn)
 	}
 
-	if n.IsEdgeType() {
-		for _, v := range n.forks {
-			err := walk(ctx, nextPath, v.prefix, l, v.Node, walkFn)
+	walkForks := func() error {
+		if !n.IsEdgeType() {
+			return nil
+		}
+		keys := sortedForkKeys(n)
+		for i := range keys {
+			if reverse {
+				i = len(keys) - 1 - i
+			}
+			v := n.forks[keys[i]]
+			err := walk(ctx, nextPath, v.prefix, l, v.Node, reverse, walkFn)
 			if err != nil {
 				return err
 			}
 		}
+		return nil
 	}
 
+	steps := []func() error{walkDirs, walkValue, walkForks}
+	for i := range steps {
+		if reverse {
+			i = len(steps) - 1 - i
+		}
+		if err := steps[i](); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// WalkOptions controls the traversal of WalkWithOptions.
+type WalkOptions struct {
+	// Reverse visits paths in descending order, exactly reversing the
+	// forward traversal.
+	Reverse bool
+	// RelativeTo is stripped from the start of every path passed to the
+	// walk function, typically set to the walk root. Paths that do not start
+	// with it, such as directories enclosing it, are passed unchanged.
+	RelativeTo []byte
+}
+
 // Walk walks the node tree structure rooted at root, calling walkFn for
 // each file or directory in the tree, including root. All errors that arise
 // visiting files and directories are filtered by walkFn.
 func (n *Node) Walk(ctx context.Context, root []byte, l Loader, walkFn WalkFunc) error {
-	node, err := n.LookupNode(ctx, root, l)
+	return n.WalkWithOptions(ctx, root, l, WalkOptions{}, walkFn)
+}
+
+// WalkWithOptions is like Walk but allows configuring the traversal.
+func (n *Node) WalkWithOptions(ctx context.Context, root []byte, l Loader, opts WalkOptions, walkFn WalkFunc) error {
+	if len(opts.RelativeTo) > 0 {
+		walkFn = relativeWalkFunc(opts.RelativeTo, walkFn)
+	}
+	l = n.budgeted(l)
+	node, err := n.lookupNodeWithOptions(ctx, root, l)
 	if err != nil {
 		return walkFn(root, false, err)
 	}
-	return walk(ctx, root, []byte{}, l, node, walkFn)
+	return walk(ctx, root, []byte{}, l, node, opts.Reverse, walkFn)
+}
+
+// relativeWalkFunc wraps walkFn to strip base from the paths it receives.
+func relativeWalkFunc(base []byte, walkFn WalkFunc) WalkFunc {
+	return func(path []byte, isDir bool, err error) error {
+		if bytes.HasPrefix(path, base) {
+			path = path[len(base):]
+		}
+		return walkFn(path, isDir, err)
+	}
+}
+
+// Range calls walkFn in sorted order for every value path p with
+// start <= p < end, as ordered by ComparePaths. A nil end means no upper
+// bound. Forks whose paths all lie outside the range are not loaded.
+func (n *Node) Range(ctx context.Context, start, end []byte, l Loader, walkFn WalkFunc) error {
+	return rangeNode(ctx, []byte{}, start, end, n.budgeted(l), n, walkFn)
+}
+
+func rangeNode(ctx context.Context, path, start, end []byte, l Loader, n *Node, walkFn WalkFunc) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if n.forks == nil {
+		if err := n.load(ctx, l); err != nil {
+			return err
+		}
+	}
+	if n.IsValueType() && len(path) > 0 && ComparePaths(path, start) >= 0 && (end == nil || ComparePaths(path, end) < 0) {
+		isDir := path[len(path)-1] == PathSeparator
+		if err := walkFnCopyBytes(path, isDir, nil, walkFn); err != nil {
+			return err
+		}
+	}
+	for _, k := range sortedForkKeys(n) {
+		f := n.forks[k]
+		nextPath := append(append(path[:0:0], path...), f.prefix...)
+		// every path in the fork starts with nextPath
+		if end != nil && ComparePaths(nextPath, end) >= 0 {
+			break
+		}
+		if ComparePaths(nextPath, start) < 0 && !bytes.HasPrefix(start, nextPath) {
+			continue
+		}
+		if err := rangeNode(ctx, nextPath, start, end, l, f.Node, walkFn); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/FavorLabs/manifest/mantaray"
@@ -249,3 +250,138 @@ func TestWalk(t *testing.T) {
 		})
 	}
 }
+
+func TestWalkReverse(t *testing.T) {
+	ctx := context.Background()
+	n := mantaray.New()
+	for _, c := range [][]byte{
+		[]byte("index.html"),
+		[]byte("img/test/"),
+		[]byte("img/test/oho.png"),
+		[]byte("img/test/old/test.png"),
+		[]byte("img/test/old/test.png.backup"),
+		[]byte("robots.txt"),
+		[]byte("a/b/c/d/e.txt"),
+	} {
+		e := entry(string(c))
+		err := n.Add(ctx, c, e, nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	collect := func(opts mantaray.WalkOptions) []string {
+		var paths []string
+		err := n.WalkWithOptions(ctx, []byte{}, nil, opts, func(path []byte, isDir bool, err error) error {
+			if err != nil {
+				return err
+			}
+			paths = append(paths, fmt.Sprintf("%s:%t", path, isDir))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("no error expected, found: %s", err)
+		}
+		return paths
+	}
+
+	forward := collect(mantaray.WalkOptions{})
+	reverse := collect(mantaray.WalkOptions{Reverse: true})
+	if len(forward) != len(reverse) {
+		t.Fatalf("expected %d paths, got %d", len(forward), len(reverse))
+	}
+	for i := range forward {
+		if forward[i] != reverse[len(reverse)-1-i] {
+			t.Fatalf("expected reverse path %s at %d, got %s", forward[i], len(reverse)-1-i, reverse[len(reverse)-1-i])
+		}
+	}
+	if forward[0] != "a:true" || forward[len(forward)-1] != "robots.txt:false" {
+		t.Fatalf("unexpected forward order %v", forward)
+	}
+}
+
+func TestRange(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls,
+		"img/1.png",
+		"img/2/test1.png",
+		"img/2/test2.png",
+		"index.html",
+		"robots.txt",
+		"src/a.go",
+	)
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, tc := range []struct {
+		start, end string
+		unbounded  bool
+		expected   []string
+	}{
+		{start: "img/2", end: "index.html", expected: []string{"img/2/test1.png", "img/2/test2.png"}},
+		{start: "img/2/test15", end: "robots", expected: []string{"img/2/test2.png", "index.html"}},
+		{start: "in", end: "s", expected: []string{"index.html", "robots.txt"}},
+		{start: "", end: "img/"},
+		{start: "img/1.png", end: "img/1.png"},
+		{start: "r", unbounded: true, expected: []string{"robots.txt", "src/a.go"}},
+	} {
+		t.Run(tc.start+"-"+tc.end, func(t *testing.T) {
+			var end []byte
+			if !tc.unbounded {
+				end = []byte(tc.end)
+			}
+			var got []string
+			err := mantaray.NewNodeRef(n.Reference()).Range(ctx, []byte(tc.start), end, ls, func(path []byte, isDir bool, err error) error {
+				if err != nil {
+					return err
+				}
+				got = append(got, string(path))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+
+	t.Run("pruning", func(t *testing.T) {
+		cl := &countingLoader{LoadSaver: ls}
+		err := mantaray.NewNodeRef(n.Reference()).Range(ctx, []byte("s"), nil, cl, func(path []byte, isDir bool, err error) error {
+			return err
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		// the root and the src/a.go node
+		if cl.loads != 2 {
+			t.Fatalf("expected 2 loads, got %d", cl.loads)
+		}
+	})
+}
+
+func TestWalkRelativeTo(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	n := newManifest(t, ls, "img/1.png", "img/2.png", "img/sub/deep/3.png", "index.html")
+
+	var paths []string
+	err := n.WalkWithOptions(ctx, []byte("img/"), ls, mantaray.WalkOptions{RelativeTo: []byte("img/")}, func(path []byte, isDir bool, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, fmt.Sprintf("%s:%t", path, isDir))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// sub/deep/3.png is a single collapsed fork
+	expected := []string{"1.png:false", "2.png:false", "sub:true", "sub/deep:true", "sub/deep/3.png:false"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected %v, got %v", expected, paths)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	// IndexDocumentMetadataKey is the directory metadata key naming the
+	// document served for the directory.
+	IndexDocumentMetadataKey = "index-document"
+	// ErrorDocumentMetadataKey is the directory metadata key naming the
+	// document served for missing paths under the directory.
+	ErrorDocumentMetadataKey = "error-document"
+)
+
+// ResolveWeb resolves path the way a web gateway does: the exact path, then
+// the index document of path as a directory, then the index document of the
+// nearest ancestor directory that has one, then the error document of the
+// nearest ancestor directory that has one. Directories without their own
+// document metadata use the one set on the root path "/". The path the entry
+// was found on is returned along with it.
+func (n *Node) ResolveWeb(ctx context.Context, path []byte, l Loader) (entry []byte, resolvedPath []byte, err error) {
+	if len(path) > 0 {
+		entry, err := n.Lookup(ctx, path, l)
+		if err == nil {
+			return entry, path, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, nil, err
+		}
+	}
+
+	dirs := ancestors(path)
+	if dirPath := withTrailingSlash(path); dirPath != nil {
+		dirs = append([][]byte{dirPath}, dirs...)
+	} else if len(path) > 0 && path[len(path)-1] == PathSeparator {
+		dirs = append([][]byte{path}, dirs...)
+	}
+
+	for _, key := range []string{IndexDocumentMetadataKey, ErrorDocumentMetadataKey} {
+		for _, dir := range dirs {
+			docPath, err := n.webDocument(ctx, dir, key, l)
+			if err != nil {
+				return nil, nil, err
+			}
+			if docPath == nil {
+				continue
+			}
+			entry, err := n.Lookup(ctx, docPath, l)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			return entry, docPath, nil
+		}
+	}
+	return nil, nil, notFound(path)
+}
+
+// webDocument returns the path of the document named by key in the metadata
+// of dir, or of the root path "/" if dir has none, or nil if neither has.
+func (n *Node) webDocument(ctx context.Context, dir []byte, key string, l Loader) ([]byte, error) {
+	root := []byte{PathSeparator}
+	for _, p := range [][]byte{dir, root} {
+		node, err := n.LookupNode(ctx, p, l)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		name, ok := node.metadata[key]
+		if !ok || name == "" {
+			continue
+		}
+		if len(dir) == 1 && dir[0] == PathSeparator {
+			return []byte(name), nil
+		}
+		return append(append([]byte{}, dir...), name...), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mantaray_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FavorLabs/manifest/mantaray"
+)
+
+func newWebManifest(t *testing.T, ls mantaray.LoadSaver, rootMetadata map[string]string, paths ...string) *mantaray.Node {
+	t.Helper()
+	ctx := context.Background()
+	n := newManifest(t, ls, paths...)
+	if err := n.Add(ctx, []byte("/"), make([]byte, 32), rootMetadata, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Save(ctx, ls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return mantaray.NewNodeRef(n.Reference())
+}
+
+func TestResolveWeb(t *testing.T) {
+	ctx := context.Background()
+	ls := newMockLoadSaver()
+	paths := []string{"404.html", "app/index.html", "app/main.js", "docs/guide.html", "index.html"}
+
+	for _, tc := range []struct {
+		name     string
+		metadata map[string]string
+		path     string
+		resolved string
+	}{
+		{name: "exact", path: "app/main.js", resolved: "app/main.js"},
+		{name: "root", path: "", resolved: "index.html"},
+		{name: "directory", path: "app", resolved: "app/index.html"},
+		{name: "directory-slash", path: "app/", resolved: "app/index.html"},
+		{name: "spa-route", path: "app/route/deep", resolved: "app/index.html"},
+		{name: "ancestor-root", path: "docs/missing", resolved: "index.html"},
+		{
+			name:     "error-document",
+			metadata: map[string]string{mantaray.ErrorDocumentMetadataKey: "404.html"},
+			path:     "docs/missing",
+			resolved: "404.html",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			metadata := tc.metadata
+			if metadata == nil {
+				metadata = map[string]string{
+					mantaray.IndexDocumentMetadataKey: "index.html",
+					mantaray.ErrorDocumentMetadataKey: "404.html",
+				}
+			}
+			n := newWebManifest(t, ls, metadata, paths...)
+			e, resolved, err := n.ResolveWeb(ctx, []byte(tc.path), ls)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if string(resolved) != tc.resolved {
+				t.Fatalf("expected resolved path %s, got %s", tc.resolved, resolved)
+			}
+			if !bytes.Equal(e, entry(tc.resolved)) {
+				t.Fatalf("expected value %x, got %x", entry(tc.resolved), e)
+			}
+		})
+	}
+
+	t.Run("not-found", func(t *testing.T) {
+		n := newWebManifest(t, ls, map[string]string{"other": "value"}, paths...)
+		_, _, err := n.ResolveWeb(ctx, []byte("docs/missing"), ls)
+		if !errors.Is(err, mantaray.ErrNotFound) {
+			t.Fatalf("expected not found error, got %v", err)
+		}
+	})
+}